@@ -0,0 +1,83 @@
+// Command secrets manages the master keys that seal stored database
+// credentials.
+//
+//	secrets keygen             print a new master key
+//	secrets migrate [-dry-run] seal plaintext credentials and rewrap values
+//	                           sealed with a previous key
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"db-backup/internal/database"
+	"db-backup/internal/secrets"
+
+	"github.com/joho/godotenv"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "keygen":
+		key, err := secrets.GenerateKey()
+		if err != nil {
+			log.Fatalf("Failed to generate key: %v", err)
+		}
+		fmt.Println(key)
+	case "migrate":
+		migrate(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: secrets keygen | secrets migrate [-dry-run]")
+	os.Exit(2)
+}
+
+// migrate brings every stored credential to the current master key. Run it
+// after setting a key for the first time and after each rotation.
+func migrate(args []string) {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "report how many databases would change without writing")
+	flags.Parse(args)
+
+	if err := godotenv.Load(); err != nil {
+		log.Println("Error loading .env file", err)
+	}
+
+	if err := secrets.Init(); err != nil {
+		log.Fatalf("Invalid secrets configuration: %v", err)
+	}
+	if !secrets.Enabled() {
+		log.Fatal("SECRETS_MASTER_KEY or SECRETS_MASTER_KEY_FILE is required")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	if err := database.Connect(ctx); err != nil {
+		log.Fatalf("Failed to connect to MongoDB: %v", err)
+	}
+	defer database.Disconnect(ctx)
+
+	updated, err := database.NewRepository().ResealDatabaseSecrets(ctx, *dryRun)
+	if err != nil {
+		log.Fatalf("Migration stopped after %d databases: %v", updated, err)
+	}
+
+	if *dryRun {
+		log.Printf("%d databases would be updated", updated)
+	} else {
+		log.Printf("Updated %d databases", updated)
+	}
+}
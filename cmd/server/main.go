@@ -11,8 +11,12 @@ import (
 
 	_ "db-backup/docs" // Import generated docs
 	"db-backup/internal/api"
+	"db-backup/internal/backup"
+	"db-backup/internal/cron"
 	"db-backup/internal/database"
 	"db-backup/internal/scheduler"
+	"db-backup/internal/secrets"
+	"db-backup/internal/storage"
 	"db-backup/internal/worker"
 
 	"github.com/joho/godotenv"
@@ -29,6 +33,11 @@ func main() {
 		log.Println("Error loading .env file", err)
 	}
 
+	// Load the master keys that seal stored credentials
+	if err := secrets.Init(); err != nil {
+		log.Fatalf("Invalid secrets configuration: %v", err)
+	}
+
 	// Initialize MongoDB connection
 	ctx := context.Background()
 	if err := database.Connect(ctx); err != nil {
@@ -48,6 +57,15 @@ func main() {
 		log.Printf("Warning: Worker initialization failed: %v", err)
 	}
 
+	// Report which client tools are available
+	for _, tool := range backup.DetectTools(ctx) {
+		if tool.Found && tool.Error == "" {
+			log.Printf("Found %s: %s (%s)", tool.Name, tool.Version, tool.Path)
+		} else {
+			log.Printf("Warning: %s unavailable: %s", tool.Name, tool.Error)
+		}
+	}
+
 	// Initialize API handlers
 	api.InitializeHandlers()
 
@@ -66,6 +84,11 @@ func main() {
 	// Disabled, since we have capabilities to delete backups
 	// cron.StartCleanupCron()
 
+	// Prune deduplicated chunks that deleted backups no longer reference
+	if pruneStorage, err := storage.NewClient(); err == nil {
+		cron.StartChunkPruneCron(pruneStorage, database.NewRepository())
+	}
+
 	router := api.NewRouter()
 
 	// Create HTTP server
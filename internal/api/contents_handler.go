@@ -0,0 +1,118 @@
+package api
+
+import (
+	"context"
+	"db-backup/internal/backup"
+	"db-backup/internal/model"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HandleGetBackupContents godoc
+// @Summary List the contents of a backup
+// @Description List the tables, collections, Redis keyspaces or directories inside a backup with approximate row counts and sizes, without restoring it
+// @Tags backup
+// @Produce json
+// @Param id path string true "Backup ID"
+// @Param name query string false "Only list items with this name, e.g. orders or public.orders"
+// @Success 200 {object} model.BackupContents "Backup contents"
+// @Failure 400 {object} model.BackupResponse "error: Bad request"
+// @Failure 404 {object} model.BackupResponse "error: Backup not found"
+// @Failure 500 {object} model.BackupResponse "error: Internal server error"
+// @Router /backups/{id}/contents [get]
+func HandleGetBackupContents(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	backupID := chi.URLParam(r, "id")
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Minute)
+	defer cancel()
+
+	record, err := backupRepo.GetBackup(ctx, backupID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Backup not found",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if record.Status != model.StatusCompleted {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Backup is not completed",
+		})
+		return
+	}
+	if record.Children > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Server backups have no artifact of their own; list the contents of a child backup",
+		})
+		return
+	}
+
+	path, cleanup, err := backupFile(ctx, record)
+	if errors.Is(err, errNoBackupFile) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Backup file not found in storage or locally",
+		})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Failed to fetch backup file",
+			Error:   err.Error(),
+		})
+		return
+	}
+	defer cleanup()
+
+	contents, err := backup.ListContents(ctx, model.BackupType(record.Type), path)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Failed to read backup contents",
+			Error:   err.Error(),
+		})
+		return
+	}
+	contents.ID = backupID
+	contents.Type = record.Type
+
+	if name := r.URL.Query().Get("name"); name != "" {
+		contents.Items = filterContentItems(contents.Items, name)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(contents)
+}
+
+// filterContentItems keeps the items called name, matched case-insensitively
+// with or without their schema
+func filterContentItems(items []model.ContentItem, name string) []model.ContentItem {
+	filtered := []model.ContentItem{}
+	for _, item := range items {
+		qualified := item.Name
+		if item.Schema != "" {
+			qualified = item.Schema + "." + item.Name
+		}
+		if strings.EqualFold(item.Name, name) || strings.EqualFold(qualified, name) {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
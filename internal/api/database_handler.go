@@ -2,10 +2,13 @@ package api
 
 import (
 	"context"
+	"db-backup/internal/backup"
 	"db-backup/internal/model"
+	"db-backup/internal/tunnel"
 	"db-backup/internal/worker"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"time"
 
@@ -92,11 +95,58 @@ func HandleCreateDatabase(w http.ResponseWriter, r *http.Request) {
 		Password:       req.Password,
 		Database:       req.Database,
 		ConnectionURI:  req.ConnectionURI,
+		Mode:           req.Mode,
+		Scope:          req.Scope,
+		Split:          req.Split,
+		Physical:       req.Physical,
+		Dedup:          req.Dedup,
+		Options:        req.Options,
+		TLS:            req.TLS,
+		Redis:          req.Redis,
+		Mongo:          req.Mongo,
+		SSH:            req.SSH,
+		Hooks:          req.Hooks,
+		Custom:         req.Custom,
+		Files:          req.Files,
+		Subset:         req.Subset,
+		Docker:         req.Docker,
+		Masking:        req.Masking,
+		Export:         req.Export,
 		CronExpression: req.CronExpression,
 		IsActive:       req.IsActive,
 		WebhookURL:     req.WebhookURL,
 	}
 
+	if err := validateBackupOptions(db.BackupRequest()); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Invalid backup options",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if err := backup.ValidateMaskingProfiles(db.Masking); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Invalid masking profiles",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if err := backup.ValidateExportOptions(db.Type, db.Export); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Invalid export options",
+			Error:   err.Error(),
+		})
+		return
+	}
+
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
@@ -110,8 +160,8 @@ func HandleCreateDatabase(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Schedule job if active and has cron
-	if jobScheduler != nil && db.IsActive && db.CronExpression != "" {
+	// Schedule jobs if active and has a backup or export cron
+	if jobScheduler != nil && db.IsActive && (db.CronExpression != "" || db.Export != nil) {
 		jobScheduler.AddJob(db)
 	}
 
@@ -213,6 +263,18 @@ func HandleUpdateDatabase(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Profiles sent back without their salt keep the one they were saved
+	// with, so masked values stay stable across updates
+	salts := make(map[string]string, len(db.Masking))
+	for _, profile := range db.Masking {
+		salts[profile.Name] = profile.Salt
+	}
+	for i := range req.Masking {
+		if req.Masking[i].Salt == "" {
+			req.Masking[i].Salt = salts[req.Masking[i].Name]
+		}
+	}
+
 	// Update fields
 	db.Name = req.Name
 	db.Type = req.Type
@@ -222,10 +284,57 @@ func HandleUpdateDatabase(w http.ResponseWriter, r *http.Request) {
 	db.Password = req.Password
 	db.Database = req.Database
 	db.ConnectionURI = req.ConnectionURI
+	db.Mode = req.Mode
+	db.Scope = req.Scope
+	db.Split = req.Split
+	db.Physical = req.Physical
+	db.Dedup = req.Dedup
+	db.Options = req.Options
+	db.TLS = req.TLS
+	db.Redis = req.Redis
+	db.Mongo = req.Mongo
+	db.SSH = req.SSH
+	db.Hooks = req.Hooks
+	db.Custom = req.Custom
+	db.Files = req.Files
+	db.Subset = req.Subset
+	db.Docker = req.Docker
+	db.Masking = req.Masking
+	db.Export = req.Export
 	db.CronExpression = req.CronExpression
 	db.IsActive = req.IsActive
 	db.WebhookURL = req.WebhookURL
 
+	if err := validateBackupOptions(db.BackupRequest()); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Invalid backup options",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if err := backup.ValidateMaskingProfiles(db.Masking); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Invalid masking profiles",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if err := backup.ValidateExportOptions(db.Type, db.Export); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Invalid export options",
+			Error:   err.Error(),
+		})
+		return
+	}
+
 	if err := backupRepo.UpdateDatabase(ctx, db); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(model.BackupResponse{
@@ -238,7 +347,7 @@ func HandleUpdateDatabase(w http.ResponseWriter, r *http.Request) {
 
 	// Update scheduler
 	if jobScheduler != nil {
-		if db.IsActive && db.CronExpression != "" {
+		if db.IsActive && (db.CronExpression != "" || db.Export != nil) {
 			jobScheduler.AddJob(db)
 		} else {
 			jobScheduler.RemoveJob(db.ID.Hex())
@@ -337,20 +446,8 @@ func HandleTriggerBackup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Create backup request
-	req := model.BackupRequest{
-		Type:          db.Type,
-		Host:          db.Host,
-		Port:          db.Port,
-		Username:      db.Username,
-		Password:      db.Password,
-		Database:      db.Database,
-		ConnectionURI: db.ConnectionURI,
-		WebhookURL:    db.WebhookURL,
-	}
-
 	// Trigger backup
-	backupID := worker.ProcessBackup(req)
+	backupID := worker.ProcessBackup(db.BackupRequest())
 
 	w.WriteHeader(http.StatusAccepted)
 	json.NewEncoder(w).Encode(model.BackupResponse{
@@ -359,3 +456,318 @@ func HandleTriggerBackup(w http.ResponseWriter, r *http.Request) {
 		ID:      backupID,
 	})
 }
+
+// HandleTestDatabase godoc
+// @Summary Test a saved database connection
+// @Description Connect and authenticate with a saved database configuration and check the account can dump it. Connection failures are reported in the result, not as an error status.
+// @Tags database
+// @Produce json
+// @Param id path string true "Database ID"
+// @Success 200 {object} model.ConnectionTestResult
+// @Failure 400 {object} model.BackupResponse "error: Bad request"
+// @Failure 404 {object} model.BackupResponse "error: Database not found"
+// @Router /databases/{id}/test [post]
+func HandleTestDatabase(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := chi.URLParam(r, "id")
+	if id == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Database ID is required",
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	db, err := backupRepo.GetDatabase(ctx, id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Database not found",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(worker.TestConnection(ctx, db.BackupRequest()))
+}
+
+// HandleTestConnection godoc
+// @Summary Test a database connection
+// @Description Connect and authenticate with ad-hoc connection details and check the account can dump the database. Connection failures are reported in the result, not as an error status.
+// @Tags database
+// @Accept json
+// @Produce json
+// @Param request body model.BackupRequest true "Connection Details"
+// @Success 200 {object} model.ConnectionTestResult
+// @Failure 400 {object} model.BackupResponse "error: Bad request"
+// @Router /databases/test [post]
+func HandleTestConnection(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req model.BackupRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Invalid request body",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if err := validateConnection(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Missing required fields",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if err := validateBackupOptions(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Invalid backup options",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(worker.TestConnection(ctx, req))
+}
+
+// HandleDiscoverDatabases godoc
+// @Summary Discover databases on a server
+// @Description Connect to a server and list its databases, schemas and approximate sizes
+// @Tags database
+// @Accept json
+// @Produce json
+// @Param request body model.DiscoverRequest true "Server Connection"
+// @Success 200 {object} model.DiscoverResponse
+// @Failure 400 {object} model.BackupResponse "error: Bad request"
+// @Failure 502 {object} model.BackupResponse "error: Discovery failed"
+// @Router /databases/discover [post]
+func HandleDiscoverDatabases(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req model.DiscoverRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Invalid request body",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if req.Type == "" || (req.Host == "" && req.ConnectionURI == "") {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Missing required fields",
+			Error:   "type and (host or connectionUri) are required",
+		})
+		return
+	}
+
+	strategy, err := backup.NewStrategy(req.Type)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Unsupported database type",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	discoverer, ok := strategy.(backup.Discoverer)
+	if !ok {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Unsupported database type",
+			Error:   fmt.Sprintf("discovery is not supported for %s", req.Type),
+		})
+		return
+	}
+
+	conn := req.BackupRequest()
+	if conn.SSH != nil {
+		if err := validateSSHTunnel(conn); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(model.BackupResponse{
+				Success: false,
+				Message: "Invalid ssh tunnel",
+				Error:   err.Error(),
+			})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Minute)
+	defer cancel()
+
+	tun, err := tunnel.Open(ctx, conn)
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Failed to open ssh tunnel",
+			Error:   err.Error(),
+		})
+		return
+	}
+	defer tun.Close()
+
+	databases, err := discoverer.Discover(ctx, tun.Rewrite(conn))
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Failed to discover databases",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(model.DiscoverResponse{
+		Databases: databases,
+	})
+}
+
+// HandleImportDatabases godoc
+// @Summary Import discovered databases
+// @Description Save several databases that share one server connection in a single call
+// @Tags database
+// @Accept json
+// @Produce json
+// @Param request body model.ImportDatabasesRequest true "Databases to import"
+// @Success 201 {object} model.DatabaseListResponse
+// @Failure 400 {object} model.BackupResponse "error: Bad request"
+// @Failure 500 {object} model.BackupResponse "error: Internal server error"
+// @Router /databases/import [post]
+func HandleImportDatabases(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	var req model.ImportDatabasesRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Invalid request body",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if req.Type == "" || len(req.Databases) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Missing required fields",
+			Error:   "type and at least one database are required",
+		})
+		return
+	}
+
+	dbs := make([]*model.Database, 0, len(req.Databases))
+	for _, item := range req.Databases {
+		if item.Database == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(model.BackupResponse{
+				Success: false,
+				Message: "Missing required fields",
+				Error:   "every imported database needs a database name",
+			})
+			return
+		}
+
+		name := item.Name
+		if name == "" {
+			name = fmt.Sprintf("%s/%s", req.Host, item.Database)
+		}
+		cronExpression := item.CronExpression
+		if cronExpression == "" {
+			cronExpression = req.CronExpression
+		}
+
+		dbs = append(dbs, &model.Database{
+			Name:           name,
+			Type:           req.Type,
+			Host:           req.Host,
+			Port:           req.Port,
+			Username:       req.Username,
+			Password:       req.Password,
+			Database:       item.Database,
+			ConnectionURI:  req.ConnectionURI,
+			Mode:           req.Mode,
+			Options:        req.Options,
+			TLS:            req.TLS,
+			Redis:          req.Redis,
+			Mongo:          req.Mongo,
+			SSH:            req.SSH,
+			CronExpression: cronExpression,
+			IsActive:       req.IsActive,
+			WebhookURL:     req.WebhookURL,
+		})
+	}
+
+	// Imported databases share their backup options
+	if err := validateBackupOptions(dbs[0].BackupRequest()); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Invalid backup options",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	if err := backupRepo.SaveDatabases(ctx, dbs); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Failed to import databases",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	databases := make([]model.Database, 0, len(dbs))
+	for _, db := range dbs {
+		// Schedule job if active and has cron
+		if jobScheduler != nil && db.IsActive && db.CronExpression != "" {
+			if err := jobScheduler.AddJob(db); err != nil {
+				log.Printf("Failed to schedule job for database %s: %v", db.Name, err)
+			}
+		}
+		databases = append(databases, *db)
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(model.DatabaseListResponse{
+		Databases: databases,
+		Total:     int64(len(databases)),
+		Page:      1,
+		Limit:     len(databases),
+	})
+}
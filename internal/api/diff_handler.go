@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"db-backup/internal/backup"
+	"db-backup/internal/model"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// HandleDiffBackups godoc
+// @Summary Compare the schema of two backups
+// @Description Report tables, columns, indexes and constraints added, removed or changed between two Postgres or MySQL backups, or collections and indexes between two MongoDB backups
+// @Tags backup
+// @Produce json
+// @Param from query string true "ID of the older backup"
+// @Param to query string true "ID of the newer backup"
+// @Success 200 {object} model.SchemaDiff "Schema diff"
+// @Failure 400 {object} model.BackupResponse "error: Bad request"
+// @Failure 404 {object} model.BackupResponse "error: Backup not found"
+// @Failure 500 {object} model.BackupResponse "error: Internal server error"
+// @Router /backups/diff [get]
+func HandleDiffBackups(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	fromID := r.URL.Query().Get("from")
+	toID := r.URL.Query().Get("to")
+	if fromID == "" || toID == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "from and to backup IDs are required",
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Minute)
+	defer cancel()
+
+	var records [2]*model.BackupMetadata
+	for i, id := range []string{fromID, toID} {
+		record, err := backupRepo.GetBackup(ctx, id)
+		if err != nil {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(model.BackupResponse{
+				Success: false,
+				Message: "Backup not found: " + id,
+				Error:   err.Error(),
+			})
+			return
+		}
+		if record.Status != model.StatusCompleted || record.Children > 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(model.BackupResponse{
+				Success: false,
+				Message: "Backup " + id + " is not a completed backup with an artifact of its own",
+			})
+			return
+		}
+		records[i] = record
+	}
+
+	switch model.BackupType(records[0].Type) {
+	case model.Postgres, model.MySQL, model.MariaDB, model.Mongo:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Schema diff supports Postgres, MySQL, MariaDB and MongoDB backups",
+		})
+		return
+	}
+	if records[0].Type != records[1].Type {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Both backups must have the same database type",
+		})
+		return
+	}
+
+	var paths [2]string
+	for i, record := range records {
+		path, cleanup, err := backupFile(ctx, record)
+		if errors.Is(err, errNoBackupFile) {
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(model.BackupResponse{
+				Success: false,
+				Message: "Backup file not found in storage or locally: " + record.ID.Hex(),
+			})
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(model.BackupResponse{
+				Success: false,
+				Message: "Failed to fetch backup file",
+				Error:   err.Error(),
+			})
+			return
+		}
+		defer cleanup()
+		paths[i] = path
+	}
+
+	diff, err := backup.DiffSchema(ctx, model.BackupType(records[0].Type), paths[0], paths[1])
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, backup.ErrNoSchema) {
+			status = http.StatusBadRequest
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Failed to compare backups",
+			Error:   err.Error(),
+		})
+		return
+	}
+	diff.From = fromID
+	diff.To = toID
+	for _, record := range records {
+		if record.Mode == model.ModeData {
+			diff.Warning = "backup " + record.ID.Hex() + " was taken in data mode and holds no schema"
+		}
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(diff)
+}
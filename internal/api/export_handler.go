@@ -0,0 +1,173 @@
+package api
+
+import (
+	"context"
+	"db-backup/internal/backup"
+	"db-backup/internal/model"
+	"db-backup/internal/worker"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HandleExportBackup godoc
+// @Summary Export a backup as flat files
+// @Description Write every table or collection of a completed backup as CSV, JSON lines or Parquet into one tar.gz archive. The export runs in the background and is recorded as a new backup that links to its source.
+// @Tags backup
+// @Accept json
+// @Produce json
+// @Param id path string true "Backup ID"
+// @Param request body model.ExportRequest true "Export Request"
+// @Success 202 {object} model.BackupResponse "Export job submitted"
+// @Failure 400 {object} model.BackupResponse "error: Bad request"
+// @Failure 404 {object} model.BackupResponse "error: Backup not found"
+// @Failure 500 {object} model.BackupResponse "error: Internal server error"
+// @Router /backups/{id}/export [post]
+func HandleExportBackup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	backupID := chi.URLParam(r, "id")
+
+	var req model.ExportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Invalid request body",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	record, err := backupRepo.GetBackup(ctx, backupID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Backup not found",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if record.Status != model.StatusCompleted || record.Children > 0 || record.Export != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Backup is not a completed backup with an artifact of its own",
+		})
+		return
+	}
+
+	if err := backup.ValidateExportOptions(model.BackupType(record.Type), &model.ExportOptions{Format: req.Format, Tables: req.Tables}); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Invalid export options",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	exportID := worker.ProcessBackupExport(record, req.Format, req.Tables)
+	if exportID == "" {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Failed to save export",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(model.BackupResponse{
+		Success: true,
+		Message: "Export job submitted successfully",
+		ID:      exportID,
+	})
+}
+
+// HandleExportDatabase godoc
+// @Summary Export a database as flat files
+// @Description Dump a saved database and write every table or collection as CSV, JSON lines or Parquet into one tar.gz archive. Without a body the database's export settings are used. The dump itself is not kept.
+// @Tags database
+// @Accept json
+// @Produce json
+// @Param id path string true "Database ID"
+// @Param request body model.ExportRequest false "Export Request"
+// @Success 202 {object} model.BackupResponse "Export job submitted"
+// @Failure 400 {object} model.BackupResponse "error: Bad request"
+// @Failure 404 {object} model.BackupResponse "error: Database not found"
+// @Failure 500 {object} model.BackupResponse "error: Internal server error"
+// @Router /databases/{id}/export [post]
+func HandleExportDatabase(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	id := chi.URLParam(r, "id")
+
+	var req model.ExportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Invalid request body",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	db, err := backupRepo.GetDatabase(ctx, id)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Database not found",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	// Fall back to the export settings of the database
+	if req.Format == "" && db.Export != nil {
+		req.Format = db.Export.Format
+		if req.Tables == nil {
+			req.Tables = db.Export.Tables
+		}
+	}
+
+	if err := backup.ValidateExportOptions(db.Type, &model.ExportOptions{Format: req.Format, Tables: req.Tables}); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Invalid export options",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	exportID := worker.ProcessDatabaseExport(*db, req.Format, req.Tables)
+	if exportID == "" {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Failed to save export",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(model.BackupResponse{
+		Success: true,
+		Message: "Export job submitted successfully",
+		ID:      exportID,
+	})
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"context"
+	"db-backup/internal/backup"
+	"db-backup/internal/model"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HandleExtractBackupObject godoc
+// @Summary Extract one table or collection from a backup
+// @Description Download a single table or collection of a backup as SQL, CSV, JSON lines or BSON without restoring the whole database
+// @Tags backup
+// @Accept json
+// @Produce octet-stream
+// @Param id path string true "Backup ID"
+// @Param request body model.ExtractRequest true "Extract Request"
+// @Success 200 {file} file "Extracted table or collection"
+// @Failure 400 {object} model.BackupResponse "error: Bad request"
+// @Failure 404 {object} model.BackupResponse "error: Backup or object not found"
+// @Failure 500 {object} model.BackupResponse "error: Internal server error"
+// @Router /backups/{id}/extract [post]
+func HandleExtractBackupObject(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	backupID := chi.URLParam(r, "id")
+
+	var req model.ExtractRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Invalid request body",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if req.Name == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "name is required",
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Hour)
+	defer cancel()
+
+	record, err := backupRepo.GetBackup(ctx, backupID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Backup not found",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if record.Status != model.StatusCompleted {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Backup is not completed",
+		})
+		return
+	}
+	if record.Children > 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Server backups have no artifact of their own; extract from a child backup",
+		})
+		return
+	}
+
+	path, cleanup, err := backupFile(ctx, record)
+	if errors.Is(err, errNoBackupFile) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Backup file not found in storage or locally",
+		})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Failed to fetch backup file",
+			Error:   err.Error(),
+		})
+		return
+	}
+	defer cleanup()
+
+	// The object is extracted to a temporary file first so a failure can
+	// still be reported as JSON
+	out, err := os.CreateTemp("", "extract-*")
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Failed to create temporary file",
+			Error:   err.Error(),
+		})
+		return
+	}
+	defer os.Remove(out.Name())
+
+	rows, err := backup.ExtractObject(ctx, model.BackupType(record.Type), path, req, out)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		status := http.StatusInternalServerError
+		switch {
+		case errors.Is(err, backup.ErrObjectNotFound):
+			status = http.StatusNotFound
+		case errors.Is(err, backup.ErrInvalidExtract):
+			status = http.StatusBadRequest
+		}
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Failed to extract " + req.Name,
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	log.Printf("Extracted %s from backup %s as %s: %d rows", req.Name, backupID, req.Format, rows)
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", extractFileName(req)))
+	http.ServeFile(w, r, out.Name())
+}
+
+// extractFileName names the download after the object, e.g. public.orders.csv
+func extractFileName(req model.ExtractRequest) string {
+	name := req.Name
+	if req.Schema != "" {
+		name = req.Schema + "." + name
+	}
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == '"' || r < ' ' {
+			return '_'
+		}
+		return r
+	}, name)
+	return name + "." + string(req.Format)
+}
@@ -2,12 +2,17 @@ package api
 
 import (
 	"context"
+	"db-backup/internal/backup"
 	"db-backup/internal/database"
+	"db-backup/internal/dedup"
+	"db-backup/internal/hooks"
 	"db-backup/internal/model"
 	"db-backup/internal/scheduler"
+	"db-backup/internal/secrets"
 	"db-backup/internal/storage"
 	"db-backup/internal/worker"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
@@ -42,6 +47,146 @@ func InitializeHandlers() {
 	}
 }
 
+// validateSSHTunnel checks a tunnel has what it needs to connect and that the
+// backup reaches a single host:port, since the tunnel forwards exactly one
+func validateSSHTunnel(req model.BackupRequest) error {
+	if req.SSH.Host == "" || req.SSH.User == "" {
+		return fmt.Errorf("ssh tunnel requires host and user")
+	}
+	if req.SSH.PrivateKey == "" && !req.SSH.UseAgent {
+		return fmt.Errorf("ssh tunnel requires a private key or useAgent")
+	}
+	if req.Type == model.SQLite || req.Type == model.Docker {
+		return fmt.Errorf("ssh tunnels are not supported for %s", req.Type)
+	}
+	if req.ConnectionURI != "" || req.Host == "" || req.Port == "" {
+		return fmt.Errorf("ssh tunnels require host and port instead of a connection URI")
+	}
+	if req.Physical {
+		return fmt.Errorf("physical backups run on the database host and cannot use an ssh tunnel")
+	}
+	if req.Redis != nil && (req.Redis.Cluster || req.Redis.SentinelMaster != "") {
+		return fmt.Errorf("ssh tunnels cannot reach the other nodes of a redis cluster or sentinel setup")
+	}
+	return nil
+}
+
+// validateConnection checks a request says where to connect: the file path for
+// SQLite, a host or connection URI for everything else
+func validateConnection(req model.BackupRequest) error {
+	if req.Type == model.SQLite {
+		if req.Database == "" {
+			return fmt.Errorf("database (the SQLite file path) is required")
+		}
+		return nil
+	}
+	if req.Type == model.Files {
+		// Files backups read local paths
+		return nil
+	}
+	if req.Type == model.Custom {
+		// The command supplies its own connection details
+		return nil
+	}
+	if req.Type == model.Docker {
+		// The container is found through the Docker socket
+		return nil
+	}
+	if req.Type == "" || (req.Host == "" && req.ConnectionURI == "") {
+		return fmt.Errorf("type and (host or connectionUri) are required")
+	}
+	return nil
+}
+
+// validateBackupOptions checks the optional backup fields of a request
+func validateBackupOptions(req model.BackupRequest) error {
+	// Sealed credentials sent back unchanged must open with a configured
+	// key. They are checked without being opened here, since only the
+	// worker decrypts them, and count as set in the checks below.
+	if err := secrets.VerifyRequest(req); err != nil {
+		return err
+	}
+
+	if !req.Mode.IsValid() {
+		return fmt.Errorf("mode must be one of full, schema, data, subset")
+	}
+	if !req.Scope.IsValid() {
+		return fmt.Errorf("scope must be one of database, server")
+	}
+	if req.Physical && req.Type != model.MariaDB {
+		return fmt.Errorf("physical backups are only supported for %s", model.MariaDB)
+	}
+	if req.Physical && req.Split {
+		return fmt.Errorf("physical backups always cover the whole server and cannot be split")
+	}
+	if req.Split && req.Scope.OrDefault() != model.ScopeServer {
+		return fmt.Errorf("split requires scope server")
+	}
+	if req.Redis != nil {
+		if req.Type != model.Redis {
+			return fmt.Errorf("redis options are only supported for %s", model.Redis)
+		}
+		if req.Redis.Cluster && req.Redis.SentinelMaster != "" {
+			return fmt.Errorf("redis cluster and sentinel cannot be combined")
+		}
+	}
+	if req.Mongo != nil && req.Type != model.Mongo {
+		return fmt.Errorf("mongo options are only supported for %s", model.Mongo)
+	}
+	if req.Type == model.Custom {
+		if err := backup.ValidateCustomCommand(req.Custom); err != nil {
+			return err
+		}
+		if req.Split {
+			return fmt.Errorf("custom backups cannot be split")
+		}
+	} else if req.Custom != nil {
+		return fmt.Errorf("custom commands are only supported for %s", model.Custom)
+	}
+	if req.Type == model.Files {
+		if err := backup.ValidateFilesOptions(req.Files); err != nil {
+			return err
+		}
+		if req.Scope.OrDefault() == model.ScopeServer {
+			return fmt.Errorf("files backups do not support scope server")
+		}
+	} else if req.Files != nil {
+		return fmt.Errorf("files options are only supported for %s", model.Files)
+	}
+	if req.Type == model.Docker {
+		if err := backup.ValidateDockerOptions(req.Docker); err != nil {
+			return err
+		}
+		if req.Split {
+			return fmt.Errorf("docker backups cannot be split")
+		}
+	} else if req.Docker != nil {
+		return fmt.Errorf("docker options are only supported for %s", model.Docker)
+	}
+	if req.Mode == model.ModeSubset {
+		if err := backup.ValidateSubsetOptions(req); err != nil {
+			return err
+		}
+	} else if req.Subset != nil {
+		return fmt.Errorf("subset options only apply to mode %s", model.ModeSubset)
+	}
+	if req.Physical && len(req.Options) > 0 {
+		return fmt.Errorf("options only apply to logical dumps")
+	}
+	if req.SSH != nil {
+		if err := validateSSHTunnel(req); err != nil {
+			return err
+		}
+	}
+	if err := backup.ValidateTLS(req.Type, req.TLS); err != nil {
+		return err
+	}
+	if err := hooks.Validate(req.Hooks); err != nil {
+		return err
+	}
+	return backup.ValidateOptions(req.Type, req.Options)
+}
+
 // HandleBackup godoc
 // @Summary Trigger a database backup
 // @Description Queue a backup job for the specified database
@@ -66,12 +211,22 @@ func HandleBackup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if req.Type == "" || (req.Host == "" && req.ConnectionURI == "") {
+	if err := validateConnection(req); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		json.NewEncoder(w).Encode(model.BackupResponse{
 			Success: false,
 			Message: "Missing required fields",
-			Error:   "type and (host or connectionUri) are required",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	if err := validateBackupOptions(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Invalid backup options",
+			Error:   err.Error(),
 		})
 		return
 	}
@@ -94,6 +249,9 @@ func HandleBackup(w http.ResponseWriter, r *http.Request) {
 // @Param page query int false "Page number" default(1)
 // @Param limit query int false "Items per page" default(10)
 // @Param statuses query string false "Comma-separated status values (pending,generating,completed,failed)"
+// @Param types query string false "Comma-separated backup types (postgre,mysql,mariadb,mongo,redis,sqlite)"
+// @Param modes query string false "Comma-separated backup modes (full,schema,data,subset)"
+// @Param parentId query string false "List the child backups of a server backup"
 // @Param search query string false "Search keyword (searches in database, host, type)"
 // @Param orderBy query string false "Field to order by" default(createdAt)
 // @Param orderDir query string false "Order direction (asc/desc)" default(desc)
@@ -145,7 +303,20 @@ func HandleListBackups(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// Parse search, orderBy, orderDir
+	// Parse modes filter
+	var modes []model.BackupMode
+	if modesStr := r.URL.Query().Get("modes"); modesStr != "" {
+		modeStrings := strings.Split(modesStr, ",")
+		for _, m := range modeStrings {
+			m = strings.TrimSpace(m)
+			if m != "" {
+				modes = append(modes, model.BackupMode(m))
+			}
+		}
+	}
+
+	// Parse parentId, search, orderBy, orderDir
+	parentID := r.URL.Query().Get("parentId")
 	search := r.URL.Query().Get("search")
 	orderBy := r.URL.Query().Get("orderBy")
 	orderDir := r.URL.Query().Get("orderDir")
@@ -169,7 +340,7 @@ func HandleListBackups(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
 	defer cancel()
 
-	backups, total, err := backupRepo.ListBackups(ctx, page, limit, statuses, types, search, orderBy, orderDir, startDate, endDate)
+	backups, total, err := backupRepo.ListBackups(ctx, page, limit, statuses, types, modes, parentID, search, orderBy, orderDir, startDate, endDate)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(model.BackupResponse{
@@ -233,7 +404,7 @@ func HandleGetBackup(w http.ResponseWriter, r *http.Request) {
 
 // HandleDeleteBackup godoc
 // @Summary Delete a backup
-// @Description Delete a backup from both MongoDB and R2 storage
+// @Description Delete a backup from both MongoDB and R2 storage, including the child backups of a server backup
 // @Tags backup
 // @Produce json
 // @Param id path string true "Backup ID"
@@ -271,10 +442,20 @@ func HandleDeleteBackup(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Delete from R2 if object key exists
-	if backup.ObjectKey != "" && storageClient != nil {
-		if err := storageClient.Delete(ctx, backup.ObjectKey); err != nil {
-			log.Printf("Failed to delete from R2: %v", err)
-			// Continue with MongoDB deletion even if R2 deletion fails
+	// Continue with MongoDB deletion even if R2 deletion fails
+	deleteStoredBackup(ctx, backup)
+
+	// Delete child backups of a server backup
+	if backup.Children > 0 {
+		children, err := backupRepo.ListChildBackups(ctx, backupID)
+		if err != nil {
+			log.Printf("Failed to list child backups: %v", err)
+		}
+		for _, child := range children {
+			deleteStoredBackup(ctx, &child)
+			if err := backupRepo.DeleteBackup(ctx, child.ID.Hex()); err != nil {
+				log.Printf("Failed to delete child backup %s: %v", child.ID.Hex(), err)
+			}
 		}
 	}
 
@@ -296,6 +477,26 @@ func HandleDeleteBackup(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
+// deleteStoredBackup removes the stored copy of a backup from R2. A
+// deduplicated backup releases its chunks and deletes its index; the chunks
+// are pruned once no backup references them.
+func deleteStoredBackup(ctx context.Context, backup *model.BackupMetadata) {
+	if backup.ObjectKey == "" || storageClient == nil {
+		return
+	}
+
+	if backup.Dedup != nil {
+		if err := dedup.Release(ctx, storageClient, backupRepo, backup.ObjectKey); err != nil {
+			log.Printf("Failed to release chunks of %s: %v", backup.ObjectKey, err)
+		}
+		return
+	}
+
+	if err := storageClient.Delete(ctx, backup.ObjectKey); err != nil {
+		log.Printf("Failed to delete from R2: %v", err)
+	}
+}
+
 // HandleDownloadBackup godoc
 // @Summary Download a backup file
 // @Description Generate a presigned URL to download a backup file from R2 storage
@@ -335,6 +536,40 @@ func HandleDownloadBackup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Deduplicated backups have no single object to presign, so the file is
+	// reassembled from its chunks and served directly
+	if storageClient != nil && backup.ObjectKey != "" && backup.Dedup != nil {
+		tmp, err := os.CreateTemp("", "download-*")
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(model.BackupResponse{
+				Success: false,
+				Message: "Failed to prepare download",
+				Error:   err.Error(),
+			})
+			return
+		}
+		tmp.Close()
+		defer os.Remove(tmp.Name())
+
+		restoreCtx, cancel := context.WithTimeout(r.Context(), 1*time.Hour)
+		defer cancel()
+		if err := dedup.Restore(restoreCtx, storageClient, backup.ObjectKey, tmp.Name()); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(model.BackupResponse{
+				Success: false,
+				Message: "Failed to reassemble backup",
+				Error:   err.Error(),
+			})
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", dedup.FileName(backup.ObjectKey)))
+		http.ServeFile(w, r, tmp.Name())
+		return
+	}
+
 	// Check if storage client is available and backup has an object key
 	if storageClient != nil && backup.ObjectKey != "" {
 		// Generate presigned URL (valid for 1 hour)
@@ -424,6 +659,18 @@ func HandleGetBackupStats(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Storage usage always covers the whole repository
+	stats.Storage, err = backupRepo.GetStorageStats(ctx)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Failed to get storage statistics",
+			Error:   err.Error(),
+		})
+		return
+	}
+
 	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(stats)
 }
@@ -0,0 +1,136 @@
+package api
+
+import (
+	"context"
+	"db-backup/internal/backup"
+	"db-backup/internal/dedup"
+	"db-backup/internal/model"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HandleRestoreBackup godoc
+// @Summary Restore a files backup
+// @Description Extract a files backup below a target path on the backup server
+// @Tags backup
+// @Accept json
+// @Produce json
+// @Param id path string true "Backup ID"
+// @Param request body model.RestoreRequest true "Restore Request"
+// @Success 200 {object} model.RestoreResult "Restore result"
+// @Failure 400 {object} model.BackupResponse "error: Bad request"
+// @Failure 403 {object} model.BackupResponse "error: Target path not allowed"
+// @Failure 404 {object} model.BackupResponse "error: Backup not found"
+// @Failure 500 {object} model.RestoreResult "error: Restore failed"
+// @Router /backups/{id}/restore [post]
+func HandleRestoreBackup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	backupID := chi.URLParam(r, "id")
+
+	var req model.RestoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Invalid request body",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if req.TargetPath == "" || !filepath.IsAbs(req.TargetPath) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "targetPath must be an absolute path",
+		})
+		return
+	}
+
+	if err := backup.CheckAllowedPath(req.TargetPath); err != nil {
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "targetPath is not allowed",
+			Error:   err.Error(),
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 1*time.Hour)
+	defer cancel()
+
+	record, err := backupRepo.GetBackup(ctx, backupID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Backup not found",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if record.Type != string(model.Files) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Only files backups can be restored",
+		})
+		return
+	}
+	if record.Status != model.StatusCompleted {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Backup is not completed",
+		})
+		return
+	}
+
+	archive, cleanup, err := backupFile(ctx, record)
+	if errors.Is(err, errNoBackupFile) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Backup file not found in storage or locally",
+		})
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(model.RestoreResult{TargetPath: req.TargetPath, Error: err.Error()})
+		return
+	}
+	defer cleanup()
+
+	files, err := backup.ExtractArchive(ctx, archive, req.TargetPath, req.Overwrite)
+	result := model.RestoreResult{
+		Success:    err == nil,
+		TargetPath: req.TargetPath,
+		Files:      files,
+	}
+	if err != nil {
+		log.Printf("Restore of backup %s failed: %v", backupID, err)
+		result.Error = err.Error()
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+
+	log.Printf("Restored backup %s to %s: %d files", backupID, req.TargetPath, files)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(result)
+}
+
+var errNoBackupFile = dedup.ErrNoBackupFile
+
+// backupFile returns a local path to the artifact of a backup; see dedup.Fetch
+func backupFile(ctx context.Context, record *model.BackupMetadata) (string, func(), error) {
+	return dedup.Fetch(ctx, storageClient, record)
+}
@@ -30,18 +30,32 @@ func NewRouter() *chi.Mux {
 	// Backup endpoints
 	r.Post("/backup", HandleBackup)
 	r.Get("/backups/stats", HandleGetBackupStats)
+	r.Get("/backups/diff", HandleDiffBackups)
 	r.Get("/backups", HandleListBackups)
 	r.Get("/backups/{id}", HandleGetBackup)
 	r.Get("/backups/{id}/download", HandleDownloadBackup)
+	r.Get("/backups/{id}/contents", HandleGetBackupContents)
+	r.Post("/backups/{id}/extract", HandleExtractBackupObject)
+	r.Post("/backups/{id}/sanitize", HandleSanitizeBackup)
+	r.Post("/backups/{id}/export", HandleExportBackup)
+	r.Post("/backups/{id}/restore", HandleRestoreBackup)
 	r.Delete("/backups/{id}", HandleDeleteBackup)
 
 	// Database endpoints
 	r.Get("/databases", HandleListDatabases)
 	r.Post("/databases", HandleCreateDatabase)
+	r.Post("/databases/discover", HandleDiscoverDatabases)
+	r.Post("/databases/import", HandleImportDatabases)
+	r.Post("/databases/test", HandleTestConnection)
 	r.Get("/databases/{id}", HandleGetDatabase)
 	r.Put("/databases/{id}", HandleUpdateDatabase)
 	r.Delete("/databases/{id}", HandleDeleteDatabase)
 	r.Post("/databases/{id}/backup", HandleTriggerBackup)
+	r.Post("/databases/{id}/export", HandleExportDatabase)
+	r.Post("/databases/{id}/test", HandleTestDatabase)
+
+	// System endpoints
+	r.Get("/system/tools", HandleListTools)
 
 	return r
 }
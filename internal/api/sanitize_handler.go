@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"db-backup/internal/model"
+	"db-backup/internal/worker"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// HandleSanitizeBackup godoc
+// @Summary Create a sanitized copy of a backup
+// @Description Apply a masking profile of a saved database to a backup. The masked copy is produced in the background and recorded as a new backup that links to its source.
+// @Tags backup
+// @Accept json
+// @Produce json
+// @Param id path string true "Backup ID"
+// @Param request body model.SanitizeRequest true "Sanitize Request"
+// @Success 202 {object} model.BackupResponse "Sanitize job submitted"
+// @Failure 400 {object} model.BackupResponse "error: Bad request"
+// @Failure 404 {object} model.BackupResponse "error: Backup, database or profile not found"
+// @Failure 500 {object} model.BackupResponse "error: Internal server error"
+// @Router /backups/{id}/sanitize [post]
+func HandleSanitizeBackup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	backupID := chi.URLParam(r, "id")
+
+	var req model.SanitizeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Invalid request body",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if req.DatabaseID == "" || req.Profile == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "databaseId and profile are required",
+		})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 10*time.Second)
+	defer cancel()
+
+	record, err := backupRepo.GetBackup(ctx, backupID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Backup not found",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if record.Status != model.StatusCompleted || record.Children > 0 || record.Export != "" {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Backup is not a completed backup with an artifact of its own",
+		})
+		return
+	}
+
+	switch model.BackupType(record.Type) {
+	case model.Postgres, model.MySQL, model.MariaDB, model.SQLite, model.Mongo:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Masking supports Postgres, MySQL, MariaDB, SQLite and MongoDB backups",
+		})
+		return
+	}
+
+	db, err := backupRepo.GetDatabase(ctx, req.DatabaseID)
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Database not found",
+			Error:   err.Error(),
+		})
+		return
+	}
+	if string(db.Type) != record.Type {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Database type does not match the backup",
+		})
+		return
+	}
+
+	var profile *model.MaskingProfile
+	for i := range db.Masking {
+		if db.Masking[i].Name == req.Profile {
+			profile = &db.Masking[i]
+		}
+	}
+	if profile == nil {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Masking profile not found: " + req.Profile,
+		})
+		return
+	}
+
+	sanitizedID := worker.ProcessSanitize(record, *profile)
+	if sanitizedID == "" {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(model.BackupResponse{
+			Success: false,
+			Message: "Failed to save sanitized backup",
+		})
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(model.BackupResponse{
+		Success: true,
+		Message: "Sanitize job submitted successfully",
+		ID:      sanitizedID,
+	})
+}
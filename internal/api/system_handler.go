@@ -0,0 +1,22 @@
+package api
+
+import (
+	"db-backup/internal/backup"
+	"db-backup/internal/model"
+	"encoding/json"
+	"net/http"
+)
+
+// HandleListTools godoc
+// @Summary List client tools
+// @Description Report the database client binaries used for backups, where they were found and their versions
+// @Tags system
+// @Produce json
+// @Success 200 {object} model.ToolsResponse
+// @Router /system/tools [get]
+func HandleListTools(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(model.ToolsResponse{
+		Tools: backup.DetectTools(r.Context()),
+	})
+}
@@ -0,0 +1,421 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"db-backup/internal/model"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Magic bytes of the artifact formats ListContents understands
+var (
+	gzipMagic         = []byte{0x1f, 0x8b}
+	mongoArchiveMagic = []byte{0x6d, 0xe2, 0x99, 0x81}
+	sqliteMagic       = []byte("SQLite format 3\x00")
+)
+
+// ListContents reads a backup artifact and lists the tables, collections,
+// keyspaces or directories it holds. The format is detected from the
+// content; the backup type only decides how tar archives are read.
+func ListContents(ctx context.Context, t model.BackupType, filePath string) (*model.BackupContents, error) {
+	artifact, err := openArtifact(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer artifact.Close()
+
+	contents := &contentCollector{}
+	switch artifact.format {
+	case formatPgCustom:
+		return pgRestoreContents(ctx, filePath)
+	case formatSQLite:
+		return sqliteContents(ctx, filePath)
+	case formatXbstream:
+		return nil, fmt.Errorf("contents of physical mariabackup copies cannot be listed")
+	case formatTar:
+		return contents.result(artifact.format, tarContents(ctx, t, artifact.r, contents))
+	case formatMongoArchive:
+		return contents.result(artifact.format, mongoArchiveContents(ctx, artifact.r, contents))
+	case formatRDB:
+		return contents.result(artifact.format, rdbContents(ctx, artifact.r, contents, ""))
+	}
+
+	return contents.result(artifact.format, sqlContents(ctx, artifact.r, contents))
+}
+
+// Artifact formats detected by openArtifact
+const (
+	formatSQL          = "sql"
+	formatPgCustom     = "pg_custom"
+	formatSQLite       = "sqlite"
+	formatXbstream     = "xbstream"
+	formatTar          = "tar"
+	formatMongoArchive = "mongo_archive"
+	formatRDB          = "rdb"
+)
+
+// artifact is an opened backup file, decompressed when it was gzipped
+type artifact struct {
+	format string
+	r      *bufio.Reader
+	file   *os.File
+	gz     *gzip.Reader
+}
+
+// openArtifact opens a backup file and detects its format from the content.
+// Anything unrecognised is treated as SQL text.
+func openArtifact(filePath string) (*artifact, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup file: %w", err)
+	}
+
+	a := &artifact{file: file, r: bufio.NewReaderSize(file, 64*1024)}
+	head, _ := a.r.Peek(16)
+
+	switch {
+	case bytes.HasPrefix(head, []byte("PGDMP")):
+		a.format = formatPgCustom
+		return a, nil
+	case bytes.HasPrefix(head, sqliteMagic):
+		a.format = formatSQLite
+		return a, nil
+	case bytes.HasPrefix(head, []byte("XBSTCK01")):
+		a.format = formatXbstream
+		return a, nil
+	}
+
+	if bytes.HasPrefix(head, gzipMagic) {
+		if a.gz, err = gzip.NewReader(a.r); err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read gzip stream: %w", err)
+		}
+		a.r = bufio.NewReaderSize(a.gz, 64*1024)
+	}
+
+	head, _ = a.r.Peek(512)
+	switch {
+	case len(head) >= 262 && string(head[257:262]) == "ustar":
+		a.format = formatTar
+	case bytes.HasPrefix(head, mongoArchiveMagic):
+		a.format = formatMongoArchive
+	case bytes.HasPrefix(head, []byte("REDIS")):
+		a.format = formatRDB
+	default:
+		a.format = formatSQL
+	}
+
+	return a, nil
+}
+
+func (a *artifact) Close() error {
+	if a.gz != nil {
+		a.gz.Close()
+	}
+	return a.file.Close()
+}
+
+// contentCollector gathers items in the order they are first seen
+type contentCollector struct {
+	items []model.ContentItem
+	index map[string]int
+}
+
+func (c *contentCollector) item(database, schema, name, kind string) *model.ContentItem {
+	if c.index == nil {
+		c.index = make(map[string]int)
+	}
+	key := database + "\x00" + schema + "\x00" + name
+	if i, ok := c.index[key]; ok {
+		return &c.items[i]
+	}
+	c.items = append(c.items, model.ContentItem{Database: database, Schema: schema, Name: name, Kind: kind})
+	c.index[key] = len(c.items) - 1
+	return &c.items[len(c.items)-1]
+}
+
+// result returns what was collected. A read error after some items were
+// found still returns them, with the error as a warning.
+func (c *contentCollector) result(format string, err error) (*model.BackupContents, error) {
+	contents := &model.BackupContents{Format: format, Items: c.items}
+	if contents.Items == nil {
+		contents.Items = []model.ContentItem{}
+	}
+	if err != nil {
+		if len(c.items) == 0 {
+			return nil, err
+		}
+		contents.Warning = fmt.Sprintf("the backup could only be read in part: %v", err)
+	}
+	return contents, nil
+}
+
+// sqlName matches a table name with optional schema, each part bare or
+// quoted with double quotes or backticks
+const sqlName = "((?:\"[^\"]*\"|`[^`]*`|[^\\s(\"`.;]+)(?:\\.(?:\"[^\"]*\"|`[^`]*`|[^\\s(\"`.;]+))*)"
+
+var (
+	sqlCreateTable = regexp.MustCompile(`(?i)^CREATE\s+(?:(?:GLOBAL\s+|LOCAL\s+)?(?:TEMPORARY|TEMP)\s+|UNLOGGED\s+)?TABLE\s+(?:IF\s+NOT\s+EXISTS\s+)?` + sqlName)
+	sqlCopy        = regexp.MustCompile(`^COPY\s+` + sqlName + `.*\bFROM\s+stdin;`)
+	sqlInsert      = regexp.MustCompile(`(?i)^INSERT\s+(?:IGNORE\s+)?INTO\s+` + sqlName)
+	sqlUse         = regexp.MustCompile("(?i)^USE\\s+`?([^`;]+)`?;")
+	pgConnect      = regexp.MustCompile(`^\\connect\s+(?:-reuse-previous=on\s+)?(.+)$`)
+	pgConnectName  = regexp.MustCompile(`dbname='?((?:[^'\\]|\\.)*)'?`)
+)
+
+// sqlContents scans a plain SQL dump from pg_dump, pg_dumpall, mysqldump,
+// mariadb-dump or sqlite3. Tables come from CREATE TABLE statements; rows
+// are counted from COPY blocks and INSERT statements, where an extended
+// insert counts one row per "),(".
+func sqlContents(ctx context.Context, r *bufio.Reader, contents *contentCollector) error {
+	var database string
+	var copying *model.ContentItem
+
+	for lineNo := 0; ; lineNo++ {
+		if lineNo%10000 == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			if copying != nil {
+				if bytes.Equal(bytes.TrimRight(line, "\r\n"), []byte(`\.`)) {
+					copying = nil
+				} else {
+					copying.Rows++
+					copying.Size += int64(len(line))
+				}
+			} else {
+				database, copying = scanSQLLine(line, database, contents)
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read dump: %w", err)
+		}
+	}
+}
+
+// scanSQLLine handles one statement line outside a COPY block. It returns the
+// current database and the table whose COPY data follows, if any.
+func scanSQLLine(line []byte, database string, contents *contentCollector) (string, *model.ContentItem) {
+	switch {
+	case bytes.HasPrefix(line, []byte("CREATE")), bytes.HasPrefix(line, []byte("create")):
+		if m := sqlCreateTable.FindSubmatch(line); m != nil {
+			schema, name := splitSQLName(string(m[1]))
+			contents.item(database, schema, name, "table")
+		}
+	case bytes.HasPrefix(line, []byte("COPY ")):
+		if m := sqlCopy.FindSubmatch(line); m != nil {
+			schema, name := splitSQLName(string(m[1]))
+			return database, contents.item(database, schema, name, "table")
+		}
+	case bytes.HasPrefix(line, []byte("INSERT")), bytes.HasPrefix(line, []byte("insert")):
+		if m := sqlInsert.FindSubmatch(line); m != nil {
+			schema, name := splitSQLName(string(m[1]))
+			item := contents.item(database, schema, name, "table")
+			item.Rows += int64(bytes.Count(line, []byte("),("))) + 1
+			item.Size += int64(len(line))
+		}
+	default:
+		if switched, ok := sqlSwitchDatabase(line); ok {
+			database = switched
+		}
+	}
+	return database, nil
+}
+
+// sqlSwitchDatabase reports the database a USE statement of mysqldump or a
+// \connect line of pg_dumpall switches to
+func sqlSwitchDatabase(line []byte) (string, bool) {
+	switch {
+	case bytes.HasPrefix(line, []byte("USE ")), bytes.HasPrefix(line, []byte("use ")):
+		if m := sqlUse.FindSubmatch(line); m != nil {
+			return string(m[1]), true
+		}
+	case bytes.HasPrefix(line, []byte(`\connect `)):
+		if m := pgConnect.FindSubmatch(bytes.TrimRight(line, "\r\n")); m != nil {
+			target := string(m[1])
+			if n := pgConnectName.FindStringSubmatch(target); n != nil {
+				target = strings.ReplaceAll(n[1], `\'`, `'`)
+			}
+			return strings.Trim(target, `"`), true
+		}
+	}
+	return "", false
+}
+
+// splitSQLName splits a possibly quoted and schema-qualified table name
+func splitSQLName(name string) (string, string) {
+	var parts []string
+	var current strings.Builder
+	var quote rune
+	for _, r := range name {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '`'):
+			quote = r
+		case quote == 0 && r == '.':
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+	parts = append(parts, current.String())
+
+	if len(parts) == 1 {
+		return "", parts[0]
+	}
+	return parts[len(parts)-2], parts[len(parts)-1]
+}
+
+// pgRestoreContents lists the tables of a pg_dump custom-format archive with
+// `pg_restore -l`. The table of contents carries no sizes or row counts.
+func pgRestoreContents(ctx context.Context, filePath string) (*model.BackupContents, error) {
+	cmd := exec.CommandContext(ctx, resolveExecutable("pg_restore"), "-l", filePath)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("pg_restore -l failed: %w", err)
+	}
+
+	contents := &contentCollector{}
+	var database string
+	for _, line := range splitLines(output) {
+		if strings.HasPrefix(line, ";") {
+			if name, ok := strings.CutPrefix(line, "; dbname: "); ok {
+				database = strings.TrimSpace(name)
+			}
+			continue
+		}
+
+		// <dump id>; <table oid> <oid> <description> <schema> <name> <owner>
+		_, entry, ok := strings.Cut(line, "; ")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(entry)
+		if len(fields) < 5 || fields[2] != "TABLE" {
+			continue
+		}
+		if fields[3] == "DATA" && len(fields) >= 6 {
+			contents.item(database, fields[4], fields[5], "table")
+		} else {
+			contents.item(database, fields[3], fields[4], "table")
+		}
+	}
+
+	result, err := contents.result(formatPgCustom, nil)
+	if err != nil {
+		return nil, err
+	}
+	result.Warning = "pg_restore -l does not report row counts or sizes"
+	return result, nil
+}
+
+// sqliteContents counts the rows of every table in a SQLite database file.
+// Sizes come from the dbstat table when sqlite3 was built with it.
+func sqliteContents(ctx context.Context, filePath string) (*model.BackupContents, error) {
+	binPath := resolveExecutable("sqlite3")
+
+	output, err := exec.CommandContext(ctx, binPath, "-readonly", filePath,
+		"SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("sqlite3 failed: %s, output: %s", err, string(output))
+	}
+
+	contents := &contentCollector{}
+	for _, name := range splitLines(output) {
+		item := contents.item("", "", name, "table")
+
+		quoted := `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+		count, err := exec.CommandContext(ctx, binPath, "-readonly", filePath, "SELECT count(*) FROM "+quoted).Output()
+		if err == nil {
+			item.Rows, _ = strconv.ParseInt(strings.TrimSpace(string(count)), 10, 64)
+		}
+	}
+
+	var warning string
+	sizes, err := exec.CommandContext(ctx, binPath, "-readonly", "-separator", "\t", filePath,
+		"SELECT name, sum(pgsize) FROM dbstat GROUP BY name").Output()
+	if err != nil {
+		warning = "sizes are not available: sqlite3 lacks the dbstat table"
+	}
+	for _, line := range splitLines(sizes) {
+		name, size, ok := strings.Cut(line, "\t")
+		if !ok {
+			continue
+		}
+		if i, found := contents.index["\x00\x00"+name]; found {
+			contents.items[i].Size, _ = strconv.ParseInt(size, 10, 64)
+		}
+	}
+
+	result, err := contents.result(formatSQLite, nil)
+	if err != nil {
+		return nil, err
+	}
+	result.Warning = warning
+	return result, nil
+}
+
+// tarContents reads the tar archives written for Redis clusters (one RDB per
+// shard), native MongoDB dumps (dump/<db>/<collection>.bson) and files
+// backups, which are summarised per directory
+func tarContents(ctx context.Context, t model.BackupType, r io.Reader, contents *contentCollector) error {
+	tr := tar.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		name := strings.TrimPrefix(header.Name, "./")
+		switch {
+		case t == model.Redis && strings.HasSuffix(name, ".rdb"):
+			shard := strings.TrimSuffix(path.Base(name), ".rdb")
+			if err := rdbContents(ctx, bufio.NewReader(tr), contents, shard); err != nil {
+				return fmt.Errorf("shard %s: %w", shard, err)
+			}
+		case t == model.Mongo && strings.HasPrefix(name, "dump/"):
+			parts := strings.SplitN(strings.TrimPrefix(name, "dump/"), "/", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			if collection, ok := strings.CutSuffix(parts[1], ".metadata.json"); ok {
+				contents.item(parts[0], "", collection, "collection")
+			} else if collection, ok := strings.CutSuffix(parts[1], ".bson"); ok {
+				item := contents.item(parts[0], "", collection, "collection")
+				if err := countBSONDocuments(tr, item); err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+			}
+		case header.Typeflag == tar.TypeReg:
+			item := contents.item("", "", "/"+path.Dir(name), "directory")
+			item.Rows++
+			item.Size += header.Size
+		}
+	}
+}
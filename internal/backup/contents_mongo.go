@@ -0,0 +1,136 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"db-backup/internal/model"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// mongoArchiveTerminator ends each block of a mongodump archive
+const mongoArchiveTerminator = 0xffffffff
+
+// mongoArchiveContents walks a mongodump --archive stream. After the magic
+// number the archive is a series of blocks: a header document, body documents
+// and a terminator. The first block is the prelude, whose body lists every
+// collection; later blocks carry the documents of one namespace.
+func mongoArchiveContents(ctx context.Context, r *bufio.Reader, contents *contentCollector) error {
+	if _, err := r.Discard(len(mongoArchiveMagic)); err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	prelude := true
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		header, err := readBSONDocument(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header == nil {
+			// Stray terminator between blocks
+			continue
+		}
+
+		var namespace struct {
+			Database   string `bson:"db"`
+			Collection string `bson:"collection"`
+		}
+		var item *model.ContentItem
+		if !prelude {
+			if err := bson.Unmarshal(header, &namespace); err != nil {
+				return fmt.Errorf("invalid namespace header: %w", err)
+			}
+			// The oplog block has no database
+			if namespace.Database != "" {
+				item = contents.item(namespace.Database, "", namespace.Collection, "collection")
+			}
+		}
+
+		for {
+			doc, err := readBSONDocument(r)
+			if err != nil {
+				return fmt.Errorf("failed to read archive: %w", err)
+			}
+			if doc == nil {
+				break
+			}
+
+			if prelude {
+				var collection struct {
+					Database   string `bson:"db"`
+					Collection string `bson:"collection"`
+					Type       string `bson:"type"`
+				}
+				if err := bson.Unmarshal(doc, &collection); err != nil {
+					return fmt.Errorf("invalid collection metadata: %w", err)
+				}
+				kind := "collection"
+				if collection.Type == "view" {
+					kind = "view"
+				}
+				contents.item(collection.Database, "", collection.Collection, kind)
+			} else if item != nil {
+				item.Rows++
+				item.Size += int64(len(doc))
+			}
+		}
+
+		prelude = false
+	}
+}
+
+// readBSONDocument reads one length-prefixed BSON document. It returns nil
+// for a mongodump archive terminator.
+func readBSONDocument(r *bufio.Reader) ([]byte, error) {
+	prefix, err := r.Peek(4)
+	if err != nil {
+		if len(prefix) == 0 {
+			return nil, io.EOF
+		}
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	length := binary.LittleEndian.Uint32(prefix)
+	if length == mongoArchiveTerminator {
+		r.Discard(4)
+		return nil, nil
+	}
+	if length < 5 || length > 48*1024*1024 {
+		return nil, fmt.Errorf("invalid BSON document length %d", length)
+	}
+
+	doc := make([]byte, length)
+	if _, err := io.ReadFull(r, doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+// countBSONDocuments counts the documents of a mongodump .bson file
+func countBSONDocuments(r io.Reader, item *model.ContentItem) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+	for {
+		doc, err := readBSONDocument(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if doc == nil {
+			continue
+		}
+		item.Rows++
+		item.Size += int64(len(doc))
+	}
+}
@@ -0,0 +1,494 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// RDB opcodes that precede a key or carry auxiliary data
+const (
+	rdbOpSlotInfo   = 0xF4
+	rdbOpFunction2  = 0xF6
+	rdbOpModuleAux  = 0xF7
+	rdbOpIdle       = 0xF8
+	rdbOpFreq       = 0xF9
+	rdbOpAux        = 0xFA
+	rdbOpResizeDB   = 0xFB
+	rdbOpExpireMs   = 0xFC
+	rdbOpExpireSec  = 0xFD
+	rdbOpSelectDB   = 0xFE
+	rdbOpEOF        = 0xFF
+	rdbModuleOpEOF  = 0
+	rdbModuleOpSInt = 1
+	rdbModuleOpUInt = 2
+	rdbModuleOpFlt  = 3
+	rdbModuleOpDbl  = 4
+	rdbModuleOpStr  = 5
+)
+
+// rdbTypeNames maps RDB value types to the Redis data type they encode
+var rdbTypeNames = map[byte]string{
+	0: "string", 1: "list", 2: "set", 3: "zset", 4: "hash", 5: "zset",
+	7: "module", 9: "hash", 10: "list", 11: "set", 12: "zset", 13: "hash",
+	14: "list", 15: "stream", 16: "hash", 17: "zset", 18: "list",
+	19: "stream", 20: "set", 21: "stream", 24: "hash", 25: "hash",
+}
+
+// rdbReader walks an RDB file and counts the bytes it consumes
+type rdbReader struct {
+	r *bufio.Reader
+	n int64
+}
+
+// rdbContents walks the keys of an RDB file without decoding their values and
+// reports one keyspace per logical database with key counts per type. For
+// cluster shards, shard names the database of the items.
+func rdbContents(ctx context.Context, r *bufio.Reader, contents *contentCollector, shard string) error {
+	rr := &rdbReader{r: r}
+
+	header := make([]byte, 9)
+	if err := rr.read(header); err != nil {
+		return fmt.Errorf("RDB too short: %w", err)
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil {
+		return fmt.Errorf("invalid RDB version %q", header[5:])
+	}
+
+	db := uint64(0)
+	for keys := 0; ; keys++ {
+		if keys%10000 == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		start := rr.n
+		op, err := rr.byte()
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case rdbOpEOF:
+			return nil
+		case rdbOpSelectDB:
+			if db, err = rr.length(); err != nil {
+				return err
+			}
+			continue
+		case rdbOpResizeDB:
+			if err := rr.skipLengths(2); err != nil {
+				return err
+			}
+			continue
+		case rdbOpAux:
+			if err := rr.skipStrings(2); err != nil {
+				return err
+			}
+			continue
+		case rdbOpSlotInfo:
+			if err := rr.skipLengths(3); err != nil {
+				return err
+			}
+			continue
+		case rdbOpFunction2:
+			if err := rr.skipString(); err != nil {
+				return err
+			}
+			continue
+		case rdbOpModuleAux:
+			// Module ID, when opcode and when, then the module data
+			if err := rr.skipLengths(3); err != nil {
+				return err
+			}
+			if err := rr.skipModuleData(); err != nil {
+				return err
+			}
+			continue
+		case rdbOpExpireMs, rdbOpExpireSec, rdbOpFreq, rdbOpIdle:
+			// Key metadata; the key follows
+			switch op {
+			case rdbOpExpireMs:
+				err = rr.skip(8)
+			case rdbOpExpireSec:
+				err = rr.skip(4)
+			case rdbOpFreq:
+				err = rr.skip(1)
+			case rdbOpIdle:
+				_, err = rr.length()
+			}
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		typeName, ok := rdbTypeNames[op]
+		if !ok {
+			return fmt.Errorf("unsupported RDB value type %d", op)
+		}
+		if err := rr.skipString(); err != nil {
+			return err
+		}
+		if err := rr.skipValue(op, version); err != nil {
+			return fmt.Errorf("failed to read %s value: %w", typeName, err)
+		}
+
+		item := contents.item(shard, "", "db"+strconv.FormatUint(db, 10), "keyspace")
+		item.Rows++
+		item.Size += rr.n - start
+		if item.KeyTypes == nil {
+			item.KeyTypes = make(map[string]int64)
+		}
+		item.KeyTypes[typeName]++
+	}
+}
+
+func (rr *rdbReader) read(buf []byte) error {
+	n, err := io.ReadFull(rr.r, buf)
+	rr.n += int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func (rr *rdbReader) byte() (byte, error) {
+	b, err := rr.r.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return 0, err
+	}
+	rr.n++
+	return b, nil
+}
+
+func (rr *rdbReader) skip(n int64) error {
+	for n > 0 {
+		chunk := int(min(n, 1<<30))
+		discarded, err := rr.r.Discard(chunk)
+		rr.n += int64(discarded)
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		n -= int64(discarded)
+	}
+	return nil
+}
+
+// lengthOrEncoding reads an RDB length. Strings may instead carry a special
+// encoding, reported with encoded set and the encoding in the length.
+func (rr *rdbReader) lengthOrEncoding() (length uint64, encoded bool, err error) {
+	b, err := rr.byte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	switch b >> 6 {
+	case 0:
+		return uint64(b & 0x3F), false, nil
+	case 1:
+		next, err := rr.byte()
+		if err != nil {
+			return 0, false, err
+		}
+		return uint64(b&0x3F)<<8 | uint64(next), false, nil
+	case 2:
+		switch b {
+		case 0x80:
+			buf := make([]byte, 4)
+			if err := rr.read(buf); err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(buf)), false, nil
+		case 0x81:
+			buf := make([]byte, 8)
+			if err := rr.read(buf); err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(buf), false, nil
+		}
+		return 0, false, fmt.Errorf("invalid RDB length byte %#x", b)
+	default:
+		return uint64(b & 0x3F), true, nil
+	}
+}
+
+func (rr *rdbReader) length() (uint64, error) {
+	length, encoded, err := rr.lengthOrEncoding()
+	if err == nil && encoded {
+		err = fmt.Errorf("unexpected string encoding where a length was expected")
+	}
+	return length, err
+}
+
+func (rr *rdbReader) skipLengths(n int) error {
+	for i := 0; i < n; i++ {
+		if _, err := rr.length(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipString skips a string, which may be raw, an encoded integer or LZF
+// compressed
+func (rr *rdbReader) skipString() error {
+	length, encoded, err := rr.lengthOrEncoding()
+	if err != nil {
+		return err
+	}
+	if !encoded {
+		return rr.skip(int64(length))
+	}
+
+	switch length {
+	case 0:
+		return rr.skip(1)
+	case 1:
+		return rr.skip(2)
+	case 2:
+		return rr.skip(4)
+	case 3:
+		compressed, err := rr.length()
+		if err != nil {
+			return err
+		}
+		if _, err := rr.length(); err != nil {
+			return err
+		}
+		return rr.skip(int64(compressed))
+	}
+	return fmt.Errorf("unknown RDB string encoding %d", length)
+}
+
+func (rr *rdbReader) skipStrings(n uint64) error {
+	for i := uint64(0); i < n; i++ {
+		if err := rr.skipString(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// skipValue skips the value of a key of the given RDB type
+func (rr *rdbReader) skipValue(valueType byte, version int) error {
+	switch valueType {
+	case 0, 9, 10, 11, 12, 13, 16, 17, 20:
+		// Strings and the single-blob encodings (ziplist, intset, listpack)
+		return rr.skipString()
+	case 1, 2, 14:
+		n, err := rr.length()
+		if err != nil {
+			return err
+		}
+		return rr.skipStrings(n)
+	case 4:
+		n, err := rr.length()
+		if err != nil {
+			return err
+		}
+		return rr.skipStrings(2 * n)
+	case 3:
+		n, err := rr.length()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if err := rr.skipString(); err != nil {
+				return err
+			}
+			// Scores are strings prefixed with their length byte; 253 to
+			// 255 stand for nan and the infinities
+			scoreLen, err := rr.byte()
+			if err != nil {
+				return err
+			}
+			if scoreLen < 253 {
+				if err := rr.skip(int64(scoreLen)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	case 5:
+		n, err := rr.length()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if err := rr.skipString(); err != nil {
+				return err
+			}
+			if err := rr.skip(8); err != nil {
+				return err
+			}
+		}
+		return nil
+	case 7:
+		if _, err := rr.length(); err != nil {
+			return err
+		}
+		return rr.skipModuleData()
+	case 18:
+		n, err := rr.length()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			// Container type, then the node
+			if _, err := rr.length(); err != nil {
+				return err
+			}
+			if err := rr.skipString(); err != nil {
+				return err
+			}
+		}
+		return nil
+	case 15, 19, 21:
+		return rr.skipStream(valueType)
+	case 24:
+		// Minimum expire time, then field TTL, field and value triples
+		if err := rr.skip(8); err != nil {
+			return err
+		}
+		n, err := rr.length()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < n; i++ {
+			if _, err := rr.length(); err != nil {
+				return err
+			}
+			if err := rr.skipStrings(2); err != nil {
+				return err
+			}
+		}
+		return nil
+	case 25:
+		// Minimum expire time, then a listpack
+		if err := rr.skip(8); err != nil {
+			return err
+		}
+		return rr.skipString()
+	}
+	return fmt.Errorf("unsupported RDB value type %d in version %d", valueType, version)
+}
+
+// skipStream skips a stream: its listpacks, metadata and consumer groups.
+// Types 19 and 21 add fields to the metadata, groups and consumers.
+func (rr *rdbReader) skipStream(valueType byte) error {
+	listpacks, err := rr.length()
+	if err != nil {
+		return err
+	}
+	// Each listpack is stored with its master entry ID
+	if err := rr.skipStrings(2 * listpacks); err != nil {
+		return err
+	}
+
+	// Length and last ID, plus first ID, max deleted ID and entries added
+	metadata := 3
+	if valueType >= 19 {
+		metadata += 5
+	}
+	if err := rr.skipLengths(metadata); err != nil {
+		return err
+	}
+
+	groups, err := rr.length()
+	if err != nil {
+		return err
+	}
+	for g := uint64(0); g < groups; g++ {
+		if err := rr.skipString(); err != nil {
+			return err
+		}
+		groupFields := 2
+		if valueType >= 19 {
+			groupFields++
+		}
+		if err := rr.skipLengths(groupFields); err != nil {
+			return err
+		}
+
+		// Pending entries: ID, delivery time and delivery count
+		pending, err := rr.length()
+		if err != nil {
+			return err
+		}
+		for p := uint64(0); p < pending; p++ {
+			if err := rr.skip(16 + 8); err != nil {
+				return err
+			}
+			if _, err := rr.length(); err != nil {
+				return err
+			}
+		}
+
+		consumers, err := rr.length()
+		if err != nil {
+			return err
+		}
+		for c := uint64(0); c < consumers; c++ {
+			if err := rr.skipString(); err != nil {
+				return err
+			}
+			// Seen time, and active time from type 21
+			times := int64(8)
+			if valueType >= 21 {
+				times += 8
+			}
+			if err := rr.skip(times); err != nil {
+				return err
+			}
+			owned, err := rr.length()
+			if err != nil {
+				return err
+			}
+			if err := rr.skip(16 * int64(owned)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// skipModuleData skips module-serialised data up to its EOF opcode
+func (rr *rdbReader) skipModuleData() error {
+	for {
+		op, err := rr.length()
+		if err != nil {
+			return err
+		}
+
+		switch op {
+		case rdbModuleOpEOF:
+			return nil
+		case rdbModuleOpSInt, rdbModuleOpUInt:
+			_, err = rr.length()
+		case rdbModuleOpFlt:
+			err = rr.skip(4)
+		case rdbModuleOpDbl:
+			err = rr.skip(8)
+		case rdbModuleOpStr:
+			err = rr.skipString()
+		default:
+			err = fmt.Errorf("unknown module opcode %d", op)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"db-backup/internal/hooks"
+	"db-backup/internal/model"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+type CustomBackup struct{}
+
+// customExtension keeps file extensions to plain names so they cannot change
+// the directory of the backup file
+var customExtension = regexp.MustCompile(`^[A-Za-z0-9]+(\.[A-Za-z0-9]+)*$`)
+
+// customTemplateData are the fields available to the command template. The
+// password is only passed as BACKUP_PASSWORD so it stays out of the process
+// list.
+type customTemplateData struct {
+	File     string
+	Host     string
+	Port     string
+	Username string
+	Database string
+}
+
+func (b *CustomBackup) Backup(ctx context.Context, req model.BackupRequest) (string, error) {
+	cfg := req.Custom
+	if cfg == nil || cfg.Command == "" {
+		return "", fmt.Errorf("custom backup requires a command")
+	}
+	if !hooks.CommandsAllowed() {
+		return "", fmt.Errorf("custom backups are disabled; set ALLOW_SHELL_COMMANDS=true to enable them")
+	}
+	// The command decides what it dumps
+	if req.Mode.OrDefault() != model.ModeFull {
+		return "", unsupportedModeError(req)
+	}
+
+	named := req
+	if named.Host == "" {
+		named.Host = "local"
+	}
+	extension := cfg.Extension
+	if extension == "" {
+		extension = "bak"
+	}
+	filename, err := filepath.Abs(generateFilename(named, extension))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve backup path: %w", err)
+	}
+
+	command, err := renderCustomCommand(cfg.Command, customTemplateData{
+		File:     filename,
+		Host:     req.Host,
+		Port:     req.Port,
+		Username: req.Username,
+		Database: req.Database,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(),
+		"BACKUP_FILE="+filename,
+		"BACKUP_HOST="+req.Host,
+		"BACKUP_PORT="+req.Port,
+		"BACKUP_USERNAME="+req.Username,
+		"BACKUP_PASSWORD="+req.Password,
+		"BACKUP_DATABASE="+req.Database,
+	)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	if cfg.Output == model.CustomOutputStdout {
+		outfile, err := os.Create(filename)
+		if err != nil {
+			return "", fmt.Errorf("failed to create backup file: %w", err)
+		}
+		defer outfile.Close()
+		cmd.Stdout = outfile
+	} else {
+		cmd.Stdout = &stderr
+	}
+
+	if err := cmd.Run(); err != nil {
+		os.Remove(filename)
+		return "", fmt.Errorf("custom command failed: %s, output: %s", err, tail(stderr.String(), 2048))
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return "", fmt.Errorf("custom command did not write %s: %w", filename, err)
+	}
+	if info.Size() == 0 {
+		os.Remove(filename)
+		return "", fmt.Errorf("backup file is empty")
+	}
+
+	return filename, nil
+}
+
+// ValidateCustomCommand checks a custom command before it is saved
+func ValidateCustomCommand(cfg *model.CustomCommand) error {
+	if cfg == nil || cfg.Command == "" {
+		return fmt.Errorf("custom backups require custom.command")
+	}
+	switch cfg.Output {
+	case "", model.CustomOutputFile, model.CustomOutputStdout:
+	default:
+		return fmt.Errorf("custom.output must be one of file, stdout")
+	}
+	if cfg.Extension != "" && !customExtension.MatchString(cfg.Extension) {
+		return fmt.Errorf("custom.extension may only contain letters, digits and dots")
+	}
+	if !hooks.CommandsAllowed() {
+		return fmt.Errorf("custom backups are disabled; set ALLOW_SHELL_COMMANDS=true to enable them")
+	}
+
+	_, err := renderCustomCommand(cfg.Command, customTemplateData{})
+	return err
+}
+
+// renderCustomCommand fills in the command template with shell-quoted values
+func renderCustomCommand(command string, data customTemplateData) (string, error) {
+	tmpl, err := template.New("command").Option("missingkey=error").Parse(command)
+	if err != nil {
+		return "", fmt.Errorf("invalid custom command: %w", err)
+	}
+
+	quoted := customTemplateData{
+		File:     shellQuote(data.File),
+		Host:     shellQuote(data.Host),
+		Port:     shellQuote(data.Port),
+		Username: shellQuote(data.Username),
+		Database: shellQuote(data.Database),
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, quoted); err != nil {
+		return "", fmt.Errorf("invalid custom command: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+// shellQuote wraps a value in single quotes for sh
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
@@ -0,0 +1,384 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"db-backup/internal/docker"
+	"db-backup/internal/model"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type DockerBackup struct{}
+
+// ValidateDockerOptions checks the Docker options of a backup before it is
+// saved
+func ValidateDockerOptions(cfg *model.DockerOptions) error {
+	if !docker.Allowed() {
+		return fmt.Errorf("docker backups are disabled; set ALLOW_DOCKER_BACKUPS=true to enable them")
+	}
+	if cfg == nil || (cfg.Label == "") == (cfg.Container == "") {
+		return fmt.Errorf("docker backups require exactly one of docker.label, docker.container")
+	}
+	switch cfg.Method {
+	case "", model.DockerDump:
+		switch cfg.Engine {
+		case model.Postgres, model.MySQL, model.MariaDB, model.Mongo, model.Redis:
+		default:
+			return fmt.Errorf("docker.engine must be one of postgre, mysql, mariadb, mongo, redis")
+		}
+	case model.DockerVolumes:
+	default:
+		return fmt.Errorf("docker.method must be one of dump, volumes")
+	}
+	return nil
+}
+
+// Backup finds the container and either runs the engine's dump tool inside
+// it or archives its volumes. Files are named after the container.
+func (b *DockerBackup) Backup(ctx context.Context, req model.BackupRequest) (string, error) {
+	cfg := req.Docker
+	if err := ValidateDockerOptions(cfg); err != nil {
+		return "", err
+	}
+	if req.Mode.OrDefault() != model.ModeFull {
+		return "", unsupportedModeError(req)
+	}
+
+	client, err := docker.NewClient()
+	if err != nil {
+		return "", err
+	}
+	container, err := findContainer(ctx, client, cfg)
+	if err != nil {
+		return "", err
+	}
+
+	named := req
+	if named.Host == "" {
+		named.Host = container.Name
+	}
+
+	if cfg.Method == model.DockerVolumes {
+		if req.Scope.OrDefault() == model.ScopeServer {
+			return "", fmt.Errorf("volume backups always cover the whole container; use scope database")
+		}
+		named.Database = ""
+		filename, err := filepath.Abs(generateFilename(named, "tar.gz"))
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve backup path: %w", err)
+		}
+		return filename, dockerVolumes(ctx, client, container, filename)
+	}
+	return dockerDump(ctx, client, container, named)
+}
+
+// TestConnection checks the container can be found and, for dumps, that the
+// dump tool is installed in it
+func (b *DockerBackup) TestConnection(ctx context.Context, req model.BackupRequest) (*model.ConnectionTestResult, error) {
+	cfg := req.Docker
+	if err := ValidateDockerOptions(cfg); err != nil {
+		return nil, err
+	}
+
+	client, err := docker.NewClient()
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	if _, err := client.Version(ctx); err != nil {
+		return nil, err
+	}
+	result := &model.ConnectionTestResult{LatencyMs: time.Since(start).Milliseconds()}
+
+	container, err := findContainer(ctx, client, cfg)
+	if err != nil {
+		return nil, err
+	}
+	result.ServerVersion = container.Config.Image
+
+	if cfg.Method == model.DockerVolumes {
+		if len(container.Mounts) == 0 {
+			result.Warning = fmt.Sprintf("container %s has no volumes", container.Name)
+		}
+		return result, nil
+	}
+
+	tool := dockerDumpTool(cfg.Engine)
+	if err := client.Exec(ctx, container.ID, []string{tool, "--version"}, nil, io.Discard); err != nil {
+		result.MissingPrivileges = append(result.MissingPrivileges, fmt.Sprintf("%s in container %s", tool, container.Name))
+		result.Warning = err.Error()
+	}
+	return result, nil
+}
+
+// findContainer returns the running container selected by label or name
+func findContainer(ctx context.Context, client *docker.Client, cfg *model.DockerOptions) (*docker.ContainerInfo, error) {
+	id := cfg.Container
+	if cfg.Label != "" {
+		found, err := client.FindByLabel(ctx, cfg.Label)
+		if err != nil {
+			return nil, err
+		}
+		id = found.ID
+	}
+
+	container, err := client.Inspect(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if !container.State.Running || (cfg.Method != model.DockerVolumes && container.State.Paused) {
+		return nil, fmt.Errorf("container %s is not running", container.Name)
+	}
+	return container, nil
+}
+
+func dockerDumpTool(engine model.BackupType) string {
+	switch engine {
+	case model.Postgres:
+		return "pg_dump"
+	case model.MySQL:
+		return "mysqldump"
+	case model.MariaDB:
+		return "mariadb-dump"
+	case model.Mongo:
+		return "mongodump"
+	}
+	return "redis-cli"
+}
+
+// dockerDumpCommand returns the dump command run in the container, its
+// environment, its standard input and the extension of the dump. The tools
+// connect to the database over the container's local socket or loopback
+// address. Passwords never go into the arguments, where ps in the container
+// would show them.
+func dockerDumpCommand(req model.BackupRequest) ([]string, []string, string, string) {
+	serverScope := req.Scope.OrDefault() == model.ScopeServer
+
+	switch engine := req.Docker.Engine; engine {
+	case model.Postgres:
+		username := req.Username
+		if username == "" {
+			username = "postgres"
+		}
+		env := []string{"PGPASSWORD=" + req.Password}
+		if serverScope {
+			return []string{"pg_dumpall", "-U", username}, env, "", "sql"
+		}
+		cmd := []string{"pg_dump", "-U", username}
+		if req.Database != "" {
+			cmd = append(cmd, req.Database)
+		}
+		return cmd, env, "", "sql"
+
+	case model.MySQL, model.MariaDB:
+		username := req.Username
+		if username == "" {
+			username = "root"
+		}
+		cmd := append([]string{dockerDumpTool(engine), "-u", username}, mysqlDumpDefaults...)
+		if serverScope {
+			cmd = append(cmd, "--all-databases")
+		} else {
+			cmd = append(cmd, req.Database)
+		}
+		return cmd, []string{"MYSQL_PWD=" + req.Password}, "", "sql"
+
+	case model.Mongo:
+		// mongodump reads the password from standard input when a user is
+		// given without one
+		cmd := []string{"mongodump", "--archive", "--gzip"}
+		var input string
+		if req.Username != "" {
+			cmd = append(cmd, "--username="+req.Username, "--authenticationDatabase=admin")
+			if req.Password != "" {
+				input = req.Password + "\n"
+			}
+		}
+		if !serverScope && req.Database != "" {
+			cmd = append(cmd, "--db="+req.Database)
+		}
+		return cmd, nil, input, "gz"
+	}
+
+	return nil, []string{"REDISCLI_AUTH=" + req.Password}, "", "rdb"
+}
+
+// dockerDump runs the dump tool in the container and streams its output to
+// the backup file. redis-cli cannot write an RDB to standard output on older
+// versions, so it writes into the container and the file is copied out.
+func dockerDump(ctx context.Context, client *docker.Client, container *docker.ContainerInfo, req model.BackupRequest) (string, error) {
+	if req.Docker.Engine == model.Redis && req.Scope.OrDefault() == model.ScopeServer {
+		return "", fmt.Errorf("server backups are not supported for redis")
+	}
+	if req.Docker.Engine != model.Redis && req.Database == "" && req.Scope.OrDefault() != model.ScopeServer {
+		return "", fmt.Errorf("database is required unless scope is server")
+	}
+
+	cmd, env, input, ext := dockerDumpCommand(req)
+	filename, err := filepath.Abs(generateFilename(req, ext))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve backup path: %w", err)
+	}
+
+	outfile, err := os.Create(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to create backup file: %w", err)
+	}
+
+	if req.Docker.Engine == model.Redis {
+		err = dockerRedisDump(ctx, client, container, env, outfile)
+	} else {
+		var stdin io.Reader
+		if input != "" {
+			stdin = strings.NewReader(input)
+		}
+		err = client.ExecWithInput(ctx, container.ID, cmd, env, stdin, outfile)
+	}
+	if closeErr := outfile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filename)
+		return "", fmt.Errorf("dump in container %s failed: %w", container.Name, err)
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to verify backup file: %w", err)
+	}
+	if info.Size() == 0 {
+		os.Remove(filename)
+		return "", fmt.Errorf("backup file is empty")
+	}
+	if req.Docker.Engine == model.Redis {
+		if err := validateRDB(filename); err != nil {
+			os.Remove(filename)
+			return "", fmt.Errorf("invalid RDB from container %s: %w", container.Name, err)
+		}
+	}
+	return filename, nil
+}
+
+func dockerRedisDump(ctx context.Context, client *docker.Client, container *docker.ContainerInfo, env []string, w io.Writer) error {
+	remote := fmt.Sprintf("/tmp/db-backup-%d.rdb", time.Now().UnixNano())
+	defer func() {
+		if err := client.Exec(context.Background(), container.ID, []string{"rm", "-f", remote}, nil, io.Discard); err != nil {
+			log.Printf("Failed to remove %s from container %s: %v", remote, container.Name, err)
+		}
+	}()
+
+	if err := client.Exec(ctx, container.ID, []string{"redis-cli", "--rdb", remote}, env, io.Discard); err != nil {
+		return err
+	}
+
+	archive, err := client.Archive(ctx, container.ID, remote)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	tr := tar.NewReader(archive)
+	if _, err := tr.Next(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", remote, err)
+	}
+	_, err = io.Copy(w, tr)
+	return err
+}
+
+// dockerVolumes pauses the container so its files are consistent and
+// archives every mount. Entries keep their path inside the container without
+// the leading slash, so /var/lib/postgresql/data/PG_VERSION is stored as
+// var/lib/postgresql/data/PG_VERSION. The container is resumed even when the
+// backup fails.
+func dockerVolumes(ctx context.Context, client *docker.Client, container *docker.ContainerInfo, filename string) error {
+	if len(container.Mounts) == 0 {
+		return fmt.Errorf("container %s has no volumes", container.Name)
+	}
+
+	if !container.State.Paused {
+		if err := client.Pause(ctx, container.ID); err != nil {
+			return err
+		}
+		defer func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := client.Unpause(ctx, container.ID); err != nil {
+				log.Printf("Failed to unpause container %s: %v", container.Name, err)
+			}
+		}()
+	}
+
+	outfile, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %w", err)
+	}
+	defer outfile.Close()
+
+	gz := gzip.NewWriter(outfile)
+	tw := tar.NewWriter(gz)
+
+	for _, mount := range container.Mounts {
+		if err := archiveMount(ctx, client, container.ID, mount, tw); err != nil {
+			os.Remove(filename)
+			return fmt.Errorf("failed to archive %s: %w", mount.Destination, err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		os.Remove(filename)
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		os.Remove(filename)
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	return nil
+}
+
+// archiveMount copies one mount into the archive. The daemon names entries
+// below the base name of the mount, so they are moved under its parent.
+func archiveMount(ctx context.Context, client *docker.Client, id string, mount docker.Mount, tw *tar.Writer) error {
+	archive, err := client.Archive(ctx, id, mount.Destination)
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	parent := strings.TrimPrefix(path.Dir(path.Clean(mount.Destination)), "/")
+	tr := tar.NewReader(archive)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		header.Name = path.Join(parent, header.Name)
+		if header.Typeflag == tar.TypeDir {
+			header.Name += "/"
+		}
+		if header.Typeflag == tar.TypeLink {
+			header.Linkname = path.Join(parent, header.Linkname)
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, tr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"db-backup/internal/model"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ValidateExportOptions checks the export settings of a database
+func ValidateExportOptions(t model.BackupType, opts *model.ExportOptions) error {
+	if opts == nil {
+		return nil
+	}
+	switch t {
+	case model.Postgres, model.MySQL, model.MariaDB, model.SQLite, model.Mongo:
+	default:
+		return fmt.Errorf("exports support Postgres, MySQL, MariaDB, SQLite and MongoDB databases")
+	}
+	if !opts.Format.IsValid() {
+		return fmt.Errorf("invalid export format %q: must be csv, jsonl or parquet", opts.Format)
+	}
+	for _, table := range opts.Tables {
+		if strings.TrimSpace(table) == "" {
+			return fmt.Errorf("export tables must not be empty")
+		}
+	}
+	return nil
+}
+
+// ExportResult describes a flat export of a backup
+type ExportResult struct {
+	FilePath string
+	// Objects and Rows count the exported tables or collections and their
+	// rows or documents
+	Objects int
+	Rows    int64
+}
+
+// Export writes every table or collection of a backup artifact as a CSV,
+// JSON lines or Parquet file into one tar.gz archive, one entry per object
+// named database/schema.name.ext. tables limits the export to the named
+// objects. name is the file name of the source backup, which the archive is
+// named after.
+func Export(ctx context.Context, t model.BackupType, srcPath, name string, format model.ExportFormat, tables []string) (*ExportResult, error) {
+	if !format.IsValid() {
+		return nil, fmt.Errorf("%w: unknown export format %q", ErrInvalidExtract, format)
+	}
+
+	contents, err := ListContents(ctx, t, srcPath)
+	if err != nil {
+		return nil, err
+	}
+	items, err := exportItems(contents.Items, tables)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join("backups", string(t))
+	ensureDir(dir)
+	destPath, err := filepath.Abs(filepath.Join(dir, exportName(name, format)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve output path: %w", err)
+	}
+
+	out, err := os.Create(destPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	result := &ExportResult{FilePath: destPath}
+	for _, item := range items {
+		if err = ctx.Err(); err != nil {
+			break
+		}
+		var rows int64
+		rows, err = exportItem(ctx, t, srcPath, item, format, tw)
+		if err != nil {
+			err = fmt.Errorf("failed to export %s: %w", item.Name, err)
+			break
+		}
+		result.Objects++
+		result.Rows += rows
+	}
+
+	if err == nil {
+		err = tw.Close()
+	}
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(destPath)
+		return nil, err
+	}
+	return result, nil
+}
+
+// exportItems returns the tables and collections to export, in the order the
+// backup lists them
+func exportItems(items []model.ContentItem, tables []string) ([]model.ContentItem, error) {
+	var selected []model.ContentItem
+	matched := make([]bool, len(tables))
+	for _, item := range items {
+		if item.Kind != "table" && item.Kind != "collection" {
+			continue
+		}
+		include := len(tables) == 0
+		for i, table := range tables {
+			if tableMatches(table, item.Database, item.Schema, item.Name) {
+				include, matched[i] = true, true
+			}
+		}
+		if include {
+			selected = append(selected, item)
+		}
+	}
+
+	for i, ok := range matched {
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrObjectNotFound, tables[i])
+		}
+	}
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("%w: the backup holds no tables or collections", ErrObjectNotFound)
+	}
+	return selected, nil
+}
+
+// exportItem extracts one object to a temporary file, converting it to
+// Parquet when asked, and adds it to the archive. The temporary file is
+// needed because tar headers carry the entry size.
+func exportItem(ctx context.Context, t model.BackupType, srcPath string, item model.ContentItem, format model.ExportFormat, tw *tar.Writer) (int64, error) {
+	extract := model.ExtractFormat(format)
+	if format == model.ExportParquet {
+		extract = model.ExtractJSONL
+	}
+
+	tmp, err := os.CreateTemp("", "export-*")
+	if err != nil {
+		return 0, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	rows, err := ExtractObject(ctx, t, srcPath, model.ExtractRequest{
+		Database: item.Database,
+		Schema:   item.Schema,
+		Name:     item.Name,
+		Format:   extract,
+	}, tmp)
+	if err != nil {
+		return 0, err
+	}
+
+	entry := tmp
+	if format == model.ExportParquet {
+		parquet, err := os.CreateTemp("", "export-*.parquet")
+		if err != nil {
+			return 0, fmt.Errorf("failed to create temporary file: %w", err)
+		}
+		defer os.Remove(parquet.Name())
+		defer parquet.Close()
+
+		if _, err := writeParquet(tmp.Name(), parquet); err != nil {
+			return 0, fmt.Errorf("failed to write Parquet: %w", err)
+		}
+		entry = parquet
+	}
+
+	size, err := entry.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := entry.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    exportEntryName(item, format),
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	}); err != nil {
+		return 0, fmt.Errorf("failed to write archive: %w", err)
+	}
+	if _, err := io.Copy(tw, entry); err != nil {
+		return 0, fmt.Errorf("failed to write archive: %w", err)
+	}
+	return rows, nil
+}
+
+// exportEntryName names an archive entry after its object, e.g.
+// shop/public.orders.parquet
+func exportEntryName(item model.ContentItem, format model.ExportFormat) string {
+	safe := func(s string) string { return strings.ReplaceAll(s, "/", "_") }
+	name := safe(item.Name)
+	if item.Schema != "" {
+		name = safe(item.Schema) + "." + name
+	}
+	name += "." + string(format)
+	if item.Database != "" {
+		return path.Join(safe(item.Database), name)
+	}
+	return name
+}
+
+// exportName names the archive after its source, e.g.
+// db1_20240102_030405_shop_export_parquet.tar.gz
+func exportName(source string, format model.ExportFormat) string {
+	stem := strings.TrimSuffix(source, ".gz")
+	for _, known := range []string{".sql", ".tar", ".archive", ".db", ".dump"} {
+		stem = strings.TrimSuffix(stem, known)
+	}
+	return fmt.Sprintf("%s_export_%s.tar.gz", stem, format)
+}
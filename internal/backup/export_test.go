@@ -0,0 +1,218 @@
+package backup
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"db-backup/internal/model"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const exportPostgresDump = `--
+-- PostgreSQL database dump
+--
+
+CREATE TABLE public.orders (
+    id integer NOT NULL,
+    customer text,
+    total numeric(10,2),
+    paid boolean
+);
+
+CREATE TABLE public.notes (
+    id integer,
+    body text
+);
+
+COPY public.orders (id, customer, total, paid) FROM stdin;
+1	Ann	10.50	t
+2	\N	3.00	f
+3	Bob, Jr.	0.99	\N
+\.
+
+COPY public.notes (id, body) FROM stdin;
+1	line\ttab
+\.
+`
+
+const exportMySQLDump = "-- MySQL dump 10.13\n" +
+	"\n" +
+	"CREATE TABLE `items` (\n" +
+	"  `id` int NOT NULL,\n" +
+	"  `name` varchar(64),\n" +
+	"  `price` decimal(6,2)\n" +
+	");\n" +
+	"\n" +
+	"INSERT INTO `items` VALUES (1,'pen',1.50),(2,NULL,2),(3,'it\\'s',0.25);\n"
+
+// runExport exports a dump from a temporary directory and returns the entries
+// of the archive by name
+func runExport(t *testing.T, bt model.BackupType, dump string, format model.ExportFormat, tables []string) (*ExportResult, map[string][]byte) {
+	t.Helper()
+	dir := t.TempDir()
+	src := filepath.Join(dir, "shop.sql")
+	if err := os.WriteFile(src, []byte(dump), 0644); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	result, err := Export(context.Background(), bt, src, "shop.sql", format, tables)
+	if err != nil {
+		t.Fatalf("Export: %v", err)
+	}
+	if want := "shop_export_" + string(format) + ".tar.gz"; filepath.Base(result.FilePath) != want {
+		t.Fatalf("archive = %s, want %s", filepath.Base(result.FilePath), want)
+	}
+
+	file, err := os.Open(result.FilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := map[string][]byte{}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[header.Name] = data
+	}
+	return result, entries
+}
+
+func TestExport(t *testing.T) {
+	tests := []struct {
+		name    string
+		bt      model.BackupType
+		dump    string
+		format  model.ExportFormat
+		tables  []string
+		objects int
+		rows    int64
+		entries map[string]string
+	}{
+		{
+			name:    "postgres csv",
+			bt:      model.Postgres,
+			dump:    exportPostgresDump,
+			format:  model.ExportCSV,
+			objects: 2,
+			rows:    4,
+			entries: map[string]string{
+				"public.orders.csv": "id,customer,total,paid\n1,Ann,10.50,t\n2,,3.00,f\n3,\"Bob, Jr.\",0.99,\n",
+				"public.notes.csv":  "id,body\n1,line\ttab\n",
+			},
+		},
+		{
+			name:    "postgres jsonl with table filter",
+			bt:      model.Postgres,
+			dump:    exportPostgresDump,
+			format:  model.ExportJSONL,
+			tables:  []string{"public.orders"},
+			objects: 1,
+			rows:    3,
+			entries: map[string]string{
+				"public.orders.jsonl": `{"id":"1","customer":"Ann","total":"10.50","paid":"t"}` + "\n" +
+					`{"id":"2","customer":null,"total":"3.00","paid":"f"}` + "\n" +
+					`{"id":"3","customer":"Bob, Jr.","total":"0.99","paid":null}` + "\n",
+			},
+		},
+		{
+			name:    "mysql jsonl",
+			bt:      model.MySQL,
+			dump:    exportMySQLDump,
+			format:  model.ExportJSONL,
+			objects: 1,
+			rows:    3,
+			entries: map[string]string{
+				"items.jsonl": `{"id":1,"name":"pen","price":1.50}` + "\n" +
+					`{"id":2,"name":null,"price":2}` + "\n" +
+					`{"id":3,"name":"it's","price":0.25}` + "\n",
+			},
+		},
+		{
+			name:    "mysql csv",
+			bt:      model.MySQL,
+			dump:    exportMySQLDump,
+			format:  model.ExportCSV,
+			objects: 1,
+			rows:    3,
+			entries: map[string]string{
+				"items.csv": "id,name,price\n1,pen,1.50\n2,,2\n3,it's,0.25\n",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, entries := runExport(t, tt.bt, tt.dump, tt.format, tt.tables)
+			if result.Objects != tt.objects || result.Rows != tt.rows {
+				t.Errorf("objects, rows = %d, %d, want %d, %d", result.Objects, result.Rows, tt.objects, tt.rows)
+			}
+			got := map[string]string{}
+			for name, data := range entries {
+				got[name] = string(data)
+			}
+			if !reflect.DeepEqual(got, tt.entries) {
+				t.Errorf("entries = %q, want %q", got, tt.entries)
+			}
+		})
+	}
+}
+
+func TestExportParquet(t *testing.T) {
+	_, entries := runExport(t, model.MySQL, exportMySQLDump, model.ExportParquet, nil)
+	data, ok := entries["items.parquet"]
+	if !ok {
+		t.Fatalf("entries = %v, want items.parquet", reflect.ValueOf(entries).MapKeys())
+	}
+
+	f := readParquetFile(t, data)
+	if f.rows != 3 || !reflect.DeepEqual(f.columns, []string{"id", "name", "price"}) {
+		t.Fatalf("rows = %d, columns = %v", f.rows, f.columns)
+	}
+	want := [][]interface{}{
+		{int64(1), int64(2), int64(3)},
+		{"pen", nil, "it's"},
+		{1.5, 2.0, 0.25},
+	}
+	if !reflect.DeepEqual(f.values, want) {
+		t.Fatalf("values = %#v, want %#v", f.values, want)
+	}
+}
+
+func TestExportUnknownTable(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "shop.sql")
+	if err := os.WriteFile(src, []byte(exportPostgresDump), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Export(context.Background(), model.Postgres, src, "shop.sql", model.ExportCSV, []string{"public.missing"})
+	if !errors.Is(err, ErrObjectNotFound) {
+		t.Fatalf("err = %v, want ErrObjectNotFound", err)
+	}
+}
@@ -0,0 +1,861 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"db-backup/internal/model"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrObjectNotFound is returned when a backup holds no table or
+	// collection of the requested name
+	ErrObjectNotFound = errors.New("table or collection not found in backup")
+	// ErrInvalidExtract is returned when the object cannot be extracted in
+	// the requested format or the name is ambiguous
+	ErrInvalidExtract = errors.New("cannot extract object")
+)
+
+// ExtractObject writes one table or collection of a backup artifact to w in
+// the requested format, without restoring the rest of the backup. It returns
+// the number of rows or documents written.
+func ExtractObject(ctx context.Context, t model.BackupType, filePath string, req model.ExtractRequest, w io.Writer) (int64, error) {
+	switch req.Format {
+	case model.ExtractSQL, model.ExtractCSV, model.ExtractJSONL, model.ExtractBSON:
+	default:
+		return 0, fmt.Errorf("%w: unknown format %q", ErrInvalidExtract, req.Format)
+	}
+
+	artifact, err := openArtifact(filePath)
+	if err != nil {
+		return 0, err
+	}
+	defer artifact.Close()
+
+	switch artifact.format {
+	case formatPgCustom:
+		return pgRestoreExtract(ctx, filePath, req, w)
+	case formatSQLite:
+		return sqliteExtract(ctx, filePath, req, w)
+	case formatMongoArchive:
+		return mongoExtract(ctx, filePath, req, w)
+	case formatTar:
+		if t == model.Mongo {
+			return mongoExtract(ctx, filePath, req, w)
+		}
+	case formatSQL:
+		return sqlExtract(ctx, artifact.r, t, req, w)
+	}
+
+	return 0, fmt.Errorf("%w: %s artifacts hold no tables or collections", ErrInvalidExtract, artifact.format)
+}
+
+// sqlValue is one value of a row read from a dump
+type sqlValue struct {
+	text string
+	null bool
+	// quoted is set for string literals and COPY fields, whose type is not
+	// known; other values are numbers, booleans or expressions
+	quoted bool
+	// raw is the value as written in an INSERT statement
+	raw string
+}
+
+// rowWriter writes the rows of an extracted table as CSV or JSON lines
+type rowWriter interface {
+	row(columns []string, values []sqlValue) error
+	finish(columns []string) error
+}
+
+func newRowWriter(format model.ExtractFormat, w io.Writer) rowWriter {
+	if format == model.ExtractCSV {
+		return &csvRowWriter{w: csv.NewWriter(w)}
+	}
+	return &jsonRowWriter{w: bufio.NewWriter(w)}
+}
+
+// csvRowWriter writes a header line followed by one line per row. NULL is
+// written as an empty field.
+type csvRowWriter struct {
+	w      *csv.Writer
+	header bool
+}
+
+func (c *csvRowWriter) row(columns []string, values []sqlValue) error {
+	if !c.header {
+		c.header = true
+		if err := c.w.Write(columns); err != nil {
+			return err
+		}
+	}
+	record := make([]string, len(values))
+	for i, value := range values {
+		if !value.null {
+			record[i] = value.text
+		}
+	}
+	return c.w.Write(record)
+}
+
+func (c *csvRowWriter) finish(columns []string) error {
+	if !c.header && len(columns) > 0 {
+		c.header = true
+		c.w.Write(columns)
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// jsonRowWriter writes one JSON object per row with the keys in column order
+type jsonRowWriter struct {
+	w *bufio.Writer
+}
+
+func (j *jsonRowWriter) row(columns []string, values []sqlValue) error {
+	j.w.WriteByte('{')
+	for i, value := range values {
+		if i > 0 {
+			j.w.WriteByte(',')
+		}
+		key, _ := json.Marshal(columns[i])
+		j.w.Write(key)
+		j.w.WriteByte(':')
+		j.w.Write(jsonValue(value))
+	}
+	j.w.WriteString("}\n")
+	return nil
+}
+
+func (j *jsonRowWriter) finish([]string) error {
+	return j.w.Flush()
+}
+
+// jsonValue encodes unquoted numbers and booleans as JSON literals and
+// everything else as a string
+func jsonValue(value sqlValue) []byte {
+	if value.null {
+		return []byte("null")
+	}
+	if !value.quoted {
+		switch lower := strings.ToLower(value.text); {
+		case lower == "true" || lower == "false":
+			return []byte(lower)
+		case json.Valid([]byte(value.text)) && isNumber(value.text):
+			return []byte(value.text)
+		}
+	}
+	encoded, _ := json.Marshal(value.text)
+	return encoded
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+var (
+	sqlCopyColumns = regexp.MustCompile(`^\s*\(([^)]*)\)`)
+	sqlValues      = regexp.MustCompile(`(?i)^\s*VALUES\s*`)
+)
+
+// sqlExtractor copies the CREATE TABLE statement and the rows of one table
+// out of a plain SQL dump. A COPY block or INSERT statement is copied as is
+// for SQL output and decoded for CSV and JSON lines.
+type sqlExtractor struct {
+	req model.ExtractRequest
+	w   io.Writer
+	// rows is nil for SQL output
+	rows rowWriter
+	// backslash enables the backslash escapes of MySQL string literals
+	backslash bool
+
+	database string
+	columns  []string
+	matched  string
+	count    int64
+}
+
+// sqlExtract scans a plain SQL dump from pg_dump, pg_dumpall, mysqldump,
+// mariadb-dump or sqlite3 for the requested table
+func sqlExtract(ctx context.Context, r *bufio.Reader, t model.BackupType, req model.ExtractRequest, w io.Writer) (int64, error) {
+	if req.Format == model.ExtractBSON {
+		return 0, fmt.Errorf("%w: BSON output is only available for MongoDB backups", ErrInvalidExtract)
+	}
+
+	e := &sqlExtractor{
+		req:       req,
+		w:         w,
+		backslash: t == model.MySQL || t == model.MariaDB,
+	}
+	if req.Format != model.ExtractSQL {
+		e.rows = newRowWriter(req.Format, w)
+	}
+
+	// statement collects a CREATE TABLE or INSERT of the table that spans
+	// several lines; copying is set inside a COPY block
+	var statement []byte
+	var copying, skipping bool
+
+	for lineNo := 0; ; lineNo++ {
+		if lineNo%10000 == 0 {
+			if err := ctx.Err(); err != nil {
+				return e.count, err
+			}
+		}
+
+		line, readErr := r.ReadBytes('\n')
+		if len(line) > 0 {
+			var err error
+			switch {
+			case copying || skipping:
+				if bytes.Equal(bytes.TrimRight(line, "\r\n"), []byte(`\.`)) {
+					if copying {
+						err = e.write(line)
+					}
+					copying, skipping = false, false
+				} else if copying {
+					err = e.copyRow(line)
+				}
+			case statement != nil:
+				statement = append(statement, line...)
+				if sqlStatementEnd(statement, e.backslash) {
+					err = e.statement(statement)
+					statement = nil
+				}
+			default:
+				var start bool
+				start, copying, skipping, err = e.line(line)
+				if start {
+					statement = append([]byte{}, line...)
+					if sqlStatementEnd(statement, e.backslash) {
+						err = e.statement(statement)
+						statement = nil
+					}
+				}
+			}
+			if err != nil {
+				return e.count, err
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return e.count, fmt.Errorf("failed to read dump: %w", readErr)
+		}
+	}
+
+	if e.matched == "" {
+		return 0, ErrObjectNotFound
+	}
+	if e.rows != nil {
+		if err := e.rows.finish(e.columns); err != nil {
+			return e.count, fmt.Errorf("failed to write rows: %w", err)
+		}
+	}
+	return e.count, nil
+}
+
+// line handles a line outside COPY blocks and statements of the table. It
+// reports whether a statement of the table starts, or a COPY block of the
+// table or of another table.
+func (e *sqlExtractor) line(line []byte) (start, copying, skipping bool, err error) {
+	if database, ok := sqlSwitchDatabase(line); ok {
+		e.database = database
+		return false, false, false, nil
+	}
+
+	switch {
+	case bytes.HasPrefix(line, []byte("CREATE")), bytes.HasPrefix(line, []byte("create")):
+		if m := sqlCreateTable.FindSubmatch(line); m != nil {
+			ok, err := e.match(string(m[1]))
+			return ok, false, false, err
+		}
+	case bytes.HasPrefix(line, []byte("INSERT")), bytes.HasPrefix(line, []byte("insert")):
+		if m := sqlInsert.FindSubmatch(line); m != nil {
+			ok, err := e.match(string(m[1]))
+			return ok, false, false, err
+		}
+	case bytes.HasPrefix(line, []byte("COPY ")):
+		m := sqlCopy.FindSubmatchIndex(line)
+		if m == nil {
+			return false, false, false, nil
+		}
+		ok, err := e.match(string(line[m[2]:m[3]]))
+		if err != nil || !ok {
+			return false, false, err == nil, err
+		}
+		if c := sqlCopyColumns.FindSubmatch(line[m[3]:]); c != nil {
+			e.columns = splitIdentifiers(string(c[1]))
+		}
+		return false, true, false, e.write(line)
+	}
+	return false, false, false, nil
+}
+
+// match reports whether a table name in the dump is the requested table.
+// The same name in a second database or schema makes the request ambiguous.
+func (e *sqlExtractor) match(qualified string) (bool, error) {
+	schema, name := splitSQLName(qualified)
+	if !strings.EqualFold(name, e.req.Name) ||
+		(e.req.Schema != "" && !strings.EqualFold(schema, e.req.Schema)) ||
+		(e.req.Database != "" && e.database != e.req.Database) {
+		return false, nil
+	}
+
+	key := e.database + "\x00" + schema + "\x00" + name
+	if e.matched != "" && e.matched != key {
+		return false, fmt.Errorf("%w: %s exists more than once in the backup; set database or schema", ErrInvalidExtract, e.req.Name)
+	}
+	e.matched = key
+	return true, nil
+}
+
+// statement handles a complete CREATE TABLE or INSERT statement of the table
+func (e *sqlExtractor) statement(statement []byte) error {
+	if m := sqlCreateTable.FindSubmatchIndex(statement); m != nil {
+		if columns := createTableColumns(statement[m[3]:], e.backslash); len(columns) > 0 {
+			e.columns = columns
+		}
+		return e.write(statement)
+	}
+
+	m := sqlInsert.FindSubmatchIndex(statement)
+	rest := statement[m[3]:]
+	columns := e.columns
+	if c := sqlCopyColumns.FindSubmatchIndex(rest); c != nil {
+		columns = splitIdentifiers(string(rest[c[2]:c[3]]))
+		rest = rest[c[1]:]
+	}
+	v := sqlValues.FindIndex(rest)
+	if v == nil {
+		return fmt.Errorf("unsupported INSERT statement for %s", e.req.Name)
+	}
+
+	tuples, err := parseSQLValues(string(rest[v[1]:]), e.backslash)
+	if err != nil {
+		return fmt.Errorf("failed to parse INSERT statement for %s: %w", e.req.Name, err)
+	}
+	if e.rows == nil {
+		e.count += int64(len(tuples))
+		return e.write(statement)
+	}
+
+	for _, values := range tuples {
+		if err := e.rows.row(rowColumns(columns, len(values)), values); err != nil {
+			return fmt.Errorf("failed to write rows: %w", err)
+		}
+		e.count++
+	}
+	if len(e.columns) == 0 {
+		e.columns = columns
+	}
+	return nil
+}
+
+// copyRow handles one line of a COPY block in PostgreSQL text format
+func (e *sqlExtractor) copyRow(line []byte) error {
+	e.count++
+	if e.rows == nil {
+		return e.write(line)
+	}
+
+	fields := strings.Split(strings.TrimRight(string(line), "\r\n"), "\t")
+	values := make([]sqlValue, len(fields))
+	for i, field := range fields {
+		values[i] = decodeCopyField(field)
+	}
+	if err := e.rows.row(rowColumns(e.columns, len(values)), values); err != nil {
+		return fmt.Errorf("failed to write rows: %w", err)
+	}
+	return nil
+}
+
+func (e *sqlExtractor) write(data []byte) error {
+	if e.rows != nil {
+		return nil
+	}
+	if _, err := e.w.Write(data); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	return nil
+}
+
+// rowColumns returns the column names of a row, numbering columns the dump
+// does not name
+func rowColumns(columns []string, n int) []string {
+	if len(columns) == n {
+		return columns
+	}
+	named := make([]string, n)
+	for i := range named {
+		if i < len(columns) {
+			named[i] = columns[i]
+		} else {
+			named[i] = fmt.Sprintf("column%d", i+1)
+		}
+	}
+	return named
+}
+
+// decodeCopyField decodes a field of COPY text format, where \N is NULL and
+// special characters are backslash escaped
+func decodeCopyField(field string) sqlValue {
+	if field == `\N` {
+		return sqlValue{null: true}
+	}
+	if !strings.Contains(field, `\`) {
+		return sqlValue{text: field, quoted: true}
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(field); i++ {
+		c := field[i]
+		if c != '\\' || i+1 == len(field) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch c = field[i]; c {
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case 'x':
+			end := i + 1
+			for end < len(field) && end < i+3 && isHexDigit(field[end]) {
+				end++
+			}
+			if end == i+1 {
+				b.WriteByte('x')
+				continue
+			}
+			v, _ := strconv.ParseUint(field[i+1:end], 16, 8)
+			b.WriteByte(byte(v))
+			i = end - 1
+		case '0', '1', '2', '3', '4', '5', '6', '7':
+			end := i + 1
+			for end < len(field) && end < i+3 && field[end] >= '0' && field[end] <= '7' {
+				end++
+			}
+			v, _ := strconv.ParseUint(field[i:end], 8, 8)
+			b.WriteByte(byte(v))
+			i = end - 1
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return sqlValue{text: b.String(), quoted: true}
+}
+
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// sqlStatementEnd reports whether a statement ends with a semicolon outside
+// any quotes
+func sqlStatementEnd(statement []byte, backslash bool) bool {
+	var quote byte
+	for i := 0; i < len(statement); i++ {
+		c := statement[i]
+		switch {
+		case quote != 0 && backslash && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote == 0 && (c == '\'' || c == '"' || c == '`'):
+			quote = c
+		}
+	}
+	return quote == 0 && bytes.HasSuffix(bytes.TrimSpace(statement), []byte(";"))
+}
+
+// splitSQLList splits s at commas that are outside quotes and parentheses
+func splitSQLList(s string, backslash bool) []string {
+	var parts []string
+	var quote byte
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0 && backslash && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ',' && depth == 0:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+func splitIdentifiers(list string) []string {
+	var names []string
+	for _, part := range splitSQLList(list, false) {
+		if part = strings.TrimSpace(part); part != "" {
+			_, name := splitSQLName(part)
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// tableConstraints start the lines of a CREATE TABLE body that do not
+// define a column
+var tableConstraints = []string{"CONSTRAINT", "PRIMARY", "UNIQUE", "KEY", "INDEX", "FOREIGN", "CHECK", "FULLTEXT", "SPATIAL", "EXCLUDE", "LIKE", "PERIOD"}
+
+// createTableColumns returns the column names defined in the body of a
+// CREATE TABLE statement, which starts after the table name
+func createTableColumns(body []byte, backslash bool) []string {
+	start := bytes.IndexByte(body, '(')
+	if start < 0 {
+		return nil
+	}
+	end := closingParen(body, start, backslash)
+	if end < 0 {
+		return nil
+	}
+
+	var columns []string
+	for _, definition := range splitSQLList(string(body[start+1:end]), backslash) {
+		definition = strings.TrimSpace(definition)
+		if definition == "" {
+			continue
+		}
+
+		var name string
+		if quote := definition[0]; quote == '"' || quote == '`' {
+			end := strings.IndexByte(definition[1:], quote)
+			if end < 0 {
+				continue
+			}
+			name = definition[1 : end+1]
+		} else {
+			name, _, _ = strings.Cut(definition, " ")
+			keyword := strings.ToUpper(name)
+			constraint := false
+			for _, c := range tableConstraints {
+				if keyword == c {
+					constraint = true
+				}
+			}
+			if constraint {
+				continue
+			}
+		}
+		columns = append(columns, name)
+	}
+	return columns
+}
+
+// closingParen returns the offset of the parenthesis that closes the one at
+// start, skipping quoted text, or -1
+func closingParen(s []byte, start int, backslash bool) int {
+	var quote byte
+	depth := 0
+	for i := start; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0 && backslash && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// parseSQLValues parses the row tuples of an INSERT statement, the text after
+// VALUES. String literals follow MySQL escaping when backslash is set and
+// standard SQL otherwise; other values are kept as written.
+func parseSQLValues(s string, backslash bool) ([][]sqlValue, error) {
+	var tuples [][]sqlValue
+	i := 0
+	skipSpace := func() {
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t' || s[i] == '\n' || s[i] == '\r') {
+			i++
+		}
+	}
+
+	for {
+		skipSpace()
+		if i >= len(s) || s[i] != '(' {
+			return nil, fmt.Errorf("expected ( at offset %d", i)
+		}
+		i++
+
+		var values []sqlValue
+		for {
+			skipSpace()
+			value, end, err := parseSQLValue(s, i, backslash)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			i = end
+			skipSpace()
+			if i >= len(s) {
+				return nil, fmt.Errorf("unterminated row")
+			}
+			if s[i] == ')' {
+				i++
+				break
+			}
+			if s[i] != ',' {
+				return nil, fmt.Errorf("unexpected %q at offset %d", s[i], i)
+			}
+			i++
+		}
+		tuples = append(tuples, values)
+
+		skipSpace()
+		if i >= len(s) || s[i] == ';' {
+			return tuples, nil
+		}
+		if s[i] != ',' {
+			return nil, fmt.Errorf("unexpected %q at offset %d", s[i], i)
+		}
+		i++
+	}
+}
+
+// sqlIntroducer matches a MySQL character set introducer such as _binary
+var sqlIntroducer = regexp.MustCompile(`^_[A-Za-z0-9]+\s*'`)
+
+// parseSQLValue parses one value starting at i and returns it with the offset
+// after it
+func parseSQLValue(s string, i int, backslash bool) (sqlValue, int, error) {
+	origin := i
+	if m := sqlIntroducer.FindStringIndex(s[i:]); m != nil {
+		i += m[1] - 1
+	}
+	escapes := backslash
+	if i+1 < len(s) && (s[i] == 'E' || s[i] == 'e') && s[i+1] == '\'' {
+		escapes = true
+		i++
+	}
+
+	if i < len(s) && s[i] == '\'' {
+		text, end, err := parseSQLString(s, i, escapes)
+		return sqlValue{text: text, quoted: true, raw: s[origin:end]}, end, err
+	}
+
+	start, depth := i, 0
+	var quote byte
+	for ; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0 && backslash && c == '\\':
+			i++
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')' && depth > 0:
+			depth--
+		case (c == ',' || c == ')') && depth == 0:
+			text := strings.TrimSpace(s[start:i])
+			return sqlValue{text: text, null: strings.EqualFold(text, "NULL"), raw: text}, i, nil
+		}
+	}
+	return sqlValue{}, i, fmt.Errorf("unterminated value")
+}
+
+// parseSQLString parses a quoted string literal starting at i, where a
+// doubled quote stands for one quote
+func parseSQLString(s string, i int, escapes bool) (string, int, error) {
+	var b strings.Builder
+	for i++; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\'' && i+1 < len(s) && s[i+1] == '\'':
+			b.WriteByte('\'')
+			i++
+		case c == '\'':
+			return b.String(), i + 1, nil
+		case c == '\\' && escapes && i+1 < len(s):
+			i++
+			switch s[i] {
+			case '0':
+				b.WriteByte(0)
+			case 'b':
+				b.WriteByte('\b')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'Z':
+				b.WriteByte(0x1a)
+			default:
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", i, fmt.Errorf("unterminated string")
+}
+
+// pgRestoreExtract converts the table from a pg_dump custom-format archive to
+// SQL with pg_restore -t and reads it like a plain dump
+func pgRestoreExtract(ctx context.Context, filePath string, req model.ExtractRequest, w io.Writer) (int64, error) {
+	if req.Format == model.ExtractBSON {
+		return 0, fmt.Errorf("%w: BSON output is only available for MongoDB backups", ErrInvalidExtract)
+	}
+
+	args := []string{"-f", "-", "-t", req.Name}
+	if req.Schema != "" {
+		args = append(args, "-n", req.Schema)
+	}
+	args = append(args, filePath)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, resolveExecutable("pg_restore"), args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, fmt.Errorf("failed to start pg_restore: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("failed to start pg_restore: %w", err)
+	}
+
+	// The custom format holds a single database, which pg_restore does not name
+	req.Database = ""
+	count, extractErr := sqlExtract(ctx, bufio.NewReaderSize(stdout, 64*1024), model.Postgres, req, w)
+	if extractErr != nil {
+		cancel()
+		cmd.Wait()
+		return count, extractErr
+	}
+	if err := cmd.Wait(); err != nil {
+		return count, fmt.Errorf("pg_restore failed: %s, output: %s", err, stderr.String())
+	}
+	return count, nil
+}
+
+// sqliteExtract reads the table from a SQLite database file with sqlite3
+func sqliteExtract(ctx context.Context, filePath string, req model.ExtractRequest, w io.Writer) (int64, error) {
+	if req.Format == model.ExtractBSON {
+		return 0, fmt.Errorf("%w: BSON output is only available for MongoDB backups", ErrInvalidExtract)
+	}
+
+	binPath := resolveExecutable("sqlite3")
+	literal := "'" + strings.ReplaceAll(req.Name, "'", "''") + "'"
+
+	output, err := exec.CommandContext(ctx, binPath, "-readonly", filePath,
+		"SELECT name FROM sqlite_master WHERE type = 'table' AND name = "+literal+" COLLATE NOCASE").CombinedOutput()
+	if err != nil {
+		return 0, fmt.Errorf("sqlite3 failed: %s, output: %s", err, string(output))
+	}
+	names := splitLines(output)
+	if len(names) == 0 {
+		return 0, ErrObjectNotFound
+	}
+	name := names[0]
+	quoted := `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+
+	count, err := exec.CommandContext(ctx, binPath, "-readonly", filePath, "SELECT count(*) FROM "+quoted).Output()
+	if err != nil {
+		return 0, fmt.Errorf("sqlite3 failed: %w", err)
+	}
+	rows, _ := strconv.ParseInt(strings.TrimSpace(string(count)), 10, 64)
+
+	var cmd *exec.Cmd
+	switch req.Format {
+	case model.ExtractSQL:
+		// .dump takes a LIKE pattern; escape its wildcards
+		pattern := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(name)
+		cmd = exec.CommandContext(ctx, binPath, "-readonly", filePath, ".dump '"+strings.ReplaceAll(pattern, "'", "''")+"'")
+	case model.ExtractCSV:
+		cmd = exec.CommandContext(ctx, binPath, "-readonly", "-csv", "-header", filePath, "SELECT * FROM "+quoted)
+	case model.ExtractJSONL:
+		return rows, sqliteJSONLines(ctx, binPath, filePath, quoted, w)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return 0, fmt.Errorf("sqlite3 failed: %s, output: %s", err, stderr.String())
+	}
+	return rows, nil
+}
+
+// sqliteJSONLines converts the JSON array sqlite3 -json prints into one
+// object per line
+func sqliteJSONLines(ctx context.Context, binPath, filePath, table string, w io.Writer) error {
+	cmd := exec.CommandContext(ctx, binPath, "-readonly", "-json", filePath, "SELECT * FROM "+table)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to start sqlite3: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start sqlite3: %w", err)
+	}
+
+	out := bufio.NewWriter(w)
+	decoder := json.NewDecoder(stdout)
+	// An empty table prints nothing at all
+	if _, err := decoder.Token(); err == nil {
+		for decoder.More() {
+			var row json.RawMessage
+			if err := decoder.Decode(&row); err != nil {
+				cmd.Process.Kill()
+				cmd.Wait()
+				return fmt.Errorf("failed to read sqlite3 output: %w", err)
+			}
+			out.Write(row)
+			out.WriteByte('\n')
+		}
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("sqlite3 failed: %s, output: %s", err, stderr.String())
+	}
+	return out.Flush()
+}
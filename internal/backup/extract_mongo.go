@@ -0,0 +1,290 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"db-backup/internal/model"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// mongoExtract writes the documents of one collection of a mongodump archive
+// or native dump. BSON output can be loaded with mongorestore and JSON lines,
+// written as relaxed Extended JSON, with mongoimport. CSV has a column per
+// top-level field and needs a first pass to collect the fields.
+func mongoExtract(ctx context.Context, filePath string, req model.ExtractRequest, w io.Writer) (int64, error) {
+	var count int64
+	switch req.Format {
+	case model.ExtractBSON:
+		err := mongoDocuments(ctx, filePath, req, func(doc []byte) error {
+			count++
+			_, err := w.Write(doc)
+			return err
+		})
+		return count, err
+
+	case model.ExtractJSONL:
+		out := bufio.NewWriter(w)
+		err := mongoDocuments(ctx, filePath, req, func(doc []byte) error {
+			line, err := bson.MarshalExtJSON(bson.Raw(doc), false, false)
+			if err != nil {
+				return fmt.Errorf("failed to convert document: %w", err)
+			}
+			count++
+			out.Write(line)
+			return out.WriteByte('\n')
+		})
+		if err != nil {
+			return count, err
+		}
+		return count, out.Flush()
+
+	case model.ExtractCSV:
+		var fields []string
+		seen := make(map[string]bool)
+		err := mongoDocuments(ctx, filePath, req, func(doc []byte) error {
+			elements, err := bson.Raw(doc).Elements()
+			if err != nil {
+				return fmt.Errorf("invalid document: %w", err)
+			}
+			for _, element := range elements {
+				if key := element.Key(); !seen[key] {
+					seen[key] = true
+					fields = append(fields, key)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+
+		out := csv.NewWriter(w)
+		if len(fields) > 0 {
+			out.Write(fields)
+		}
+		err = mongoDocuments(ctx, filePath, req, func(doc []byte) error {
+			record := make([]string, len(fields))
+			for i, field := range fields {
+				record[i] = csvField(bson.Raw(doc).Lookup(field))
+			}
+			count++
+			return out.Write(record)
+		})
+		if err != nil {
+			return count, err
+		}
+		out.Flush()
+		return count, out.Error()
+	}
+
+	return 0, fmt.Errorf("%w: SQL output is not available for MongoDB backups", ErrInvalidExtract)
+}
+
+// csvField formats a BSON value for CSV. Nested documents, arrays and other
+// types are written as Extended JSON; a missing field or null is empty.
+func csvField(value bson.RawValue) string {
+	switch value.Type {
+	case 0, bsontype.Null, bsontype.Undefined:
+		return ""
+	case bsontype.String:
+		return value.StringValue()
+	case bsontype.ObjectID:
+		return value.ObjectID().Hex()
+	case bsontype.Int32:
+		return strconv.FormatInt(int64(value.Int32()), 10)
+	case bsontype.Int64:
+		return strconv.FormatInt(value.Int64(), 10)
+	case bsontype.Double:
+		return strconv.FormatFloat(value.Double(), 'g', -1, 64)
+	case bsontype.Boolean:
+		return strconv.FormatBool(value.Boolean())
+	case bsontype.DateTime:
+		return value.Time().UTC().Format(time.RFC3339Nano)
+	}
+
+	// Wrap the value in a document to encode it as relaxed Extended JSON
+	encoded, err := bson.MarshalExtJSON(bson.D{{Key: "v", Value: value}}, false, false)
+	if err != nil {
+		return value.String()
+	}
+	return string(bytes.TrimSuffix(bytes.TrimPrefix(encoded, []byte(`{"v":`)), []byte("}")))
+}
+
+// mongoNamespace tracks which database the requested collection was found
+// in, so the same name in a second database is reported as ambiguous
+type mongoNamespace struct {
+	req     model.ExtractRequest
+	matched string
+}
+
+func (n *mongoNamespace) match(database, collection string) (bool, error) {
+	if collection != n.req.Name || (n.req.Database != "" && database != n.req.Database) {
+		return false, nil
+	}
+	if n.matched != "" && n.matched != database {
+		return false, fmt.Errorf("%w: %s exists in databases %s and %s; set database", ErrInvalidExtract, collection, n.matched, database)
+	}
+	n.matched = database
+	return true, nil
+}
+
+// mongoDocuments calls fn for every document of the requested collection
+func mongoDocuments(ctx context.Context, filePath string, req model.ExtractRequest, fn func(doc []byte) error) error {
+	artifact, err := openArtifact(filePath)
+	if err != nil {
+		return err
+	}
+	defer artifact.Close()
+
+	namespace := &mongoNamespace{req: req}
+	if artifact.format == formatTar {
+		err = mongoTarDocuments(ctx, artifact.r, namespace, fn)
+	} else {
+		err = mongoArchiveDocuments(ctx, artifact.r, namespace, fn)
+	}
+	if err != nil {
+		return err
+	}
+	if namespace.matched == "" {
+		return ErrObjectNotFound
+	}
+	return nil
+}
+
+// mongoArchiveDocuments reads a mongodump --archive stream; see
+// mongoArchiveContents for its layout
+func mongoArchiveDocuments(ctx context.Context, r *bufio.Reader, namespace *mongoNamespace, fn func(doc []byte) error) error {
+	if _, err := r.Discard(len(mongoArchiveMagic)); err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	prelude := true
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		header, err := readBSONDocument(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header == nil {
+			continue
+		}
+
+		matched := false
+		if !prelude {
+			var ns struct {
+				Database   string `bson:"db"`
+				Collection string `bson:"collection"`
+			}
+			if err := bson.Unmarshal(header, &ns); err != nil {
+				return fmt.Errorf("invalid namespace header: %w", err)
+			}
+			if ns.Database != "" {
+				if matched, err = namespace.match(ns.Database, ns.Collection); err != nil {
+					return err
+				}
+			}
+		}
+
+		for {
+			doc, err := readBSONDocument(r)
+			if err != nil {
+				return fmt.Errorf("failed to read archive: %w", err)
+			}
+			if doc == nil {
+				break
+			}
+
+			if prelude {
+				// Empty collections have no block of their own
+				var collection struct {
+					Database   string `bson:"db"`
+					Collection string `bson:"collection"`
+				}
+				if err := bson.Unmarshal(doc, &collection); err != nil {
+					return fmt.Errorf("invalid collection metadata: %w", err)
+				}
+				if _, err := namespace.match(collection.Database, collection.Collection); err != nil {
+					return err
+				}
+			} else if matched {
+				if err := fn(doc); err != nil {
+					return err
+				}
+			}
+		}
+
+		prelude = false
+	}
+}
+
+// mongoTarDocuments reads the dump/<db>/<collection>.bson files of a native
+// MongoDB dump
+func mongoTarDocuments(ctx context.Context, r io.Reader, namespace *mongoNamespace, fn func(doc []byte) error) error {
+	tr := tar.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		name, ok := strings.CutPrefix(strings.TrimPrefix(header.Name, "./"), "dump/")
+		if !ok {
+			continue
+		}
+		parts := strings.SplitN(name, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		collection, ok := strings.CutSuffix(parts[1], ".bson")
+		if !ok {
+			continue
+		}
+		matched, err := namespace.match(parts[0], collection)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			continue
+		}
+
+		br := bufio.NewReaderSize(tr, 64*1024)
+		for {
+			doc, err := readBSONDocument(br)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return fmt.Errorf("%s: %w", header.Name, err)
+			}
+			if doc == nil {
+				continue
+			}
+			if err := fn(doc); err != nil {
+				return err
+			}
+		}
+	}
+}
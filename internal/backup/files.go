@@ -0,0 +1,431 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"db-backup/internal/model"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type FilesBackup struct{}
+
+// Backup archives the configured paths into a tar, gzipped unless compression
+// is none. Entries keep their absolute path without the leading slash, so
+// /var/www/uploads/a.jpg is stored as var/www/uploads/a.jpg.
+func (b *FilesBackup) Backup(ctx context.Context, req model.BackupRequest) (string, error) {
+	cfg := req.Files
+	if err := ValidateFilesOptions(cfg); err != nil {
+		return "", err
+	}
+	if req.Scope.OrDefault() == model.ScopeServer {
+		return "", fmt.Errorf("server backups are not supported for %s", req.Type)
+	}
+	if req.Mode.OrDefault() != model.ModeFull {
+		return "", unsupportedModeError(req)
+	}
+
+	named := req
+	if named.Host == "" {
+		named.Host = "local"
+	}
+	if named.Database == "" {
+		named.Database = filepath.Base(cfg.Paths[0])
+	}
+
+	extension := "tar.gz"
+	if cfg.Compression == model.CompressionNone {
+		extension = "tar"
+	}
+	filename, err := filepath.Abs(generateFilename(named, extension))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve backup path: %w", err)
+	}
+
+	outfile, err := os.Create(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to create backup file: %w", err)
+	}
+	defer outfile.Close()
+
+	var w io.Writer = outfile
+	var gz *gzip.Writer
+	if cfg.Compression != model.CompressionNone {
+		gz = gzip.NewWriter(outfile)
+		w = gz
+	}
+	tw := tar.NewWriter(w)
+
+	for _, root := range cfg.Paths {
+		if err := archivePath(ctx, tw, filepath.Clean(root), filename, cfg); err != nil {
+			os.Remove(filename)
+			return "", err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		os.Remove(filename)
+		return "", fmt.Errorf("failed to finish archive: %w", err)
+	}
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			os.Remove(filename)
+			return "", fmt.Errorf("failed to finish archive: %w", err)
+		}
+	}
+
+	return filename, nil
+}
+
+// archivePath walks one root and writes the entries that pass the filters.
+// The archive being written is skipped in case it lives under the root.
+func archivePath(ctx context.Context, tw *tar.Writer, root, outfile string, cfg *model.FilesOptions) error {
+	if _, err := os.Lstat(root); err != nil {
+		return fmt.Errorf("failed to access %s: %w", root, err)
+	}
+
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Files removed while the walk runs are not an error
+			if os.IsNotExist(err) && p != root {
+				return nil
+			}
+			return fmt.Errorf("failed to read %s: %w", p, err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if p == outfile {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if rel != "." && matchesAny(cfg.Exclude, rel) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		// With include patterns only matching files are stored; their
+		// directories are recreated on extraction
+		if d.IsDir() {
+			if len(cfg.Include) > 0 {
+				return nil
+			}
+		} else if len(cfg.Include) > 0 && !matchesAny(cfg.Include, rel) {
+			return nil
+		}
+
+		name := strings.TrimPrefix(filepath.ToSlash(p), "/")
+		if err := addPathToTar(tw, p, name, d); err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		return nil
+	})
+}
+
+// addPathToTar writes a directory, regular file or symlink to the archive.
+// Other file types such as sockets are skipped.
+func addPathToTar(tw *tar.Writer, p, name string, d fs.DirEntry) error {
+	info, err := d.Info()
+	if err != nil {
+		return err
+	}
+
+	var link string
+	switch {
+	case info.Mode().IsRegular(), info.IsDir():
+	case info.Mode()&fs.ModeSymlink != 0:
+		if link, err = os.Readlink(p); err != nil {
+			return err
+		}
+	default:
+		log.Printf("Skipping %s: unsupported file type %s", p, info.Mode().Type())
+		return nil
+	}
+
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return fmt.Errorf("failed to create tar header for %s: %w", p, err)
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+
+	if !info.Mode().IsRegular() {
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write tar header: %w", err)
+		}
+		return nil
+	}
+
+	file, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write tar header: %w", err)
+	}
+	// Copy exactly the size in the header; a file that grows while it is
+	// read is cut at the size it had when the walk reached it
+	if _, err := io.CopyN(tw, file, header.Size); err != nil {
+		return fmt.Errorf("failed to write %s to archive: %w", p, err)
+	}
+
+	return nil
+}
+
+// matchesAny reports whether a slash-separated relative path matches one of
+// the patterns. Patterns without a slash match the base name at any depth.
+func matchesAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		target := rel
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(rel)
+		}
+		if ok, _ := path.Match(pattern, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateFilesOptions checks the paths and patterns of a files backup
+func ValidateFilesOptions(cfg *model.FilesOptions) error {
+	if cfg == nil || len(cfg.Paths) == 0 {
+		return fmt.Errorf("files backups require files.paths")
+	}
+	for _, p := range cfg.Paths {
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("files.paths must be absolute: %s", p)
+		}
+		if err := CheckAllowedPath(p); err != nil {
+			return fmt.Errorf("files.paths: %w", err)
+		}
+	}
+	for _, pattern := range append(append([]string{}, cfg.Include...), cfg.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	switch cfg.Compression {
+	case "", model.CompressionGzip, model.CompressionNone:
+	default:
+		return fmt.Errorf("files.compression must be one of gzip, none")
+	}
+	return nil
+}
+
+// allowedRoots returns the resolved directories listed in
+// FILES_ALLOWED_ROOTS, separated by commas or the OS path list separator
+func allowedRoots() ([]string, error) {
+	var roots []string
+	for _, root := range strings.FieldsFunc(os.Getenv("FILES_ALLOWED_ROOTS"), func(r rune) bool {
+		return r == ',' || r == filepath.ListSeparator
+	}) {
+		if root = strings.TrimSpace(root); root == "" {
+			continue
+		}
+		if !filepath.IsAbs(root) {
+			return nil, fmt.Errorf("FILES_ALLOWED_ROOTS must list absolute paths: %s", root)
+		}
+		resolved, err := resolvePath(root)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, resolved)
+	}
+	return roots, nil
+}
+
+// resolvePath cleans a path and resolves the symlinks in the longest part of
+// it that exists, so paths that are yet to be created can be checked too
+func resolvePath(p string) (string, error) {
+	p = filepath.Clean(p)
+	var rest []string
+	for {
+		resolved, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			return filepath.Join(append([]string{resolved}, rest...)...), nil
+		}
+		if !os.IsNotExist(err) {
+			return "", fmt.Errorf("failed to resolve %s: %w", p, err)
+		}
+		parent := filepath.Dir(p)
+		if parent == p {
+			return "", fmt.Errorf("failed to resolve %s: %w", p, err)
+		}
+		rest = append([]string{filepath.Base(p)}, rest...)
+		p = parent
+	}
+}
+
+// CheckAllowedPath reports an error unless a path lies below one of the
+// FILES_ALLOWED_ROOTS once symlinks are resolved. Files backups and restores
+// read and write anywhere the server can, and anyone who can call the API
+// could use them to read secrets or replace files, so they are disabled until
+// roots are configured.
+func CheckAllowedPath(p string) error {
+	roots, err := allowedRoots()
+	if err != nil {
+		return err
+	}
+	if len(roots) == 0 {
+		return fmt.Errorf("files backups and restores are disabled; set FILES_ALLOWED_ROOTS to enable them")
+	}
+
+	resolved, err := resolvePath(p)
+	if err != nil {
+		return err
+	}
+	for _, root := range roots {
+		if resolved == root || strings.HasPrefix(resolved, strings.TrimSuffix(root, string(filepath.Separator))+string(filepath.Separator)) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s is outside FILES_ALLOWED_ROOTS", p)
+}
+
+// ExtractArchive extracts a files backup below target and returns the number
+// of files written. Gzip compression is detected from the content. Entries
+// that would land outside target are rejected, and existing files are only
+// replaced with overwrite. The target must be below FILES_ALLOWED_ROOTS.
+func ExtractArchive(ctx context.Context, src, target string, overwrite bool) (int, error) {
+	if err := CheckAllowedPath(target); err != nil {
+		return 0, err
+	}
+
+	file, err := os.Open(src)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer file.Close()
+
+	br := bufio.NewReader(file)
+	var r io.Reader = br
+	if magic, err := br.Peek(2); err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		gz, err := gzip.NewReader(br)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read gzip archive: %w", err)
+		}
+		defer gz.Close()
+		r = gz
+	}
+
+	if err := ensureDir(target); err != nil {
+		return 0, fmt.Errorf("failed to create %s: %w", target, err)
+	}
+	root, err := filepath.EvalSymlinks(target)
+	if err != nil {
+		return 0, fmt.Errorf("failed to resolve %s: %w", target, err)
+	}
+	if err := CheckAllowedPath(root); err != nil {
+		return 0, err
+	}
+
+	tr := tar.NewReader(r)
+	files := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return files, err
+		}
+
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return files, fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		name := path.Clean(strings.TrimPrefix(header.Name, "/"))
+		if name == "." {
+			continue
+		}
+		if name == ".." || strings.HasPrefix(name, "../") {
+			return files, fmt.Errorf("archive entry %s escapes the target path", header.Name)
+		}
+		dest := filepath.Join(root, filepath.FromSlash(name))
+
+		mode := fs.FileMode(header.Mode).Perm()
+		if header.Typeflag == tar.TypeDir {
+			if err := ensureDir(dest); err != nil {
+				return files, fmt.Errorf("failed to create %s: %w", dest, err)
+			}
+			os.Chmod(dest, mode|0700)
+			continue
+		}
+
+		if err := ensureDir(filepath.Dir(dest)); err != nil {
+			return files, fmt.Errorf("failed to create %s: %w", filepath.Dir(dest), err)
+		}
+		// A symlinked directory from the archive must not redirect later
+		// entries outside the target
+		parent, err := filepath.EvalSymlinks(filepath.Dir(dest))
+		if err != nil {
+			return files, fmt.Errorf("failed to resolve %s: %w", filepath.Dir(dest), err)
+		}
+		if parent != root && !strings.HasPrefix(parent, root+string(filepath.Separator)) {
+			return files, fmt.Errorf("archive entry %s escapes the target path", header.Name)
+		}
+
+		if _, err := os.Lstat(dest); err == nil {
+			if !overwrite {
+				return files, fmt.Errorf("%s already exists", dest)
+			}
+			if err := os.RemoveAll(dest); err != nil {
+				return files, fmt.Errorf("failed to replace %s: %w", dest, err)
+			}
+		}
+
+		switch header.Typeflag {
+		case tar.TypeSymlink:
+			if err := os.Symlink(header.Linkname, dest); err != nil {
+				return files, fmt.Errorf("failed to create symlink %s: %w", dest, err)
+			}
+		case tar.TypeReg:
+			if err := extractFile(tr, dest, mode); err != nil {
+				return files, err
+			}
+			os.Chtimes(dest, header.ModTime, header.ModTime)
+			files++
+		default:
+			log.Printf("Skipping %s: unsupported archive entry type %c", header.Name, header.Typeflag)
+		}
+	}
+
+	return files, nil
+}
+
+func extractFile(r io.Reader, dest string, mode fs.FileMode) error {
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_EXCL, mode)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dest, err)
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, r); err != nil {
+		return fmt.Errorf("failed to write %s: %w", dest, err)
+	}
+	return nil
+}
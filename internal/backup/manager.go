@@ -7,6 +7,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -14,6 +15,32 @@ type Strategy interface {
 	Backup(ctx context.Context, req model.BackupRequest) (string, error)
 }
 
+// VersionChecker is implemented by strategies that compare their client tool
+// with the server before a backup. An error means the dump would fail and the
+// backup should not run; a warning is worth recording but not fatal.
+type VersionChecker interface {
+	CheckVersion(ctx context.Context, req model.BackupRequest) (warning string, err error)
+}
+
+// DatabaseLister is implemented by strategies that can enumerate the databases
+// on a server, which is required for per-database server backups.
+type DatabaseLister interface {
+	ListDatabases(ctx context.Context, req model.BackupRequest) ([]string, error)
+}
+
+// Discoverer is implemented by strategies that can report the databases on a
+// server along with their schemas and approximate sizes.
+type Discoverer interface {
+	Discover(ctx context.Context, req model.BackupRequest) ([]model.DiscoveredDatabase, error)
+}
+
+// ConnectionTester is implemented by strategies that can connect and
+// authenticate against a server without taking a backup. An error means the
+// server could not be reached or rejected the credentials.
+type ConnectionTester interface {
+	TestConnection(ctx context.Context, req model.BackupRequest) (*model.ConnectionTestResult, error)
+}
+
 func NewStrategy(t model.BackupType) (Strategy, error) {
 	switch t {
 	case model.Postgres:
@@ -24,12 +51,49 @@ func NewStrategy(t model.BackupType) (Strategy, error) {
 		return &MongoBackup{}, nil
 	case model.Redis:
 		return &RedisBackup{}, nil
+	case model.SQLite:
+		return &SQLiteBackup{}, nil
+	case model.MariaDB:
+		return &MariaDBBackup{}, nil
+	case model.Custom:
+		return &CustomBackup{}, nil
+	case model.Files:
+		return &FilesBackup{}, nil
+	case model.Docker:
+		return &DockerBackup{}, nil
 	default:
 		return nil, fmt.Errorf("unsupported backup type: %s", t)
 	}
 }
 
+// TestConnection connects to the server of a backup request and reports the
+// latency, server version and whether the account has the privileges to dump
+func TestConnection(ctx context.Context, req model.BackupRequest) model.ConnectionTestResult {
+	strategy, err := NewStrategy(req.Type)
+	if err != nil {
+		return model.ConnectionTestResult{Error: err.Error()}
+	}
+
+	tester, ok := strategy.(ConnectionTester)
+	if !ok {
+		return model.ConnectionTestResult{Error: fmt.Sprintf("connection tests are not supported for %s", req.Type)}
+	}
+
+	result, err := tester.TestConnection(ctx, req)
+	if err != nil {
+		return model.ConnectionTestResult{Error: err.Error()}
+	}
+	result.Success = true
+	result.CanDump = len(result.MissingPrivileges) == 0
+
+	return *result
+}
+
 func resolveExecutable(binName string) string {
+	if path := os.Getenv(toolEnvName(binName, 0)); path != "" {
+		return path
+	}
+
 	path, err := exec.LookPath(binName)
 	if err == nil {
 		return path
@@ -52,6 +116,12 @@ func resolveExecutable(binName string) string {
 	return binName
 }
 
+// toolAvailable reports whether a client binary can be found
+func toolAvailable(binName string) bool {
+	_, err := exec.LookPath(resolveExecutable(binName))
+	return err == nil
+}
+
 func ensureDir(path string) error {
 	return os.MkdirAll(path, 0755)
 }
@@ -61,8 +131,41 @@ func generateFilename(req model.BackupRequest, ext string) string {
 	dir := filepath.Join("backups", string(req.Type))
 	ensureDir(dir)
 	var dbPart string
-	if req.Database != "" {
+	if req.Scope.OrDefault() == model.ScopeServer {
+		dbPart = "_all"
+	} else if req.Database != "" {
 		dbPart = fmt.Sprintf("_%s", req.Database)
 	}
-	return filepath.Join(dir, fmt.Sprintf("%s_%s%s.%s", req.Host, timestamp, dbPart, ext))
+	var modePart string
+	if mode := req.Mode.OrDefault(); mode != model.ModeFull {
+		modePart = fmt.Sprintf("_%s", mode)
+	}
+	host := req.Host
+	if req.OriginalHost != "" {
+		host = req.OriginalHost
+	}
+	return filepath.Join(dir, fmt.Sprintf("%s_%s%s%s.%s", host, timestamp, dbPart, modePart, ext))
+}
+
+// splitLines returns the non-empty, trimmed lines of command output
+func splitLines(output []byte) []string {
+	var lines []string
+	for _, line := range strings.Split(string(output), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// tail returns at most the last n bytes of s
+func tail(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[len(s)-n:]
+}
+
+func unsupportedModeError(req model.BackupRequest) error {
+	return fmt.Errorf("backup mode %s is not supported for %s", req.Mode, req.Type)
 }
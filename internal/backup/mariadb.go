@@ -0,0 +1,162 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"db-backup/internal/model"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+type MariaDBBackup struct{}
+
+// mariadbDumpDefaults give a consistent InnoDB snapshot without locking tables
+// and include the stored programs that mariadb-dump skips by default
+var mariadbDumpDefaults = []string{
+	"--single-transaction",
+	"--routines",
+	"--triggers",
+	"--events",
+}
+
+func (b *MariaDBBackup) Backup(ctx context.Context, req model.BackupRequest) (string, error) {
+	if req.Physical {
+		return b.backupPhysical(ctx, req)
+	}
+	if req.Mode.OrDefault() == model.ModeSubset {
+		return mysqlSubset(ctx, req, "mariadb", "mariadb-dump")
+	}
+
+	filename := generateFilename(req, "sql")
+
+	tlsFiles, err := writeTLSFiles(req.TLS)
+	if err != nil {
+		return "", err
+	}
+	defer tlsFiles.Cleanup()
+
+	binPath := resolveExecutable("mariadb-dump")
+
+	args := []string{
+		"-h", req.Host,
+		"-P", req.Port,
+		"-u", req.Username,
+	}
+	args = append(args, mysqlTLSArgs(ctx, binPath, req.TLS, tlsFiles)...)
+	args = append(args, mariadbDumpDefaults...)
+	args = append(args, optionArgs(req.Type, req.Options, nil)...)
+
+	switch req.Mode.OrDefault() {
+	case model.ModeSchema:
+		args = append(args, "--no-data")
+	case model.ModeData:
+		args = append(args, "--no-create-info")
+	}
+
+	if req.Scope.OrDefault() == model.ScopeServer {
+		args = append(args, "--all-databases")
+	} else {
+		args = append(args, req.Database)
+	}
+
+	outfile, err := os.Create(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to create backup file: %w", err)
+	}
+	defer outfile.Close()
+
+	cmd := exec.CommandContext(ctx, binPath, args...)
+
+	var stderr bytes.Buffer
+	cmd.Stdout = outfile
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("MYSQL_PWD=%s", req.Password))
+
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("mariadb-dump failed: %s, output: %s", err, stderr.String())
+	}
+
+	return filename, nil
+}
+
+// backupPhysical streams a mariabackup copy of the data directory as an
+// xbstream archive. mariabackup reads the data files directly, so it has to
+// run on the database host or with its data directory mounted at the same
+// path. Restore with `mbstream -x` followed by `mariabackup --prepare`.
+func (b *MariaDBBackup) backupPhysical(ctx context.Context, req model.BackupRequest) (string, error) {
+	if req.Mode.OrDefault() != model.ModeFull {
+		return "", unsupportedModeError(req)
+	}
+
+	// A physical copy always covers the whole server
+	named := req
+	named.Scope = model.ScopeServer
+	filename := generateFilename(named, "xb")
+
+	tlsFiles, err := writeTLSFiles(req.TLS)
+	if err != nil {
+		return "", err
+	}
+	defer tlsFiles.Cleanup()
+
+	outfile, err := os.Create(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to create backup file: %w", err)
+	}
+	defer outfile.Close()
+
+	binPath := resolveExecutable("mariabackup")
+	args := []string{
+		"--backup",
+		"--stream=xbstream",
+		fmt.Sprintf("--host=%s", req.Host),
+		fmt.Sprintf("--port=%s", req.Port),
+		fmt.Sprintf("--user=%s", req.Username),
+	}
+	args = append(args, mysqlTLSArgs(ctx, binPath, req.TLS, tlsFiles)...)
+	cmd := exec.CommandContext(ctx, binPath, args...)
+
+	// mariabackup logs progress on stderr; only the tail matters on failure
+	var stderr bytes.Buffer
+	cmd.Stdout = outfile
+	cmd.Stderr = &stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("MYSQL_PWD=%s", req.Password))
+
+	if err := cmd.Run(); err != nil {
+		os.Remove(filename)
+		return "", fmt.Errorf("mariabackup failed: %s, output: %s", err, tail(stderr.String(), 2048))
+	}
+
+	return filename, nil
+}
+
+// CheckVersion warns when the dump client is older than the server. Physical
+// backups need mariabackup to match the server closely, so it checks that one.
+func (b *MariaDBBackup) CheckVersion(ctx context.Context, req model.BackupRequest) (string, error) {
+	dumper := "mariadb-dump"
+	if req.Physical {
+		dumper = "mariabackup"
+	}
+	return checkMySQLVersion(ctx, "mariadb", dumper, req)
+}
+
+// TestConnection logs in and checks the grants needed by mariadb-dump with
+// the default flags, or by mariabackup for physical backups
+func (b *MariaDBBackup) TestConnection(ctx context.Context, req model.BackupRequest) (*model.ConnectionTestResult, error) {
+	required := []string{"SELECT", "SHOW VIEW", "TRIGGER", "EVENT"}
+	if req.Physical {
+		required = []string{"RELOAD", "PROCESS", "LOCK TABLES"}
+	}
+	return testMySQLConnection(ctx, "mariadb", req, required)
+}
+
+// ListDatabases returns the user databases on the server
+func (b *MariaDBBackup) ListDatabases(ctx context.Context, req model.BackupRequest) ([]string, error) {
+	return listMySQLDatabases(ctx, "mariadb", req)
+}
+
+// Discover returns the user databases on the server with their sizes
+func (b *MariaDBBackup) Discover(ctx context.Context, req model.BackupRequest) ([]model.DiscoveredDatabase, error) {
+	return discoverMySQLDatabases(ctx, "mariadb", req)
+}
@@ -0,0 +1,546 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"db-backup/internal/model"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// ErrCannotSanitize is returned for backups whose format cannot be masked
+var ErrCannotSanitize = errors.New("backup format cannot be sanitized")
+
+// ValidateMaskingProfiles checks the masking profiles of a database and
+// generates a salt for profiles without one
+func ValidateMaskingProfiles(profiles []model.MaskingProfile) error {
+	names := make(map[string]bool)
+	for i := range profiles {
+		profile := &profiles[i]
+		if profile.Name == "" {
+			return fmt.Errorf("masking profile %d has no name", i+1)
+		}
+		if names[profile.Name] {
+			return fmt.Errorf("masking profile %q is defined twice", profile.Name)
+		}
+		names[profile.Name] = true
+
+		if len(profile.Rules) == 0 {
+			return fmt.Errorf("masking profile %q has no rules", profile.Name)
+		}
+		for j, rule := range profile.Rules {
+			if rule.Column == "" {
+				return fmt.Errorf("rule %d of masking profile %q has no column", j+1, profile.Name)
+			}
+			if !rule.Strategy.IsValid() {
+				return fmt.Errorf("rule %d of masking profile %q has unknown strategy %q", j+1, profile.Name, rule.Strategy)
+			}
+		}
+
+		if profile.Salt == "" {
+			salt := make([]byte, 16)
+			if _, err := rand.Read(salt); err != nil {
+				return fmt.Errorf("failed to generate salt: %w", err)
+			}
+			profile.Salt = hex.EncodeToString(salt)
+		}
+	}
+	return nil
+}
+
+// SanitizeResult describes a sanitized copy of a backup
+type SanitizeResult struct {
+	FilePath string
+	// Values counts the masked values
+	Values int64
+	// Unmatched lists the rules that matched no column or field
+	Unmatched []string
+}
+
+// Sanitize writes a copy of a backup artifact with the rules of a masking
+// profile applied. SQL dumps keep their format; pg_dump custom archives and
+// SQLite databases are converted to plain SQL. MongoDB archives and native
+// dumps are rewritten document by document. name is the file name of the
+// source backup, which the copy is named after.
+func Sanitize(ctx context.Context, t model.BackupType, srcPath, name string, profile model.MaskingProfile) (*SanitizeResult, error) {
+	artifact, err := openArtifact(srcPath)
+	if err != nil {
+		return nil, err
+	}
+	defer artifact.Close()
+
+	compressed := artifact.gz != nil
+	var ext string
+	switch {
+	case artifact.format == formatSQL && t != model.Mongo:
+		ext = "sql"
+	case artifact.format == formatPgCustom, artifact.format == formatSQLite:
+		ext, compressed = "sql", false
+	case artifact.format == formatMongoArchive:
+		ext = "archive"
+	case artifact.format == formatTar && t == model.Mongo:
+		ext = "tar"
+	default:
+		return nil, fmt.Errorf("%w: %s artifact", ErrCannotSanitize, artifact.format)
+	}
+	if compressed {
+		ext += ".gz"
+	}
+
+	dir := filepath.Join("backups", string(t))
+	ensureDir(dir)
+	destPath, err := filepath.Abs(filepath.Join(dir, sanitizedName(name, profile.Name, ext)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve output path: %w", err)
+	}
+
+	out, err := os.Create(destPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	var w io.Writer = out
+	var gz *gzip.Writer
+	if compressed {
+		gz = gzip.NewWriter(out)
+		w = gz
+	}
+	bw := bufio.NewWriterSize(w, 64*1024)
+
+	m := newMasker(profile)
+	switch artifact.format {
+	case formatSQL:
+		err = sqlSanitize(ctx, artifact.r, t == model.MySQL || t == model.MariaDB, m, bw)
+	case formatPgCustom:
+		err = commandSanitize(ctx, m, bw, resolveExecutable("pg_restore"), "-f", "-", srcPath)
+	case formatSQLite:
+		err = commandSanitize(ctx, m, bw, resolveExecutable("sqlite3"), "-readonly", srcPath, ".dump")
+	case formatMongoArchive:
+		err = mongoArchiveSanitize(ctx, artifact.r, m, bw)
+	case formatTar:
+		err = mongoTarSanitize(ctx, artifact.r, m, bw)
+	}
+
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(destPath)
+		return nil, err
+	}
+
+	return &SanitizeResult{
+		FilePath:  destPath,
+		Values:    m.values,
+		Unmatched: m.unmatched(),
+	}, nil
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9_-]+`)
+
+// sanitizedName names the copy after its source, e.g.
+// db1_20240102_030405_shop_masked_staging.sql
+func sanitizedName(source, profile, ext string) string {
+	stem := strings.TrimSuffix(source, ".gz")
+	for _, known := range []string{".sql", ".tar", ".archive", ".db", ".dump"} {
+		stem = strings.TrimSuffix(stem, known)
+	}
+	return fmt.Sprintf("%s_masked_%s.%s", stem, unsafeFileChars.ReplaceAllString(profile, "_"), ext)
+}
+
+// commandSanitize masks the plain SQL a command prints, such as pg_restore
+// converting a custom-format archive
+func commandSanitize(ctx context.Context, m *masker, w *bufio.Writer, binPath string, args ...string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, binPath, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("failed to start %s: %w", filepath.Base(binPath), err)
+	}
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start %s: %w", filepath.Base(binPath), err)
+	}
+
+	if err := sqlSanitize(ctx, bufio.NewReaderSize(stdout, 64*1024), false, m, w); err != nil {
+		cancel()
+		cmd.Wait()
+		return err
+	}
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("%s failed: %s, output: %s", filepath.Base(binPath), err, stderr.String())
+	}
+	return nil
+}
+
+// masker applies the rules of a profile and records which rules matched
+type masker struct {
+	profile model.MaskingProfile
+	matched []bool
+	values  int64
+}
+
+func newMasker(profile model.MaskingProfile) *masker {
+	return &masker{profile: profile, matched: make([]bool, len(profile.Rules))}
+}
+
+// rules returns the rules that apply to a table, keyed by lower-case column
+// name or field path. The first rule for a column wins.
+func (m *masker) rules(database, schema, name string) map[string]int {
+	var rules map[string]int
+	for i, rule := range m.profile.Rules {
+		if !tableMatches(rule.Table, database, schema, name) {
+			continue
+		}
+		column := strings.ToLower(rule.Column)
+		if rules == nil {
+			rules = make(map[string]int)
+		}
+		if _, ok := rules[column]; !ok {
+			rules[column] = i
+		}
+	}
+	return rules
+}
+
+// columnRules maps the positions of the masked columns of a table to their
+// rule and marks those rules as matched
+func (m *masker) columnRules(rules map[string]int, columns []string) map[int]int {
+	positions := make(map[int]int)
+	for i, column := range columns {
+		if rule, ok := rules[strings.ToLower(column)]; ok {
+			positions[i] = rule
+			m.matched[rule] = true
+		}
+	}
+	return positions
+}
+
+func tableMatches(table, database, schema, name string) bool {
+	if table == "" || strings.EqualFold(table, name) {
+		return true
+	}
+	return (schema != "" && strings.EqualFold(table, schema+"."+name)) ||
+		(database != "" && strings.EqualFold(table, database+"."+name))
+}
+
+// mask returns the masked form of a value, or null
+func (m *masker) mask(rule int, value string) (string, bool) {
+	m.matched[rule] = true
+	m.values++
+
+	r := m.profile.Rules[rule]
+	switch r.Strategy {
+	case model.MaskNull:
+		return "", true
+	case model.MaskFixed:
+		return r.Value, false
+	case model.MaskEmail:
+		return "user_" + hex.EncodeToString(m.sum(value))[:16] + "@example.com", false
+	case model.MaskKeepFormat:
+		return m.keepFormat(value), false
+	}
+	return hex.EncodeToString(m.sum(value)), false
+}
+
+func (m *masker) sum(value string) []byte {
+	mac := hmac.New(sha256.New, []byte(m.profile.Salt))
+	mac.Write([]byte(value))
+	return mac.Sum(nil)
+}
+
+// keepFormat replaces every digit and letter with one derived from the
+// value's hash, so equal inputs give equal outputs
+func (m *masker) keepFormat(value string) string {
+	seed := m.sum(value)
+	var stream []byte
+	var block uint32
+	next := func() byte {
+		if len(stream) == 0 {
+			var counter [4]byte
+			binary.BigEndian.PutUint32(counter[:], block)
+			block++
+			sum := sha256.Sum256(append(seed, counter[:]...))
+			stream = sum[:]
+		}
+		b := stream[0]
+		stream = stream[1:]
+		return b
+	}
+
+	var b strings.Builder
+	for _, r := range value {
+		switch {
+		case unicode.IsDigit(r):
+			b.WriteByte('0' + next()%10)
+		case unicode.IsUpper(r):
+			b.WriteByte('A' + next()%26)
+		case unicode.IsLetter(r):
+			b.WriteByte('a' + next()%26)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// unmatched lists the rules that never matched, as table.column
+func (m *masker) unmatched() []string {
+	var rules []string
+	for i, matched := range m.matched {
+		if matched {
+			continue
+		}
+		rule := m.profile.Rules[i]
+		if rule.Table != "" {
+			rules = append(rules, rule.Table+"."+rule.Column)
+		} else {
+			rules = append(rules, rule.Column)
+		}
+	}
+	return rules
+}
+
+// sqlSanitizer rewrites the COPY blocks and INSERT statements of the tables
+// a profile masks and copies everything else unchanged
+type sqlSanitizer struct {
+	m         *masker
+	w         *bufio.Writer
+	backslash bool
+	database  string
+	// columns holds the CREATE TABLE columns of masked tables, for INSERT
+	// statements without a column list
+	columns map[string][]string
+}
+
+// sqlSanitize masks a plain SQL dump from pg_dump, pg_dumpall, mysqldump,
+// mariadb-dump or sqlite3
+func sqlSanitize(ctx context.Context, r *bufio.Reader, backslash bool, m *masker, w *bufio.Writer) error {
+	s := &sqlSanitizer{m: m, w: w, backslash: backslash, columns: make(map[string][]string)}
+
+	// statement collects a CREATE TABLE or INSERT of a masked table that
+	// spans several lines; copyRules is set inside a COPY block
+	var statement []byte
+	var copying bool
+	var copyRules map[int]int
+
+	for lineNo := 0; ; lineNo++ {
+		if lineNo%10000 == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		line, readErr := r.ReadBytes('\n')
+		if len(line) > 0 {
+			var err error
+			switch {
+			case copying:
+				if bytes.Equal(bytes.TrimRight(line, "\r\n"), []byte(`\.`)) {
+					copying, copyRules = false, nil
+					_, err = w.Write(line)
+				} else if len(copyRules) > 0 {
+					_, err = w.Write(s.copyRow(line, copyRules))
+				} else {
+					_, err = w.Write(line)
+				}
+			case statement != nil:
+				statement = append(statement, line...)
+				if sqlStatementEnd(statement, backslash) {
+					err = s.statement(statement)
+					statement = nil
+				}
+			default:
+				var masked bool
+				masked, copying, copyRules = s.line(line)
+				switch {
+				case !masked:
+					_, err = w.Write(line)
+				case sqlStatementEnd(line, backslash):
+					err = s.statement(line)
+				default:
+					statement = append([]byte{}, line...)
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if readErr == io.EOF {
+			if statement != nil {
+				return fmt.Errorf("dump ends inside a statement")
+			}
+			return nil
+		}
+		if readErr != nil {
+			return fmt.Errorf("failed to read dump: %w", readErr)
+		}
+	}
+}
+
+// line inspects a line outside statements of masked tables. It reports
+// whether a CREATE TABLE or INSERT of a masked table starts, or a COPY block
+// starts along with the rules for its columns.
+func (s *sqlSanitizer) line(line []byte) (bool, bool, map[int]int) {
+	if database, ok := sqlSwitchDatabase(line); ok {
+		s.database = database
+		return false, false, nil
+	}
+
+	switch {
+	case bytes.HasPrefix(line, []byte("CREATE")), bytes.HasPrefix(line, []byte("create")):
+		if m := sqlCreateTable.FindSubmatch(line); m != nil {
+			return s.tableRules(string(m[1])) != nil, false, nil
+		}
+	case bytes.HasPrefix(line, []byte("INSERT")), bytes.HasPrefix(line, []byte("insert")):
+		if m := sqlInsert.FindSubmatch(line); m != nil {
+			return s.tableRules(string(m[1])) != nil, false, nil
+		}
+	case bytes.HasPrefix(line, []byte("COPY ")):
+		m := sqlCopy.FindSubmatchIndex(line)
+		if m == nil {
+			return false, false, nil
+		}
+		rules := s.tableRules(string(line[m[2]:m[3]]))
+		if rules == nil {
+			return false, true, nil
+		}
+		var columns []string
+		if c := sqlCopyColumns.FindSubmatch(line[m[3]:]); c != nil {
+			columns = splitIdentifiers(string(c[1]))
+		} else {
+			columns = s.columns[s.key(string(line[m[2]:m[3]]))]
+		}
+		return false, true, s.m.columnRules(rules, columns)
+	}
+	return false, false, nil
+}
+
+func (s *sqlSanitizer) tableRules(qualified string) map[string]int {
+	schema, name := splitSQLName(qualified)
+	return s.m.rules(s.database, schema, name)
+}
+
+func (s *sqlSanitizer) key(qualified string) string {
+	schema, name := splitSQLName(qualified)
+	return s.database + "\x00" + schema + "\x00" + name
+}
+
+// statement writes a complete CREATE TABLE or INSERT statement of a masked
+// table, rewriting the values of INSERT statements
+func (s *sqlSanitizer) statement(statement []byte) error {
+	if m := sqlCreateTable.FindSubmatchIndex(statement); m != nil {
+		qualified := string(statement[m[2]:m[3]])
+		s.columns[s.key(qualified)] = createTableColumns(statement[m[3]:], s.backslash)
+		_, err := s.w.Write(statement)
+		return err
+	}
+
+	m := sqlInsert.FindSubmatchIndex(statement)
+	qualified := string(statement[m[2]:m[3]])
+	rest := statement[m[3]:]
+	columns := s.columns[s.key(qualified)]
+	if c := sqlCopyColumns.FindSubmatchIndex(rest); c != nil {
+		columns = splitIdentifiers(string(rest[c[2]:c[3]]))
+		rest = rest[c[1]:]
+	}
+	v := sqlValues.FindIndex(rest)
+	if v == nil {
+		return fmt.Errorf("unsupported INSERT statement for %s", qualified)
+	}
+	if len(columns) == 0 {
+		return fmt.Errorf("the columns of %s are not known; the dump has no CREATE TABLE or column list for it", qualified)
+	}
+
+	tuples, err := parseSQLValues(string(rest[v[1]:]), s.backslash)
+	if err != nil {
+		return fmt.Errorf("failed to parse INSERT statement for %s: %w", qualified, err)
+	}
+	positions := s.m.columnRules(s.tableRules(qualified), columns)
+
+	prefix := len(statement) - len(rest) + v[1]
+	s.w.Write(statement[:prefix])
+	for i, values := range tuples {
+		if i > 0 {
+			s.w.WriteByte(',')
+		}
+		s.w.WriteByte('(')
+		for j, value := range values {
+			if j > 0 {
+				s.w.WriteByte(',')
+			}
+			rule, ok := positions[j]
+			if !ok || value.null {
+				s.w.WriteString(value.raw)
+				continue
+			}
+			masked, null := s.m.mask(rule, value.text)
+			if null {
+				s.w.WriteString("NULL")
+			} else {
+				s.w.WriteString(encodeSQLString(masked, s.backslash))
+			}
+		}
+		s.w.WriteByte(')')
+	}
+	_, err = s.w.WriteString(";\n")
+	return err
+}
+
+// copyRow masks the fields of one line of a COPY block
+func (s *sqlSanitizer) copyRow(line []byte, rules map[int]int) []byte {
+	fields := strings.Split(strings.TrimRight(string(line), "\r\n"), "\t")
+	for i, field := range fields {
+		rule, ok := rules[i]
+		if !ok || field == `\N` {
+			continue
+		}
+		masked, null := s.m.mask(rule, decodeCopyField(field).text)
+		if null {
+			fields[i] = `\N`
+		} else {
+			fields[i] = encodeCopyField(masked)
+		}
+	}
+	return []byte(strings.Join(fields, "\t") + "\n")
+}
+
+var copyFieldEscaper = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\n", `\n`, "\r", `\r`)
+
+// encodeCopyField escapes a value for COPY text format
+func encodeCopyField(value string) string {
+	return copyFieldEscaper.Replace(value)
+}
+
+var mysqlStringEscaper = strings.NewReplacer(`\`, `\\`, "'", `\'`, "\x00", `\0`, "\n", `\n`, "\r", `\r`, "\x1a", `\Z`)
+
+// encodeSQLString quotes a string literal, with MySQL backslash escapes when
+// backslash is set
+func encodeSQLString(value string, backslash bool) string {
+	if backslash {
+		return "'" + mysqlStringEscaper.Replace(value) + "'"
+	}
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
@@ -0,0 +1,276 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"db-backup/internal/model"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// mongoRule is a masking rule split into its field path
+type mongoRule struct {
+	rule int
+	path []string
+}
+
+// collectionRules returns the rules that apply to a collection
+func (m *masker) collectionRules(database, collection string) []mongoRule {
+	var rules []mongoRule
+	for _, rule := range m.rules(database, "", collection) {
+		rules = append(rules, mongoRule{rule: rule, path: strings.Split(m.profile.Rules[rule].Column, ".")})
+	}
+	return rules
+}
+
+// document masks the fields of one BSON document
+func (m *masker) document(doc []byte, rules []mongoRule) ([]byte, error) {
+	var d bson.D
+	if err := bson.Unmarshal(doc, &d); err != nil {
+		return nil, fmt.Errorf("invalid document: %w", err)
+	}
+	for _, rule := range rules {
+		m.field(d, rule.path, rule.rule)
+	}
+	return bson.Marshal(d)
+}
+
+// field masks the value at path, descending into embedded documents and
+// into every element of arrays
+func (m *masker) field(value interface{}, path []string, rule int) interface{} {
+	if len(path) == 0 {
+		return m.bsonValue(rule, value)
+	}
+
+	switch v := value.(type) {
+	case bson.D:
+		for i := range v {
+			if strings.EqualFold(v[i].Key, path[0]) {
+				v[i].Value = m.field(v[i].Value, path[1:], rule)
+			}
+		}
+	case bson.A:
+		for i := range v {
+			v[i] = m.field(v[i], path, rule)
+		}
+	}
+	return value
+}
+
+// bsonValue masks a single value. Null stays null, arrays are masked element
+// by element and numbers keep their type under keep_format.
+func (m *masker) bsonValue(rule int, value interface{}) interface{} {
+	var text string
+	switch v := value.(type) {
+	case nil, primitive.Null, primitive.Undefined:
+		return value
+	case bson.A:
+		for i := range v {
+			v[i] = m.bsonValue(rule, v[i])
+		}
+		return v
+	case string:
+		text = v
+	case int32:
+		text = strconv.FormatInt(int64(v), 10)
+	case int64:
+		text = strconv.FormatInt(v, 10)
+	case float64:
+		text = strconv.FormatFloat(v, 'f', -1, 64)
+	case primitive.ObjectID:
+		text = v.Hex()
+	case primitive.DateTime:
+		text = v.Time().UTC().Format(time.RFC3339Nano)
+	default:
+		text = fmt.Sprint(v)
+	}
+
+	masked, null := m.mask(rule, text)
+	if null {
+		return nil
+	}
+	if m.profile.Rules[rule].Strategy == model.MaskKeepFormat {
+		switch value.(type) {
+		case int32:
+			if n, err := strconv.ParseInt(masked, 10, 32); err == nil {
+				return int32(n)
+			}
+		case int64:
+			if n, err := strconv.ParseInt(masked, 10, 64); err == nil {
+				return n
+			}
+		case float64:
+			if n, err := strconv.ParseFloat(masked, 64); err == nil {
+				return n
+			}
+		}
+	}
+	return masked
+}
+
+// mongoArchiveSanitize rewrites a mongodump --archive stream, masking the
+// documents of the collections the profile has rules for
+func mongoArchiveSanitize(ctx context.Context, r *bufio.Reader, m *masker, w *bufio.Writer) error {
+	magic := make([]byte, len(mongoArchiveMagic))
+	if _, err := io.ReadFull(r, magic); err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	w.Write(magic)
+
+	terminator := make([]byte, 4)
+	binary.LittleEndian.PutUint32(terminator, mongoArchiveTerminator)
+
+	prelude := true
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		header, err := readBSONDocument(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header == nil {
+			w.Write(terminator)
+			continue
+		}
+		w.Write(header)
+
+		var rules []mongoRule
+		if !prelude {
+			var namespace struct {
+				Database   string `bson:"db"`
+				Collection string `bson:"collection"`
+			}
+			if err := bson.Unmarshal(header, &namespace); err != nil {
+				return fmt.Errorf("invalid namespace header: %w", err)
+			}
+			if namespace.Database != "" {
+				rules = m.collectionRules(namespace.Database, namespace.Collection)
+			}
+		}
+
+		for {
+			doc, err := readBSONDocument(r)
+			if err != nil {
+				return fmt.Errorf("failed to read archive: %w", err)
+			}
+			if doc == nil {
+				w.Write(terminator)
+				break
+			}
+			if len(rules) > 0 {
+				if doc, err = m.document(doc, rules); err != nil {
+					return err
+				}
+			}
+			if _, err := w.Write(doc); err != nil {
+				return fmt.Errorf("failed to write archive: %w", err)
+			}
+		}
+
+		prelude = false
+	}
+}
+
+// mongoTarSanitize rewrites a native MongoDB dump. Masked .bson files are
+// staged in a temporary file because the tar header needs their new size.
+func mongoTarSanitize(ctx context.Context, r io.Reader, m *masker, w io.Writer) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		header, err := tr.Next()
+		if err == io.EOF {
+			return tw.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		var rules []mongoRule
+		if name, ok := strings.CutPrefix(strings.TrimPrefix(header.Name, "./"), "dump/"); ok {
+			parts := strings.SplitN(name, "/", 2)
+			if collection, ok := strings.CutSuffix(parts[len(parts)-1], ".bson"); ok && len(parts) == 2 {
+				rules = m.collectionRules(parts[0], collection)
+			}
+		}
+
+		if len(rules) == 0 {
+			if err := tw.WriteHeader(header); err != nil {
+				return fmt.Errorf("failed to write archive: %w", err)
+			}
+			if _, err := io.Copy(tw, tr); err != nil {
+				return fmt.Errorf("failed to write archive: %w", err)
+			}
+			continue
+		}
+
+		if err := maskTarEntry(tw, tr, header, m, rules); err != nil {
+			return fmt.Errorf("%s: %w", header.Name, err)
+		}
+	}
+}
+
+func maskTarEntry(tw *tar.Writer, r io.Reader, header *tar.Header, m *masker, rules []mongoRule) error {
+	tmp, err := os.CreateTemp("", "masked-*.bson")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	br := bufio.NewReaderSize(r, 64*1024)
+	bw := bufio.NewWriterSize(tmp, 64*1024)
+	for {
+		doc, err := readBSONDocument(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if doc == nil {
+			continue
+		}
+		if doc, err = m.document(doc, rules); err != nil {
+			return err
+		}
+		bw.Write(doc)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	header.Size = size
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	if _, err := io.Copy(tw, tmp); err != nil {
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
@@ -4,18 +4,54 @@ import (
 	"context"
 	"db-backup/internal/model"
 	"fmt"
+	"net"
+	"net/url"
 	"os/exec"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
 )
 
 type MongoBackup struct{}
 
 func (b *MongoBackup) Backup(ctx context.Context, req model.BackupRequest) (string, error) {
+	if useNativeMongo(req) {
+		return b.backupNative(ctx, req)
+	}
+
+	// mongodump has no schema-only or data-only equivalent
+	if req.Mode.OrDefault() != model.ModeFull {
+		return "", unsupportedModeError(req)
+	}
+
 	// mongodump creates a directory usually, or an archive. Archive is better for single file.
 	filename := generateFilename(req, "gz")
 
+	// Leaving out --db makes mongodump back up every database on the server
+	serverScope := req.Scope.OrDefault() == model.ScopeServer
+
 	var args []string
 	if req.ConnectionURI != "" {
-		args = append(args, fmt.Sprintf("--uri=%s", req.ConnectionURI))
+		// A database in the URI limits mongodump to it and conflicts with
+		// --db, so it is removed for server backups and when another
+		// database, such as each database of a split server backup, is
+		// requested. Otherwise it selects the database to back up.
+		uri := req.ConnectionURI
+		if serverScope || req.Database != "" {
+			var err error
+			if uri, err = mongoURIWithoutDatabase(uri); err != nil {
+				return "", err
+			}
+		}
+		args = append(args, fmt.Sprintf("--uri=%s", uri))
+		if !serverScope && req.Database != "" {
+			args = append(args, fmt.Sprintf("--db=%s", req.Database))
+		}
 	} else {
 		args = append(args,
 			fmt.Sprintf("--host=%s", req.Host),
@@ -27,11 +63,19 @@ func (b *MongoBackup) Backup(ctx context.Context, req model.BackupRequest) (stri
 		if req.Password != "" {
 			args = append(args, fmt.Sprintf("--password=%s", req.Password))
 		}
-		if req.Database != "" {
+		if !serverScope && req.Database != "" {
 			args = append(args, fmt.Sprintf("--db=%s", req.Database))
 		}
 	}
 
+	tlsFiles, err := writeTLSFiles(req.TLS)
+	if err != nil {
+		return "", err
+	}
+	defer tlsFiles.Cleanup()
+	args = append(args, mongoTLSArgs(req.TLS, tlsFiles)...)
+
+	args = append(args, optionArgs(req.Type, req.Options, nil)...)
 	args = append(args, fmt.Sprintf("--archive=%s", filename), "--gzip")
 
 	binPath := resolveExecutable("mongodump")
@@ -43,3 +87,241 @@ func (b *MongoBackup) Backup(ctx context.Context, req model.BackupRequest) (stri
 
 	return filename, nil
 }
+
+// mongoTLSArgs translates the TLS config for mongodump, which takes the client
+// certificate and key as one PEM file
+func mongoTLSArgs(cfg *model.TLSConfig, tlsFiles *tlsFiles) []string {
+	if cfg == nil || !cfg.Enabled {
+		return nil
+	}
+
+	args := []string{"--ssl"}
+	if tlsFiles.CACert != "" {
+		args = append(args, fmt.Sprintf("--sslCAFile=%s", tlsFiles.CACert))
+	}
+	if tlsFiles.ClientPEM != "" {
+		args = append(args, fmt.Sprintf("--sslPEMKeyFile=%s", tlsFiles.ClientPEM))
+	}
+
+	switch cfg.ModeOrDefault() {
+	case model.TLSRequire:
+		args = append(args, "--sslAllowInvalidCertificates", "--sslAllowInvalidHostnames")
+	case model.TLSVerifyCA:
+		args = append(args, "--sslAllowInvalidHostnames")
+	}
+
+	return args
+}
+
+// mongoUserDatabases filters out the internal databases of a MongoDB server
+var mongoUserDatabases = bson.M{"name": bson.M{"$nin": []string{"admin", "config", "local"}}}
+
+// ListDatabases returns the user databases on the server
+func (b *MongoBackup) ListDatabases(ctx context.Context, req model.BackupRequest) ([]string, error) {
+	opts, err := mongoClientOptions(req)
+	if err != nil {
+		return nil, err
+	}
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+	defer client.Disconnect(ctx)
+
+	names, err := client.ListDatabaseNames(ctx, mongoUserDatabases)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list databases: %w", err)
+	}
+
+	return names, nil
+}
+
+// Discover returns the user databases on the server with their on-disk sizes
+func (b *MongoBackup) Discover(ctx context.Context, req model.BackupRequest) ([]model.DiscoveredDatabase, error) {
+	opts, err := mongoClientOptions(req)
+	if err != nil {
+		return nil, err
+	}
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+	defer client.Disconnect(ctx)
+
+	result, err := client.ListDatabases(ctx, mongoUserDatabases)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list databases: %w", err)
+	}
+
+	databases := []model.DiscoveredDatabase{}
+	for _, spec := range result.Databases {
+		databases = append(databases, model.DiscoveredDatabase{
+			Name:      spec.Name,
+			SizeBytes: spec.SizeOnDisk,
+		})
+	}
+
+	return databases, nil
+}
+
+// mongoServerReadRoles can read every database on the server
+var mongoServerReadRoles = map[string]bool{
+	"root":                 true,
+	"backup":               true,
+	"readAnyDatabase":      true,
+	"__system":             true,
+	"readWriteAnyDatabase": true,
+}
+
+// mongoDatabaseReadRoles can read a single database
+var mongoDatabaseReadRoles = map[string]bool{
+	"read":      true,
+	"readWrite": true,
+	"dbOwner":   true,
+}
+
+// TestConnection pings the server and checks the roles of the authenticated
+// user cover the databases the backup reads
+func (b *MongoBackup) TestConnection(ctx context.Context, req model.BackupRequest) (*model.ConnectionTestResult, error) {
+	opts, err := mongoClientOptions(req)
+	if err != nil {
+		return nil, err
+	}
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+	defer client.Disconnect(ctx)
+
+	// The first ping also performs the handshake and authentication
+	start := time.Now()
+	if err := client.Ping(ctx, readpref.Primary()); err != nil {
+		return nil, fmt.Errorf("failed to ping MongoDB: %w", err)
+	}
+	result := &model.ConnectionTestResult{LatencyMs: time.Since(start).Milliseconds()}
+
+	admin := client.Database("admin")
+
+	var buildInfo struct {
+		Version string `bson:"version"`
+	}
+	if err := admin.RunCommand(ctx, bson.D{{Key: "buildInfo", Value: 1}}).Decode(&buildInfo); err != nil {
+		return nil, fmt.Errorf("failed to read server version: %w", err)
+	}
+	result.ServerVersion = buildInfo.Version
+
+	var status struct {
+		AuthInfo struct {
+			AuthenticatedUsers     []bson.M `bson:"authenticatedUsers"`
+			AuthenticatedUserRoles []struct {
+				Role string `bson:"role"`
+				DB   string `bson:"db"`
+			} `bson:"authenticatedUserRoles"`
+		} `bson:"authInfo"`
+	}
+	if err := admin.RunCommand(ctx, bson.D{{Key: "connectionStatus", Value: 1}}).Decode(&status); err != nil {
+		return nil, fmt.Errorf("failed to read connection status: %w", err)
+	}
+
+	if len(status.AuthInfo.AuthenticatedUsers) == 0 {
+		result.Warning = "not authenticated; dumps only work if access control is disabled"
+		return result, nil
+	}
+
+	database := ""
+	if req.Scope.OrDefault() != model.ScopeServer {
+		database = req.Database
+		if database == "" && req.ConnectionURI != "" {
+			if cs, err := connstring.Parse(req.ConnectionURI); err == nil {
+				database = cs.Database
+			}
+		}
+	}
+
+	for _, role := range status.AuthInfo.AuthenticatedUserRoles {
+		if role.DB == "admin" && mongoServerReadRoles[role.Role] {
+			return result, nil
+		}
+		if database != "" && role.DB == database && mongoDatabaseReadRoles[role.Role] {
+			return result, nil
+		}
+	}
+
+	if database == "" {
+		result.MissingPrivileges = []string{"backup or readAnyDatabase role"}
+	} else {
+		result.MissingPrivileges = []string{fmt.Sprintf("read role on %s", database)}
+	}
+
+	return result, nil
+}
+
+// mongoURIWithoutDatabase removes the database from a connection URI. The
+// database also names the authentication database unless authSource is set,
+// so it is kept as authSource.
+func mongoURIWithoutDatabase(uri string) (string, error) {
+	cs, err := connstring.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("invalid connection URI: %w", err)
+	}
+	if cs.Database == "" {
+		return uri, nil
+	}
+
+	// The path starts at the first slash after the hosts
+	hosts := strings.Index(uri, "://") + len("://")
+	path := hosts + strings.IndexByte(uri[hosts:], '/')
+	var query string
+	if i := strings.IndexByte(uri[path:], '?'); i >= 0 {
+		query = uri[path+i+1:]
+	}
+	if !cs.AuthSourceSet {
+		param := "authSource=" + url.QueryEscape(cs.Database)
+		if query != "" {
+			query += "&"
+		}
+		query += param
+	}
+
+	stripped := uri[:path+1]
+	if query != "" {
+		stripped += "?" + query
+	}
+	return stripped, nil
+}
+
+// mongoClientOptions builds driver options from the connection URI or the
+// individual host fields
+func mongoClientOptions(req model.BackupRequest) (*options.ClientOptions, error) {
+	// Without an explicit server name the driver checks each host it dials
+	tlsConfig, err := tlsClientConfig(req.TLS, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if req.ConnectionURI != "" {
+		opts := options.Client().ApplyURI(req.ConnectionURI)
+		if tlsConfig != nil {
+			opts.SetTLSConfig(tlsConfig)
+		}
+		return opts, nil
+	}
+
+	opts := options.Client().SetHosts([]string{net.JoinHostPort(req.Host, req.Port)})
+	if tlsConfig != nil {
+		opts.SetTLSConfig(tlsConfig)
+	}
+	// Through a tunnel only this node is reachable, so do not follow the
+	// replica set members it advertises
+	if req.SSH != nil {
+		opts.SetDirect(true)
+	}
+	if req.Username != "" {
+		opts.SetAuth(options.Credential{
+			Username: req.Username,
+			Password: req.Password,
+		})
+	}
+
+	return opts, nil
+}
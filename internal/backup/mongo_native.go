@@ -0,0 +1,276 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"db-backup/internal/model"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/x/mongo/driver/connstring"
+)
+
+// useNativeMongo reports whether the in-process dump engine is used instead of
+// mongodump: when requested, or when mongodump is not installed
+func useNativeMongo(req model.BackupRequest) bool {
+	return (req.Mongo != nil && req.Mongo.Native) || !toolAvailable("mongodump")
+}
+
+// backupNative dumps through the driver into a gzipped tar holding the same
+// layout mongodump writes with --out: dump/<db>/<collection>.bson plus
+// <collection>.metadata.json. Restore with `tar xzf` and `mongorestore --dir dump`.
+// Schema mode writes only the metadata, data mode only the documents.
+func (b *MongoBackup) backupNative(ctx context.Context, req model.BackupRequest) (string, error) {
+	opts, err := mongoClientOptions(req)
+	if err != nil {
+		return "", err
+	}
+	client, err := mongo.Connect(ctx, opts)
+	if err != nil {
+		return "", fmt.Errorf("failed to connect to MongoDB: %w", err)
+	}
+	defer client.Disconnect(ctx)
+
+	dbNames, err := nativeMongoDatabases(ctx, client, req)
+	if err != nil {
+		return "", err
+	}
+
+	filename := generateFilename(req, "tar.gz")
+	workDir, err := os.MkdirTemp(filepath.Dir(filename), "mongo-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create work directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	outfile, err := os.Create(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to create backup file: %w", err)
+	}
+	defer outfile.Close()
+
+	gz := gzip.NewWriter(outfile)
+	tw := tar.NewWriter(gz)
+
+	filter := newMongoCollectionFilter(req.Options)
+	mode := req.Mode.OrDefault()
+
+	for _, dbName := range dbNames {
+		db := client.Database(dbName)
+
+		specs, err := db.ListCollectionSpecifications(ctx, bson.D{})
+		if err != nil {
+			os.Remove(filename)
+			return "", fmt.Errorf("failed to list collections of %s: %w", dbName, err)
+		}
+
+		for _, spec := range specs {
+			if !filter.include(spec.Name) {
+				continue
+			}
+			prefix := path.Join("dump", dbName, spec.Name)
+
+			if mode != model.ModeData {
+				metadata, err := mongoCollectionMetadata(ctx, db, spec)
+				if err != nil {
+					os.Remove(filename)
+					return "", err
+				}
+				if err := addBytesToTar(tw, prefix+".metadata.json", metadata); err != nil {
+					os.Remove(filename)
+					return "", err
+				}
+			}
+
+			// Views have no documents of their own
+			if mode == model.ModeSchema || spec.Type != "collection" {
+				continue
+			}
+
+			// tar needs the size up front, so each collection is staged
+			// on disk and removed once archived
+			staged := filepath.Join(workDir, "collection.bson")
+			if err := dumpMongoCollection(ctx, db.Collection(spec.Name), staged); err != nil {
+				os.Remove(filename)
+				return "", fmt.Errorf("failed to dump %s.%s: %w", dbName, spec.Name, err)
+			}
+			if err := addFileToTar(tw, staged, prefix+".bson"); err != nil {
+				os.Remove(filename)
+				return "", err
+			}
+			os.Remove(staged)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		os.Remove(filename)
+		return "", fmt.Errorf("failed to finish archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		os.Remove(filename)
+		return "", fmt.Errorf("failed to finish archive: %w", err)
+	}
+
+	return filename, nil
+}
+
+// nativeMongoDatabases returns the databases to dump: the requested one, the
+// one named in the connection URI, or every user database for server scope
+func nativeMongoDatabases(ctx context.Context, client *mongo.Client, req model.BackupRequest) ([]string, error) {
+	if req.Scope.OrDefault() != model.ScopeServer {
+		if req.Database != "" {
+			return []string{req.Database}, nil
+		}
+		if req.ConnectionURI != "" {
+			if cs, err := connstring.Parse(req.ConnectionURI); err == nil && cs.Database != "" {
+				return []string{cs.Database}, nil
+			}
+		}
+	}
+
+	names, err := client.ListDatabaseNames(ctx, mongoUserDatabases)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list databases: %w", err)
+	}
+
+	return names, nil
+}
+
+// mongoCollectionMetadata builds the metadata.json mongorestore reads to
+// recreate a collection with its options and indexes
+func mongoCollectionMetadata(ctx context.Context, db *mongo.Database, spec *mongo.CollectionSpecification) ([]byte, error) {
+	indexes := bson.A{}
+	if spec.Type == "collection" {
+		cursor, err := db.Collection(spec.Name).Indexes().List(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list indexes of %s.%s: %w", db.Name(), spec.Name, err)
+		}
+		var raw []bson.Raw
+		if err := cursor.All(ctx, &raw); err != nil {
+			return nil, fmt.Errorf("failed to decode indexes of %s.%s: %w", db.Name(), spec.Name, err)
+		}
+		for _, index := range raw {
+			indexes = append(indexes, index)
+		}
+	}
+
+	options := spec.Options
+	if options == nil {
+		options = bson.Raw(bsonEmptyDocument)
+	}
+
+	metadata := bson.D{
+		{Key: "indexes", Value: indexes},
+		{Key: "collectionName", Value: spec.Name},
+		{Key: "type", Value: spec.Type},
+		{Key: "options", Value: options},
+	}
+	if spec.UUID != nil {
+		metadata = append(metadata, bson.E{Key: "uuid", Value: hex.EncodeToString(spec.UUID.Data)})
+	}
+
+	data, err := bson.MarshalExtJSON(metadata, true, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode metadata of %s.%s: %w", db.Name(), spec.Name, err)
+	}
+
+	return data, nil
+}
+
+// bsonEmptyDocument is the encoding of {}
+var bsonEmptyDocument = []byte{5, 0, 0, 0, 0}
+
+// dumpMongoCollection writes every document of a collection as raw BSON, the
+// format of mongodump .bson files
+func dumpMongoCollection(ctx context.Context, coll *mongo.Collection, filename string) error {
+	outfile, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create staging file: %w", err)
+	}
+	defer outfile.Close()
+
+	cursor, err := coll.Find(ctx, bson.D{})
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	w := bufio.NewWriter(outfile)
+	ns := fmt.Sprintf("%s.%s", coll.Database().Name(), coll.Name())
+	start := time.Now()
+	last := start
+
+	var docs, written int64
+	for cursor.Next(ctx) {
+		n, err := w.Write(cursor.Current)
+		if err != nil {
+			return fmt.Errorf("failed to write document: %w", err)
+		}
+		docs++
+		written += int64(n)
+
+		if time.Since(last) >= 5*time.Second {
+			last = time.Now()
+			log.Printf("Mongo dump %s: %d documents, %d bytes", ns, docs, written)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("failed to write staging file: %w", err)
+	}
+
+	log.Printf("Mongo dump %s done: %d documents, %d bytes in %s", ns, docs, written, time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// mongoCollectionFilter applies the collection selection options that
+// mongodump supports to the native engine
+type mongoCollectionFilter struct {
+	only     string
+	excluded map[string]bool
+	prefixes []string
+}
+
+func newMongoCollectionFilter(options map[string]string) mongoCollectionFilter {
+	filter := mongoCollectionFilter{
+		only:     options["collection"],
+		excluded: make(map[string]bool),
+	}
+	for _, name := range splitOptionList(options["excludeCollection"]) {
+		filter.excluded[name] = true
+	}
+	filter.prefixes = splitOptionList(options["excludeCollectionsWithPrefix"])
+	return filter
+}
+
+func (f mongoCollectionFilter) include(name string) bool {
+	// mongodump skips internal collections except stored JavaScript
+	if strings.HasPrefix(name, "system.") && name != "system.js" {
+		return false
+	}
+	if f.only != "" && name != f.only {
+		return false
+	}
+	if f.excluded[name] {
+		return false
+	}
+	for _, prefix := range f.prefixes {
+		if strings.HasPrefix(name, prefix) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,32 @@
+package backup
+
+import "testing"
+
+func TestMongoURIWithoutDatabase(t *testing.T) {
+	tests := []struct {
+		name string
+		uri  string
+		want string
+	}{
+		{"no database", "mongodb://u:p@host:27017/", "mongodb://u:p@host:27017/"},
+		{"no path", "mongodb://host:27017", "mongodb://host:27017"},
+		{"database", "mongodb://u:p@host/shop", "mongodb://u:p@host/?authSource=shop"},
+		{"database and options", "mongodb://u:p@a:1,b:2/shop?replicaSet=rs0", "mongodb://u:p@a:1,b:2/?replicaSet=rs0&authSource=shop"},
+		{"auth source set", "mongodb://u:p@host/shop?authSource=admin", "mongodb://u:p@host/?authSource=admin"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := mongoURIWithoutDatabase(tt.uri)
+			if err != nil {
+				t.Fatalf("mongoURIWithoutDatabase: %v", err)
+			}
+			if got != tt.want {
+				t.Fatalf("mongoURIWithoutDatabase(%q) = %q, want %q", tt.uri, got, tt.want)
+			}
+		})
+	}
+
+	if _, err := mongoURIWithoutDatabase("postgres://host/db"); err == nil {
+		t.Fatal("accepted a non-MongoDB URI")
+	}
+}
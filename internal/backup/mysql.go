@@ -1,16 +1,36 @@
 package backup
 
 import (
+	"bytes"
 	"context"
 	"db-backup/internal/model"
 	"fmt"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type MySQLBackup struct{}
 
+// mysqlDumpDefaults take a consistent InnoDB snapshot without locking tables,
+// stream rows instead of buffering whole tables, keep binary columns intact
+// and include stored programs. Options can turn any of them off.
+var mysqlDumpDefaults = []string{
+	"--single-transaction",
+	"--quick",
+	"--routines",
+	"--triggers",
+	"--events",
+	"--hex-blob",
+}
+
 func (b *MySQLBackup) Backup(ctx context.Context, req model.BackupRequest) (string, error) {
+	if req.Mode.OrDefault() == model.ModeSubset {
+		return mysqlSubset(ctx, req, "mysql", "mysqldump")
+	}
+
 	filename := generateFilename(req, "sql")
 
 	// mysqldump -u [username] -p[password] [database_name] > [filename]
@@ -20,13 +40,37 @@ func (b *MySQLBackup) Backup(ctx context.Context, req model.BackupRequest) (stri
 	// We will try using MYSQL_PWD env var to avoid command line arg if possible,
 	// or fallback. Here we use the env var approach which is generally supported.
 
+	tlsFiles, err := writeTLSFiles(req.TLS)
+	if err != nil {
+		return "", err
+	}
+	defer tlsFiles.Cleanup()
+
 	binPath := resolveExecutable("mysqldump")
-	cmd := exec.CommandContext(ctx, binPath,
+
+	args := []string{
 		"-h", req.Host,
 		"-P", req.Port,
 		"-u", req.Username,
-		req.Database,
-	)
+	}
+	args = append(args, mysqlTLSArgs(ctx, binPath, req.TLS, tlsFiles)...)
+	args = append(args, mysqlDumpDefaults...)
+	args = append(args, optionArgs(req.Type, req.Options, nil)...)
+
+	switch req.Mode.OrDefault() {
+	case model.ModeSchema:
+		args = append(args, "--no-data")
+	case model.ModeData:
+		args = append(args, "--no-create-info")
+	}
+
+	if req.Scope.OrDefault() == model.ScopeServer {
+		args = append(args, "--all-databases")
+	} else {
+		args = append(args, req.Database)
+	}
+
+	cmd := exec.CommandContext(ctx, binPath, args...)
 
 	// Redirect stdout to file
 	// cmd.Stdout is not set, we will write to file manually or let shell handle it?
@@ -48,3 +92,253 @@ func (b *MySQLBackup) Backup(ctx context.Context, req model.BackupRequest) (stri
 
 	return filename, nil
 }
+
+// mysqlSystemSchemas are excluded from per-database server backups
+var mysqlSystemSchemas = map[string]bool{
+	"information_schema": true,
+	"performance_schema": true,
+	"sys":                true,
+}
+
+// ListDatabases returns the user databases on the server
+func (b *MySQLBackup) ListDatabases(ctx context.Context, req model.BackupRequest) ([]string, error) {
+	return listMySQLDatabases(ctx, "mysql", req)
+}
+
+// Discover returns the user databases on the server with their sizes. MySQL
+// has no schemas below the database level.
+func (b *MySQLBackup) Discover(ctx context.Context, req model.BackupRequest) ([]model.DiscoveredDatabase, error) {
+	return discoverMySQLDatabases(ctx, "mysql", req)
+}
+
+// CheckVersion warns when mysqldump is older than the server or comes from
+// the other MySQL flavour. Dumps usually still work, so neither is fatal.
+func (b *MySQLBackup) CheckVersion(ctx context.Context, req model.BackupRequest) (string, error) {
+	return checkMySQLVersion(ctx, "mysql", "mysqldump", req)
+}
+
+func checkMySQLVersion(ctx context.Context, client, dumper string, req model.BackupRequest) (string, error) {
+	rows, err := mysqlQuery(ctx, client, req, "SELECT VERSION()")
+	if err != nil || len(rows) == 0 {
+		return fmt.Sprintf("could not determine server version: %v", err), nil
+	}
+	serverVersion := rows[0]
+
+	info := detectTool(ctx, dumper, resolveExecutable(dumper))
+	if !info.Found || info.Major == 0 {
+		return fmt.Sprintf("could not determine %s version: %s", dumper, info.Error), nil
+	}
+
+	serverMariaDB := strings.Contains(strings.ToLower(serverVersion), "mariadb")
+	clientMariaDB := strings.Contains(strings.ToLower(info.Version), "mariadb")
+	if serverMariaDB != clientMariaDB {
+		return fmt.Sprintf("%s (%s) and server %s are different MySQL flavours", dumper, info.Version, serverVersion), nil
+	}
+
+	serverMajor, serverMinor := parseVersion(serverVersion)
+	clientMajor, clientMinor := parseVersion(info.Version)
+	if clientMajor < serverMajor || (clientMajor == serverMajor && clientMinor < serverMinor) {
+		return fmt.Sprintf("%s %d.%d is older than server %s", dumper, clientMajor, clientMinor, serverVersion), nil
+	}
+
+	return "", nil
+}
+
+// TestConnection logs in and checks the grants mysqldump needs with the
+// default flags
+func (b *MySQLBackup) TestConnection(ctx context.Context, req model.BackupRequest) (*model.ConnectionTestResult, error) {
+	required := []string{"SELECT", "SHOW VIEW", "TRIGGER", "EVENT"}
+	// Since 8.0.21 dumping tablespaces needs PROCESS
+	if !optionEnabled(req.Options, "no-tablespaces") {
+		required = append(required, "PROCESS")
+	}
+	return testMySQLConnection(ctx, "mysql", req, required)
+}
+
+// testMySQLConnection measures a round trip, then compares SHOW GRANTS with
+// the required privileges. Only global grants count for server backups.
+func testMySQLConnection(ctx context.Context, client string, req model.BackupRequest, required []string) (*model.ConnectionTestResult, error) {
+	start := time.Now()
+	rows, err := mysqlQuery(ctx, client, req, "SELECT VERSION()")
+	if err != nil {
+		return nil, err
+	}
+	result := &model.ConnectionTestResult{LatencyMs: time.Since(start).Milliseconds()}
+	if len(rows) > 0 {
+		result.ServerVersion = rows[0]
+	}
+
+	grants, err := mysqlQuery(ctx, client, req, "SHOW GRANTS")
+	if err != nil {
+		return nil, err
+	}
+
+	database := req.Database
+	if req.Scope.OrDefault() == model.ScopeServer {
+		database = ""
+	}
+	granted, viaRoles := mysqlGrantedPrivileges(grants, database)
+
+	for _, privilege := range required {
+		if !granted["ALL PRIVILEGES"] && !granted[privilege] {
+			result.MissingPrivileges = append(result.MissingPrivileges, privilege)
+		}
+	}
+	if len(result.MissingPrivileges) > 0 && viaRoles {
+		result.Warning = "privileges granted through roles are not checked"
+	}
+
+	return result, nil
+}
+
+// mysqlGrantedPrivileges collects the privileges SHOW GRANTS lists globally or
+// on the given database. Lines look like:
+// GRANT SELECT, SHOW VIEW ON `shop`.* TO `backup`@`%`
+func mysqlGrantedPrivileges(grants []string, database string) (map[string]bool, bool) {
+	granted := map[string]bool{}
+	viaRoles := false
+
+	for _, grant := range grants {
+		privileges, rest, ok := strings.Cut(strings.TrimPrefix(grant, "GRANT "), " ON ")
+		if !ok {
+			// Role grants have no ON clause: GRANT `reader`@`%` TO ...
+			viaRoles = true
+			continue
+		}
+
+		object, _, _ := strings.Cut(rest, " TO ")
+		// Wildcard characters in database names are escaped
+		object = strings.ReplaceAll(object, "\\", "")
+		if object != "*.*" && (database == "" || object != fmt.Sprintf("`%s`.*", database)) {
+			continue
+		}
+
+		for _, privilege := range strings.Split(privileges, ", ") {
+			privilege = strings.TrimSpace(privilege)
+			if privilege == "ALL" {
+				privilege = "ALL PRIVILEGES"
+			}
+			granted[privilege] = true
+		}
+	}
+
+	return granted, viaRoles
+}
+
+// listMySQLDatabases lists the user databases with the given client binary,
+// which is shared by MySQL and MariaDB
+func listMySQLDatabases(ctx context.Context, client string, req model.BackupRequest) ([]string, error) {
+	rows, err := mysqlQuery(ctx, client, req, "SHOW DATABASES")
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, name := range rows {
+		if !mysqlSystemSchemas[name] {
+			names = append(names, name)
+		}
+	}
+
+	return names, nil
+}
+
+// discoverMySQLDatabases reports the user databases and their sizes with the
+// given client binary
+func discoverMySQLDatabases(ctx context.Context, client string, req model.BackupRequest) ([]model.DiscoveredDatabase, error) {
+	rows, err := mysqlQuery(ctx, client, req, `SELECT s.schema_name, COALESCE(SUM(t.data_length + t.index_length), 0)
+		FROM information_schema.schemata s
+		LEFT JOIN information_schema.tables t ON t.table_schema = s.schema_name
+		GROUP BY s.schema_name
+		ORDER BY s.schema_name`)
+	if err != nil {
+		return nil, err
+	}
+
+	databases := []model.DiscoveredDatabase{}
+	for _, row := range rows {
+		name, size, _ := strings.Cut(row, "\t")
+		if mysqlSystemSchemas[name] {
+			continue
+		}
+		db := model.DiscoveredDatabase{Name: name}
+		db.SizeBytes, _ = strconv.ParseInt(size, 10, 64)
+		databases = append(databases, db)
+	}
+
+	return databases, nil
+}
+
+// mysqlQuery runs a query and returns one tab-separated line per row
+func mysqlQuery(ctx context.Context, client string, req model.BackupRequest, query string) ([]string, error) {
+	tlsFiles, err := writeTLSFiles(req.TLS)
+	if err != nil {
+		return nil, err
+	}
+	defer tlsFiles.Cleanup()
+
+	binPath := resolveExecutable(client)
+
+	args := []string{
+		"-h", req.Host,
+		"-P", req.Port,
+		"-u", req.Username,
+	}
+	args = append(args, mysqlTLSArgs(ctx, binPath, req.TLS, tlsFiles)...)
+	args = append(args, "-N", "-B", "-e", query)
+
+	cmd := exec.CommandContext(ctx, binPath, args...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("MYSQL_PWD=%s", req.Password))
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %s, output: %s", client, err, stderr.String())
+	}
+
+	return splitLines(output), nil
+}
+
+// mysqlTLSArgs translates the TLS config for MySQL and MariaDB clients. The
+// flavours disagree: MySQL clients take --ssl-mode, MariaDB clients --ssl with
+// --ssl-verify-server-cert and cannot skip only the host name check.
+func mysqlTLSArgs(ctx context.Context, binPath string, cfg *model.TLSConfig, tlsFiles *tlsFiles) []string {
+	if cfg == nil || !cfg.Enabled {
+		return nil
+	}
+
+	var args []string
+	version, _ := toolVersion(ctx, binPath)
+	if strings.Contains(version, "MariaDB") {
+		args = append(args, "--ssl")
+		if cfg.ModeOrDefault() == model.TLSRequire {
+			// MariaDB 11.4+ clients verify the certificate by default
+			args = append(args, "--skip-ssl-verify-server-cert")
+		} else {
+			args = append(args, "--ssl-verify-server-cert")
+		}
+	} else {
+		switch cfg.ModeOrDefault() {
+		case model.TLSRequire:
+			args = append(args, "--ssl-mode=REQUIRED")
+		case model.TLSVerifyCA:
+			args = append(args, "--ssl-mode=VERIFY_CA")
+		default:
+			args = append(args, "--ssl-mode=VERIFY_IDENTITY")
+		}
+	}
+
+	if tlsFiles.CACert != "" {
+		args = append(args, fmt.Sprintf("--ssl-ca=%s", tlsFiles.CACert))
+	}
+	if tlsFiles.ClientCert != "" {
+		args = append(args, fmt.Sprintf("--ssl-cert=%s", tlsFiles.ClientCert))
+	}
+	if tlsFiles.ClientKey != "" {
+		args = append(args, fmt.Sprintf("--ssl-key=%s", tlsFiles.ClientKey))
+	}
+
+	return args
+}
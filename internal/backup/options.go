@@ -0,0 +1,205 @@
+package backup
+
+import (
+	"db-backup/internal/model"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type optionKind int
+
+const (
+	// boolOption is a switch: "true" (or empty) enables it, "false" disables it
+	boolOption optionKind = iota
+	// valueOption takes a single value, passed as --name=value
+	valueOption
+	// listOption takes a comma-separated list and repeats the flag per item
+	listOption
+)
+
+// mysqlOptions are shared by mysqldump and mariadb-dump
+var mysqlOptions = map[string]optionKind{
+	"single-transaction":    boolOption,
+	"quick":                 boolOption,
+	"routines":              boolOption,
+	"triggers":              boolOption,
+	"events":                boolOption,
+	"hex-blob":              boolOption,
+	"lock-tables":           boolOption,
+	"add-drop-table":        boolOption,
+	"add-locks":             boolOption,
+	"complete-insert":       boolOption,
+	"extended-insert":       boolOption,
+	"order-by-primary":      boolOption,
+	"tz-utc":                boolOption,
+	"no-tablespaces":        boolOption,
+	"compress":              boolOption,
+	"max-allowed-packet":    valueOption,
+	"net-buffer-length":     valueOption,
+	"default-character-set": valueOption,
+	"ignore-table":          listOption,
+}
+
+// allowedOptions lists the dump tool flags that may be set per backup type.
+// Flags that change the connection, the output location or load external
+// files are deliberately left out so options cannot redirect a dump.
+var allowedOptions = map[model.BackupType]map[string]optionKind{
+	model.Postgres: {
+		"no-owner":                boolOption,
+		"no-privileges":           boolOption,
+		"no-comments":             boolOption,
+		"no-tablespaces":          boolOption,
+		"no-publications":         boolOption,
+		"no-subscriptions":        boolOption,
+		"no-unlogged-table-data":  boolOption,
+		"clean":                   boolOption,
+		"if-exists":               boolOption,
+		"create":                  boolOption,
+		"serializable-deferrable": boolOption,
+		"encoding":                valueOption,
+		"lock-wait-timeout":       valueOption,
+		"extra-float-digits":      valueOption,
+		"schema":                  listOption,
+		"exclude-schema":          listOption,
+		"table":                   listOption,
+		"exclude-table":           listOption,
+		"exclude-table-data":      listOption,
+		"exclude-database":        listOption,
+	},
+	model.MySQL:   mysqlOptions,
+	model.MariaDB: mysqlOptions,
+	model.Mongo: {
+		"oplog":                        boolOption,
+		"forceTableScan":               boolOption,
+		"authenticationDatabase":       valueOption,
+		"readPreference":               valueOption,
+		"numParallelCollections":       valueOption,
+		"collection":                   valueOption,
+		"excludeCollection":            listOption,
+		"excludeCollectionsWithPrefix": listOption,
+	},
+}
+
+// optionEnabled reports whether a switch option is set and not turned off
+func optionEnabled(options map[string]string, name string) bool {
+	value, ok := options[name]
+	return ok && value != "false"
+}
+
+// pgDumpallOptions are the Postgres options that pg_dumpall also accepts
+var pgDumpallOptions = map[string]bool{
+	"no-owner":               true,
+	"no-privileges":          true,
+	"no-comments":            true,
+	"no-tablespaces":         true,
+	"no-publications":        true,
+	"no-subscriptions":       true,
+	"no-unlogged-table-data": true,
+	"clean":                  true,
+	"if-exists":              true,
+	"encoding":               true,
+	"lock-wait-timeout":      true,
+	"extra-float-digits":     true,
+	"exclude-database":       true,
+}
+
+// ValidateOptions checks that every option is allowed for the backup type and
+// has a well-formed value
+func ValidateOptions(t model.BackupType, options map[string]string) error {
+	if len(options) == 0 {
+		return nil
+	}
+
+	allowed, ok := allowedOptions[t]
+	if !ok {
+		return fmt.Errorf("options are not supported for %s", t)
+	}
+
+	for _, name := range sortedKeys(options) {
+		value := options[name]
+		kind, ok := allowed[name]
+		if !ok {
+			return fmt.Errorf("option %q is not allowed for %s", name, t)
+		}
+		if strings.ContainsAny(value, "\x00\r\n") {
+			return fmt.Errorf("option %q contains invalid characters", name)
+		}
+
+		switch kind {
+		case boolOption:
+			if value != "" && value != "true" && value != "false" {
+				return fmt.Errorf("option %q must be true or false", name)
+			}
+		case valueOption:
+			if value == "" {
+				return fmt.Errorf("option %q requires a value", name)
+			}
+		case listOption:
+			for _, item := range strings.Split(value, ",") {
+				if strings.TrimSpace(item) == "" {
+					return fmt.Errorf("option %q has an empty list item", name)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// optionArgs turns validated options into command line flags. Disabled
+// switches become --skip-name when the tool supports it (mysqldump and
+// mariadb-dump), so they can override defaults passed earlier on the command
+// line; other tools simply leave them out.
+func optionArgs(t model.BackupType, options map[string]string, include func(name string) bool) []string {
+	allowed := allowedOptions[t]
+	skipForm := t == model.MySQL || t == model.MariaDB
+
+	var args []string
+	for _, name := range sortedKeys(options) {
+		kind, ok := allowed[name]
+		if !ok || (include != nil && !include(name)) {
+			continue
+		}
+
+		value := options[name]
+		switch kind {
+		case boolOption:
+			if value == "false" {
+				if skipForm {
+					args = append(args, fmt.Sprintf("--skip-%s", name))
+				}
+				continue
+			}
+			args = append(args, fmt.Sprintf("--%s", name))
+		case valueOption:
+			args = append(args, fmt.Sprintf("--%s=%s", name, value))
+		case listOption:
+			for _, item := range splitOptionList(value) {
+				args = append(args, fmt.Sprintf("--%s=%s", name, item))
+			}
+		}
+	}
+
+	return args
+}
+
+// splitOptionList splits a list option value into its trimmed items
+func splitOptionList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
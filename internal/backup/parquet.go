@@ -0,0 +1,545 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/golang/snappy"
+)
+
+// Parquet physical types, repetitions, encodings and codecs from the format
+// specification
+const (
+	parquetBoolean   = 0
+	parquetInt64     = 2
+	parquetDouble    = 5
+	parquetByteArray = 6
+
+	parquetOptional = 1
+
+	parquetPlain = 0
+	parquetRLE   = 3
+
+	parquetSnappy = 1
+
+	parquetUTF8 = 0
+)
+
+// parquetRowGroupSize is the buffered size at which a row group is written
+var parquetRowGroupSize = 64 << 20
+
+// jsonKind is the kind of a JSON value, from narrowest to widest
+type jsonKind int
+
+const (
+	kindNull jsonKind = iota
+	kindBool
+	kindInt
+	kindFloat
+	kindString
+)
+
+// parquetColumn buffers the values of one column for the current row group
+type parquetColumn struct {
+	name   string
+	kind   jsonKind
+	levels []byte
+	values bytes.Buffer
+	// bits packs boolean values, which PLAIN writes one bit each
+	bits  byte
+	nbits int
+	nulls int
+}
+
+func (c *parquetColumn) physicalType() int32 {
+	switch c.kind {
+	case kindBool:
+		return parquetBoolean
+	case kindInt:
+		return parquetInt64
+	case kindFloat:
+		return parquetDouble
+	}
+	return parquetByteArray
+}
+
+// add appends one value, or a null when raw is nil or JSON null
+func (c *parquetColumn) add(raw json.RawMessage) error {
+	kind := jsonValueKind(raw)
+	if kind == kindNull {
+		c.levels = append(c.levels, 0)
+		c.nulls++
+		return nil
+	}
+	c.levels = append(c.levels, 1)
+
+	switch c.kind {
+	case kindBool:
+		if string(raw) == "true" {
+			c.bits |= 1 << c.nbits
+		}
+		if c.nbits++; c.nbits == 8 {
+			c.values.WriteByte(c.bits)
+			c.bits, c.nbits = 0, 0
+		}
+	case kindInt:
+		n, err := strconv.ParseInt(string(raw), 10, 64)
+		if err != nil {
+			return err
+		}
+		binary.Write(&c.values, binary.LittleEndian, n)
+	case kindFloat:
+		f, err := strconv.ParseFloat(string(raw), 64)
+		if err != nil {
+			return err
+		}
+		binary.Write(&c.values, binary.LittleEndian, math.Float64bits(f))
+	default:
+		text := jsonText(raw, kind)
+		binary.Write(&c.values, binary.LittleEndian, uint32(len(text)))
+		c.values.WriteString(text)
+	}
+	return nil
+}
+
+// page returns the definition levels and values of the buffered rows
+func (c *parquetColumn) page() []byte {
+	if c.nbits > 0 {
+		c.values.WriteByte(c.bits)
+		c.bits, c.nbits = 0, 0
+	}
+
+	// Definition levels use the RLE hybrid encoding with a bit width of one,
+	// written as runs of equal levels
+	var levels bytes.Buffer
+	for i := 0; i < len(c.levels); {
+		j := i
+		for j < len(c.levels) && c.levels[j] == c.levels[i] {
+			j++
+		}
+		levels.Write(binary.AppendUvarint(nil, uint64(j-i)<<1))
+		levels.WriteByte(c.levels[i])
+		i = j
+	}
+
+	page := make([]byte, 4, 4+levels.Len()+c.values.Len())
+	binary.LittleEndian.PutUint32(page, uint32(levels.Len()))
+	page = append(page, levels.Bytes()...)
+	return append(page, c.values.Bytes()...)
+}
+
+func (c *parquetColumn) reset() {
+	c.levels = c.levels[:0]
+	c.values.Reset()
+	c.nulls = 0
+}
+
+// jsonValueKind classifies a JSON value. Objects and arrays count as strings
+// and are written as JSON text.
+func jsonValueKind(raw json.RawMessage) jsonKind {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 {
+		return kindNull
+	}
+	switch raw[0] {
+	case 'n':
+		return kindNull
+	case 't', 'f':
+		return kindBool
+	case '"', '{', '[':
+		return kindString
+	}
+	if _, err := strconv.ParseInt(string(raw), 10, 64); err == nil {
+		return kindInt
+	}
+	return kindFloat
+}
+
+// jsonText returns the text written for a string column. Strings are
+// unquoted, single-field extended JSON wrappers such as {"$oid": "..."} or
+// {"$date": "..."} are unwrapped and anything else keeps its JSON text.
+func jsonText(raw json.RawMessage, kind jsonKind) string {
+	raw = bytes.TrimSpace(raw)
+	switch {
+	case raw[0] == '"':
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			return s
+		}
+	case raw[0] == '{':
+		var wrapper map[string]json.RawMessage
+		if json.Unmarshal(raw, &wrapper) == nil && len(wrapper) == 1 {
+			for key, value := range wrapper {
+				if strings.HasPrefix(key, "$") && jsonValueKind(value) == kindString && bytes.TrimSpace(value)[0] == '"' {
+					return jsonText(value, kindString)
+				}
+			}
+		}
+	}
+	return string(raw)
+}
+
+// widen returns the kind that holds values of both kinds
+func widen(a, b jsonKind) jsonKind {
+	switch {
+	case a == kindNull:
+		return b
+	case b == kindNull || a == b:
+		return a
+	case (a == kindInt && b == kindFloat) || (a == kindFloat && b == kindInt):
+		return kindFloat
+	}
+	return kindString
+}
+
+// jsonObject calls fn for each field of a JSON object in document order
+func jsonObject(line []byte, fn func(key string, value json.RawMessage) error) error {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	if tok, err := dec.Token(); err != nil || tok != json.Delim('{') {
+		return fmt.Errorf("expected a JSON object")
+	}
+	for dec.More() {
+		tok, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+		if err := fn(tok.(string), value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeParquet converts a JSON lines file to Parquet. A first pass collects
+// the fields in order of appearance and the narrowest type holding all of
+// their values; fields with mixed types become strings. Every column is
+// optional and pages are snappy compressed. It returns the number of rows.
+func writeParquet(jsonPath string, w io.Writer) (int64, error) {
+	in, err := os.Open(jsonPath)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	var columns []*parquetColumn
+	index := map[string]int{}
+	err = jsonLines(in, func(line []byte) error {
+		return jsonObject(line, func(key string, value json.RawMessage) error {
+			i, ok := index[key]
+			if !ok {
+				i = len(columns)
+				index[key] = i
+				columns = append(columns, &parquetColumn{name: key})
+			}
+			columns[i].kind = widen(columns[i].kind, jsonValueKind(value))
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if _, err := in.Seek(0, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	pw := &parquetWriter{w: w, columns: columns}
+	if err := pw.writeMagic(); err != nil {
+		return 0, err
+	}
+
+	row := make([]json.RawMessage, len(columns))
+	err = jsonLines(in, func(line []byte) error {
+		clear(row)
+		if err := jsonObject(line, func(key string, value json.RawMessage) error {
+			row[index[key]] = value
+			return nil
+		}); err != nil {
+			return err
+		}
+		for i, column := range columns {
+			if err := column.add(row[i]); err != nil {
+				return fmt.Errorf("field %s: %w", column.name, err)
+			}
+		}
+		pw.rows++
+		if pw.buffered() >= parquetRowGroupSize {
+			return pw.flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	if err := pw.close(); err != nil {
+		return 0, err
+	}
+	return pw.total, nil
+}
+
+// jsonLines calls fn for every non-empty line
+func jsonLines(r io.Reader, fn func(line []byte) error) error {
+	br := bufio.NewReaderSize(r, 64*1024)
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(bytes.TrimSpace(line)) > 0 {
+			if fnErr := fn(line); fnErr != nil {
+				return fnErr
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// parquetWriter writes row groups of one data page per column, then the
+// footer with the file metadata
+type parquetWriter struct {
+	w       io.Writer
+	columns []*parquetColumn
+	offset  int64
+	rows    int64
+	total   int64
+	groups  []parquetRowGroup
+}
+
+type parquetRowGroup struct {
+	rows   int64
+	size   int64
+	chunks []parquetChunk
+}
+
+type parquetChunk struct {
+	offset       int64
+	uncompressed int64
+	compressed   int64
+}
+
+func (p *parquetWriter) write(b []byte) error {
+	n, err := p.w.Write(b)
+	p.offset += int64(n)
+	return err
+}
+
+func (p *parquetWriter) writeMagic() error {
+	return p.write([]byte("PAR1"))
+}
+
+func (p *parquetWriter) buffered() int {
+	size := 0
+	for _, column := range p.columns {
+		size += len(column.levels) + column.values.Len()
+	}
+	return size
+}
+
+// flush writes the buffered rows as a row group
+func (p *parquetWriter) flush() error {
+	if p.rows == 0 {
+		return nil
+	}
+
+	group := parquetRowGroup{rows: p.rows}
+	for _, column := range p.columns {
+		data := column.page()
+		compressed := snappy.Encode(nil, data)
+
+		var header thriftWriter
+		header.i32(1, 0) // DATA_PAGE
+		header.i32(2, int32(len(data)))
+		header.i32(3, int32(len(compressed)))
+		header.begin(5)
+		header.i32(1, int32(p.rows))
+		header.i32(2, parquetPlain)
+		header.i32(3, parquetRLE)
+		header.i32(4, parquetRLE)
+		header.end()
+		header.stop()
+
+		chunk := parquetChunk{
+			offset:       p.offset,
+			uncompressed: int64(header.buf.Len() + len(data)),
+			compressed:   int64(header.buf.Len() + len(compressed)),
+		}
+		if err := p.write(header.buf.Bytes()); err != nil {
+			return err
+		}
+		if err := p.write(compressed); err != nil {
+			return err
+		}
+		group.chunks = append(group.chunks, chunk)
+		group.size += chunk.uncompressed
+		column.reset()
+	}
+
+	p.groups = append(p.groups, group)
+	p.total += p.rows
+	p.rows = 0
+	return nil
+}
+
+// close writes the last row group and the footer
+func (p *parquetWriter) close() error {
+	if err := p.flush(); err != nil {
+		return err
+	}
+
+	var meta thriftWriter
+	meta.i32(1, 1)
+	meta.list(2, thriftStruct, len(p.columns)+1)
+	meta.element()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(p.columns)))
+	meta.stop()
+	for _, column := range p.columns {
+		meta.element()
+		meta.i32(1, column.physicalType())
+		meta.i32(3, parquetOptional)
+		meta.binary(4, column.name)
+		if column.physicalType() == parquetByteArray {
+			meta.i32(6, parquetUTF8)
+		}
+		meta.stop()
+	}
+	meta.i64(3, p.total)
+	meta.list(4, thriftStruct, len(p.groups))
+	for _, group := range p.groups {
+		meta.element()
+		meta.list(1, thriftStruct, len(group.chunks))
+		for i, chunk := range group.chunks {
+			column := p.columns[i]
+			meta.element()
+			meta.i64(2, chunk.offset)
+			meta.begin(3)
+			meta.i32(1, column.physicalType())
+			meta.list(2, thriftI32, 2)
+			meta.varint(parquetPlain)
+			meta.varint(parquetRLE)
+			meta.list(3, thriftBinary, 1)
+			meta.string(column.name)
+			meta.i32(4, parquetSnappy)
+			meta.i64(5, group.rows)
+			meta.i64(6, chunk.uncompressed)
+			meta.i64(7, chunk.compressed)
+			meta.i64(9, chunk.offset)
+			meta.end()
+			meta.stop()
+		}
+		meta.i64(2, group.size)
+		meta.i64(3, group.rows)
+		meta.stop()
+	}
+	meta.binary(6, "db-backup")
+	meta.stop()
+
+	if err := p.write(meta.buf.Bytes()); err != nil {
+		return err
+	}
+	footer := binary.LittleEndian.AppendUint32(nil, uint32(meta.buf.Len()))
+	return p.write(append(footer, "PAR1"...))
+}
+
+// Thrift compact protocol field types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter encodes the Thrift compact protocol, which Parquet uses for
+// page headers and file metadata. Structs nest by pushing the last field ID.
+type thriftWriter struct {
+	buf  bytes.Buffer
+	last []int16
+	id   int16
+}
+
+func (t *thriftWriter) field(id int16, kind byte) {
+	if delta := id - t.id; delta > 0 && delta <= 15 {
+		t.buf.WriteByte(byte(delta)<<4 | kind)
+	} else {
+		t.buf.WriteByte(kind)
+		t.varint(int64(id))
+	}
+	t.id = id
+}
+
+// varint writes a zigzag varint, used for integers and list elements
+func (t *thriftWriter) varint(v int64) {
+	t.buf.Write(binary.AppendUvarint(nil, uint64(v<<1)^uint64(v>>63)))
+}
+
+func (t *thriftWriter) string(s string) {
+	t.buf.Write(binary.AppendUvarint(nil, uint64(len(s))))
+	t.buf.WriteString(s)
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.varint(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.varint(v)
+}
+
+func (t *thriftWriter) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.string(s)
+}
+
+// list starts a list field of n elements
+func (t *thriftWriter) list(id int16, kind byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.buf.WriteByte(byte(n)<<4 | kind)
+	} else {
+		t.buf.WriteByte(0xf0 | kind)
+		t.buf.Write(binary.AppendUvarint(nil, uint64(n)))
+	}
+}
+
+// begin starts a struct field, end returns to the enclosing struct
+func (t *thriftWriter) begin(id int16) {
+	t.field(id, thriftStruct)
+	t.element()
+}
+
+// element starts a struct element of a list; stop closes it
+func (t *thriftWriter) element() {
+	t.last = append(t.last, t.id)
+	t.id = 0
+}
+
+func (t *thriftWriter) end() {
+	t.stop()
+}
+
+// stop writes the end of a struct and restores the enclosing field ID
+func (t *thriftWriter) stop() {
+	t.buf.WriteByte(0)
+	if n := len(t.last); n > 0 {
+		t.id = t.last[n-1]
+		t.last = t.last[:n-1]
+	}
+}
@@ -0,0 +1,346 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/golang/snappy"
+)
+
+// The reader below decodes Parquet files independently of the writer: it
+// parses the Thrift compact protocol generically and follows the format
+// specification for page headers, definition levels and PLAIN values.
+
+type thriftStructValue map[int16]interface{}
+
+type thriftReader struct {
+	b   []byte
+	pos int
+}
+
+func (r *thriftReader) byte() byte {
+	b := r.b[r.pos]
+	r.pos++
+	return b
+}
+
+func (r *thriftReader) uvarint() uint64 {
+	v, n := binary.Uvarint(r.b[r.pos:])
+	if n <= 0 {
+		panic("bad varint")
+	}
+	r.pos += n
+	return v
+}
+
+func (r *thriftReader) zigzag() int64 {
+	v := r.uvarint()
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+func (r *thriftReader) value(kind byte) interface{} {
+	switch kind {
+	case 1:
+		return true
+	case 2:
+		return false
+	case 3:
+		return int8(r.byte())
+	case 4, 5, 6:
+		return r.zigzag()
+	case 7:
+		v := math.Float64frombits(binary.LittleEndian.Uint64(r.b[r.pos:]))
+		r.pos += 8
+		return v
+	case 8:
+		n := int(r.uvarint())
+		s := string(r.b[r.pos : r.pos+n])
+		r.pos += n
+		return s
+	case 9, 10:
+		header := r.byte()
+		n := int(header >> 4)
+		if n == 15 {
+			n = int(r.uvarint())
+		}
+		elem := header & 0x0f
+		list := make([]interface{}, n)
+		for i := range list {
+			if elem == 1 || elem == 2 {
+				list[i] = r.byte() == 1
+			} else {
+				list[i] = r.value(elem)
+			}
+		}
+		return list
+	case 12:
+		return r.structure()
+	}
+	panic(fmt.Sprintf("unsupported thrift type %d", kind))
+}
+
+func (r *thriftReader) structure() thriftStructValue {
+	s := thriftStructValue{}
+	var last int16
+	for {
+		header := r.byte()
+		if header == 0 {
+			return s
+		}
+		id := last + int16(header>>4)
+		if header>>4 == 0 {
+			id = int16(r.zigzag())
+		}
+		s[id] = r.value(header & 0x0f)
+		last = id
+	}
+}
+
+type parquetFile struct {
+	columns   []string
+	types     []int64
+	rows      int64
+	rowGroups int
+	// values holds the decoded values per column, nil for nulls
+	values [][]interface{}
+}
+
+func readParquetFile(t *testing.T, data []byte) *parquetFile {
+	t.Helper()
+	if !bytes.HasPrefix(data, []byte("PAR1")) || !bytes.HasSuffix(data, []byte("PAR1")) {
+		t.Fatalf("missing PAR1 magic")
+	}
+	size := int(binary.LittleEndian.Uint32(data[len(data)-8:]))
+	footer := &thriftReader{b: data[len(data)-8-size : len(data)-8]}
+	meta := footer.structure()
+	if footer.pos != size {
+		t.Fatalf("footer is %d bytes, decoded %d", size, footer.pos)
+	}
+
+	f := &parquetFile{rows: meta[3].(int64)}
+	schema := meta[2].([]interface{})
+	root := schema[0].(thriftStructValue)
+	if int(root[5].(int64)) != len(schema)-1 {
+		t.Fatalf("root has %d children, schema lists %d", root[5], len(schema)-1)
+	}
+	for _, element := range schema[1:] {
+		e := element.(thriftStructValue)
+		if e[3].(int64) != parquetOptional {
+			t.Fatalf("column %s is not optional", e[4])
+		}
+		f.columns = append(f.columns, e[4].(string))
+		f.types = append(f.types, e[1].(int64))
+	}
+	f.values = make([][]interface{}, len(f.columns))
+
+	var total int64
+	for _, group := range meta[4].([]interface{}) {
+		g := group.(thriftStructValue)
+		rows := g[3].(int64)
+		total += rows
+		f.rowGroups++
+		for i, chunk := range g[1].([]interface{}) {
+			cm := chunk.(thriftStructValue)[3].(thriftStructValue)
+			if cm[1].(int64) != f.types[i] || cm[4].(int64) != parquetSnappy || cm[5].(int64) != rows {
+				t.Fatalf("column chunk metadata %v does not match the schema", cm)
+			}
+			if cm[3].([]interface{})[0].(string) != f.columns[i] {
+				t.Fatalf("column chunk path %v, want %s", cm[3], f.columns[i])
+			}
+			offset := cm[9].(int64)
+			values := readParquetPage(t, data, offset, cm[7].(int64), rows, f.types[i])
+			f.values[i] = append(f.values[i], values...)
+		}
+	}
+	if total != f.rows {
+		t.Fatalf("row groups hold %d rows, footer says %d", total, f.rows)
+	}
+	return f
+}
+
+func readParquetPage(t *testing.T, data []byte, offset, chunkSize, rows int64, physical int64) []interface{} {
+	t.Helper()
+	r := &thriftReader{b: data[offset:]}
+	header := r.structure()
+	if header[1].(int64) != 0 {
+		t.Fatalf("page type %d, want DATA_PAGE", header[1])
+	}
+	compressed := int(header[3].(int64))
+	if int64(r.pos+compressed) != chunkSize {
+		t.Fatalf("chunk is %d bytes, page header and data are %d", chunkSize, r.pos+compressed)
+	}
+	dataPage := header[5].(thriftStructValue)
+	if dataPage[1].(int64) != rows || dataPage[2].(int64) != parquetPlain || dataPage[3].(int64) != parquetRLE {
+		t.Fatalf("unexpected data page header %v", dataPage)
+	}
+
+	page, err := snappy.Decode(nil, data[offset+int64(r.pos):offset+int64(r.pos+compressed)])
+	if err != nil {
+		t.Fatalf("snappy: %v", err)
+	}
+	if len(page) != int(header[2].(int64)) {
+		t.Fatalf("page is %d bytes, header says %d", len(page), header[2])
+	}
+
+	levelsSize := int(binary.LittleEndian.Uint32(page))
+	levels := decodeLevels(t, page[4:4+levelsSize], int(rows))
+	values := page[4+levelsSize:]
+
+	out := make([]interface{}, rows)
+	present := 0
+	for i, level := range levels {
+		if level == 0 {
+			continue
+		}
+		switch physical {
+		case parquetBoolean:
+			out[i] = values[present/8]>>(present%8)&1 == 1
+		case parquetInt64:
+			out[i] = int64(binary.LittleEndian.Uint64(values))
+			values = values[8:]
+		case parquetDouble:
+			out[i] = math.Float64frombits(binary.LittleEndian.Uint64(values))
+			values = values[8:]
+		case parquetByteArray:
+			n := binary.LittleEndian.Uint32(values)
+			out[i] = string(values[4 : 4+n])
+			values = values[4+n:]
+		default:
+			t.Fatalf("unexpected physical type %d", physical)
+		}
+		present++
+	}
+	if physical == parquetBoolean {
+		if want := (present + 7) / 8; len(values) != want {
+			t.Fatalf("boolean page has %d value bytes, want %d", len(values), want)
+		}
+	} else if len(values) != 0 {
+		t.Fatalf("%d bytes left after the values", len(values))
+	}
+	return out
+}
+
+// decodeLevels reads definition levels of bit width one in the RLE / bit
+// packing hybrid encoding
+func decodeLevels(t *testing.T, b []byte, n int) []byte {
+	t.Helper()
+	var levels []byte
+	for len(b) > 0 {
+		header, size := binary.Uvarint(b)
+		b = b[size:]
+		if header&1 == 0 {
+			count := int(header >> 1)
+			for i := 0; i < count; i++ {
+				levels = append(levels, b[0])
+			}
+			b = b[1:]
+			continue
+		}
+		groups := int(header >> 1)
+		for _, packed := range b[:groups] {
+			for bit := 0; bit < 8; bit++ {
+				levels = append(levels, packed>>bit&1)
+			}
+		}
+		b = b[groups:]
+	}
+	if len(levels) < n {
+		t.Fatalf("decoded %d levels, want %d", len(levels), n)
+	}
+	return levels[:n]
+}
+
+func writeParquetFile(t *testing.T, jsonl string) (*parquetFile, int64) {
+	t.Helper()
+	src := filepath.Join(t.TempDir(), "rows.jsonl")
+	if err := os.WriteFile(src, []byte(jsonl), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	rows, err := writeParquet(src, &buf)
+	if err != nil {
+		t.Fatalf("writeParquet: %v", err)
+	}
+	return readParquetFile(t, buf.Bytes()), rows
+}
+
+func TestWriteParquetMixedTypes(t *testing.T) {
+	jsonl := strings.Join([]string{
+		`{"id": 1, "name": "alpha", "score": 1, "active": true, "note": null, "mixed": 7}`,
+		`{"id": 2, "name": null, "score": 2.5, "active": false, "mixed": "seven"}`,
+		``,
+		`{"id": 3, "name": "gamma", "score": -3, "active": null, "tags": ["a", "b"], "owner": {"$oid": "64b7f0"}}`,
+		`{"name": "delta \"quoted\"", "id": 4, "score": 1e3, "active": true, "mixed": false}`,
+	}, "\n") + "\n"
+
+	f, rows := writeParquetFile(t, jsonl)
+
+	if rows != 4 || f.rows != 4 {
+		t.Fatalf("rows = %d, footer rows = %d, want 4", rows, f.rows)
+	}
+	wantColumns := []string{"id", "name", "score", "active", "note", "mixed", "tags", "owner"}
+	if !reflect.DeepEqual(f.columns, wantColumns) {
+		t.Fatalf("columns = %v, want %v", f.columns, wantColumns)
+	}
+	wantTypes := []int64{parquetInt64, parquetByteArray, parquetDouble, parquetBoolean, parquetByteArray, parquetByteArray, parquetByteArray, parquetByteArray}
+	if !reflect.DeepEqual(f.types, wantTypes) {
+		t.Fatalf("types = %v, want %v", f.types, wantTypes)
+	}
+
+	want := [][]interface{}{
+		{int64(1), int64(2), int64(3), int64(4)},
+		{"alpha", nil, "gamma", `delta "quoted"`},
+		{1.0, 2.5, -3.0, 1000.0},
+		{true, false, nil, true},
+		{nil, nil, nil, nil},
+		{"7", "seven", nil, "false"},
+		{nil, nil, `["a", "b"]`, nil},
+		{nil, nil, "64b7f0", nil},
+	}
+	for i, column := range f.columns {
+		if !reflect.DeepEqual(f.values[i], want[i]) {
+			t.Errorf("column %s = %#v, want %#v", column, f.values[i], want[i])
+		}
+	}
+}
+
+func TestWriteParquetRowGroups(t *testing.T) {
+	defer func(size int) { parquetRowGroupSize = size }(parquetRowGroupSize)
+	parquetRowGroupSize = 256
+
+	var b strings.Builder
+	const n = 203
+	for i := 0; i < n; i++ {
+		switch {
+		case i%10 == 0:
+			fmt.Fprintf(&b, `{"n": %d, "flag": null, "label": null}`+"\n", i)
+		default:
+			fmt.Fprintf(&b, `{"n": %d, "flag": %t, "label": "row %d"}`+"\n", i, i%3 == 0, i)
+		}
+	}
+
+	f, rows := writeParquetFile(t, b.String())
+
+	if rows != n || f.rows != n {
+		t.Fatalf("rows = %d, footer rows = %d, want %d", rows, f.rows, n)
+	}
+	if f.rowGroups < 2 {
+		t.Fatalf("got %d row groups, want several", f.rowGroups)
+	}
+	for i := 0; i < n; i++ {
+		var flag, label interface{}
+		if i%10 != 0 {
+			flag, label = i%3 == 0, fmt.Sprintf("row %d", i)
+		}
+		if f.values[0][i] != int64(i) || f.values[1][i] != flag || f.values[2][i] != label {
+			t.Fatalf("row %d = %v %v %v, want %d %v %v", i, f.values[0][i], f.values[1][i], f.values[2][i], i, flag, label)
+		}
+	}
+}
@@ -1,29 +1,58 @@
 package backup
 
 import (
+	"bytes"
 	"context"
 	"db-backup/internal/model"
 	"fmt"
+	"log"
+	"net"
 	"os"
 	"os/exec"
+	"strconv"
+	"strings"
+	"time"
 )
 
-type PostgresBackup struct{}
+type PostgresBackup struct {
+	// serverMajor is set by CheckVersion and selects the matching client
+	serverMajor int
+}
 
 func (b *PostgresBackup) Backup(ctx context.Context, req model.BackupRequest) (string, error) {
+	if req.Scope.OrDefault() == model.ScopeServer {
+		return b.backupServer(ctx, req)
+	}
+	if req.Mode.OrDefault() == model.ModeSubset {
+		return postgresSubset(ctx, req, resolveToolForServer("pg_dump", b.serverMajor))
+	}
+
 	filename := generateFilename(req, "sql")
 
-	binPath := resolveExecutable("pg_dump")
-	// PGPASSWORD environment variable is the safest way to pass password to pg_dump
-	cmd := exec.CommandContext(ctx, binPath,
-		"-h", req.Host,
-		"-p", req.Port,
-		"-U", req.Username,
-		"-f", filename,
-		req.Database,
-	)
+	tlsFiles, err := writeTLSFiles(req.TLS)
+	if err != nil {
+		return "", err
+	}
+	defer tlsFiles.Cleanup()
+
+	args := []string{"-f", filename}
+
+	// exclude-database only applies to pg_dumpall
+	args = append(args, optionArgs(req.Type, req.Options, func(name string) bool {
+		return name != "exclude-database"
+	})...)
+
+	switch req.Mode.OrDefault() {
+	case model.ModeSchema:
+		args = append(args, "--schema-only")
+	case model.ModeData:
+		args = append(args, "--data-only")
+	}
+
+	args = append(args, req.Database)
 
-	cmd.Env = append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", req.Password))
+	binPath := resolveToolForServer("pg_dump", b.serverMajor)
+	cmd := pgCommand(ctx, req, tlsFiles, binPath, args...)
 
 	if output, err := cmd.CombinedOutput(); err != nil {
 		return "", fmt.Errorf("pg_dump failed: %s, output: %s", err, string(output))
@@ -31,3 +60,229 @@ func (b *PostgresBackup) Backup(ctx context.Context, req model.BackupRequest) (s
 
 	return filename, nil
 }
+
+// backupServer dumps roles, tablespaces and every database into one file
+func (b *PostgresBackup) backupServer(ctx context.Context, req model.BackupRequest) (string, error) {
+	filename := generateFilename(req, "sql")
+
+	tlsFiles, err := writeTLSFiles(req.TLS)
+	if err != nil {
+		return "", err
+	}
+	defer tlsFiles.Cleanup()
+
+	args := []string{"-f", filename}
+
+	args = append(args, optionArgs(req.Type, req.Options, func(name string) bool {
+		return pgDumpallOptions[name]
+	})...)
+
+	switch req.Mode.OrDefault() {
+	case model.ModeSchema:
+		args = append(args, "--schema-only")
+	case model.ModeData:
+		args = append(args, "--data-only")
+	}
+
+	binPath := resolveToolForServer("pg_dumpall", b.serverMajor)
+	cmd := pgCommand(ctx, req, tlsFiles, binPath, args...)
+
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return "", fmt.Errorf("pg_dumpall failed: %s, output: %s", err, string(output))
+	}
+
+	return filename, nil
+}
+
+// CheckVersion compares the server version with the pg_dump that will run.
+// pg_dump refuses to dump a newer server, so that case fails up front with
+// a hint instead of a cryptic error after connecting.
+func (b *PostgresBackup) CheckVersion(ctx context.Context, req model.BackupRequest) (string, error) {
+	rows, err := psqlQuery(ctx, req, maintenanceDatabase(req), "SHOW server_version_num")
+	if err != nil || len(rows) == 0 {
+		return fmt.Sprintf("could not determine server version: %v", err), nil
+	}
+
+	// server_version_num is MMmmpp since PostgreSQL 10 and MMmmpp with a
+	// two part major version before that, e.g. 90624 for 9.6.24
+	num, err := strconv.Atoi(strings.TrimSpace(rows[0]))
+	if err != nil {
+		return fmt.Sprintf("unexpected server version %q", rows[0]), nil
+	}
+	serverMajor := num / 10000
+	b.serverMajor = serverMajor
+
+	tool := "pg_dump"
+	if req.Scope.OrDefault() == model.ScopeServer {
+		tool = "pg_dumpall"
+	}
+
+	info := detectTool(ctx, tool, resolveToolForServer(tool, serverMajor))
+	if !info.Found || info.Major == 0 {
+		return fmt.Sprintf("could not determine %s version: %s", tool, info.Error), nil
+	}
+
+	if info.Major < serverMajor {
+		return "", fmt.Errorf("%s %d is older than server %d; install the PostgreSQL %d client or set %s",
+			tool, info.Major, serverMajor, serverMajor, toolEnvName(tool, serverMajor))
+	}
+
+	return "", nil
+}
+
+// TestConnection logs in and checks the account can read every table of the
+// database, or is a superuser for server backups since pg_dumpall reads the
+// role passwords
+func (b *PostgresBackup) TestConnection(ctx context.Context, req model.BackupRequest) (*model.ConnectionTestResult, error) {
+	start := time.Now()
+	rows, err := psqlQuery(ctx, req, maintenanceDatabase(req), `SELECT current_setting('server_version'), r.rolsuper,
+		(SELECT count(*) FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+		 WHERE c.relkind IN ('r', 'p', 'm', 'S')
+		   AND n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg\_toast%'
+		   AND NOT (has_schema_privilege(n.oid, 'USAGE') AND has_table_privilege(c.oid, 'SELECT')))
+		FROM pg_roles r WHERE r.rolname = current_user`)
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("current role not found in pg_roles")
+	}
+
+	fields := strings.Split(rows[0], "\t")
+	if len(fields) != 3 {
+		return nil, fmt.Errorf("unexpected psql output: %s", rows[0])
+	}
+
+	result := &model.ConnectionTestResult{
+		LatencyMs:     time.Since(start).Milliseconds(),
+		ServerVersion: fields[0],
+	}
+	superuser := fields[1] == "t"
+
+	if req.Scope.OrDefault() == model.ScopeServer {
+		if !superuser {
+			result.MissingPrivileges = append(result.MissingPrivileges, "SUPERUSER (pg_dumpall reads pg_authid)")
+		}
+		return result, nil
+	}
+
+	if unreadable, _ := strconv.Atoi(fields[2]); unreadable > 0 {
+		result.MissingPrivileges = append(result.MissingPrivileges,
+			fmt.Sprintf("SELECT on %d tables or sequences", unreadable))
+	}
+
+	return result, nil
+}
+
+// ListDatabases returns the connectable, non-template databases on the server
+func (b *PostgresBackup) ListDatabases(ctx context.Context, req model.BackupRequest) ([]string, error) {
+	return psqlQuery(ctx, req, maintenanceDatabase(req),
+		"SELECT datname FROM pg_database WHERE datallowconn AND NOT datistemplate ORDER BY datname")
+}
+
+// Discover returns the databases on the server with their schemas and sizes
+func (b *PostgresBackup) Discover(ctx context.Context, req model.BackupRequest) ([]model.DiscoveredDatabase, error) {
+	rows, err := psqlQuery(ctx, req, maintenanceDatabase(req),
+		"SELECT datname, pg_database_size(datname) FROM pg_database WHERE datallowconn AND NOT datistemplate ORDER BY datname")
+	if err != nil {
+		return nil, err
+	}
+
+	databases := []model.DiscoveredDatabase{}
+	for _, row := range rows {
+		name, size, _ := strings.Cut(row, "\t")
+		db := model.DiscoveredDatabase{Name: name}
+		db.SizeBytes, _ = strconv.ParseInt(size, 10, 64)
+
+		// Schemas live per database, so each one needs its own connection.
+		// Databases the account cannot connect to are still reported.
+		schemas, err := psqlQuery(ctx, req, name,
+			"SELECT nspname FROM pg_namespace WHERE nspname NOT LIKE 'pg\\_%' AND nspname <> 'information_schema' ORDER BY nspname")
+		if err != nil {
+			log.Printf("Failed to list schemas of %s: %v", name, err)
+		}
+		db.Schemas = schemas
+
+		databases = append(databases, db)
+	}
+
+	return databases, nil
+}
+
+// maintenanceDatabase returns the database used for server-level queries. Any
+// existing database works; fall back to the maintenance database when none is
+// configured.
+func maintenanceDatabase(req model.BackupRequest) string {
+	if req.Database != "" {
+		return req.Database
+	}
+	return "postgres"
+}
+
+// psqlQuery runs a query against a database and returns one tab-separated line
+// per row
+func psqlQuery(ctx context.Context, req model.BackupRequest, database, query string) ([]string, error) {
+	tlsFiles, err := writeTLSFiles(req.TLS)
+	if err != nil {
+		return nil, err
+	}
+	defer tlsFiles.Cleanup()
+
+	cmd := pgCommand(ctx, req, tlsFiles, resolveExecutable("psql"),
+		"-d", database,
+		"-A", "-t", "-F", "\t",
+		"-c", query,
+	)
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("psql failed: %s, output: %s", err, stderr.String())
+	}
+
+	return splitLines(output), nil
+}
+
+// pgCommand builds a command for a libpq based tool. The password and TLS
+// settings go through the environment so they stay out of the process list.
+func pgCommand(ctx context.Context, req model.BackupRequest, tlsFiles *tlsFiles, binPath string, args ...string) *exec.Cmd {
+	host := req.Host
+	env := append(os.Environ(), fmt.Sprintf("PGPASSWORD=%s", req.Password))
+
+	if cfg := req.TLS; cfg != nil && cfg.Enabled {
+		env = append(env, fmt.Sprintf("PGSSLMODE=%s", cfg.ModeOrDefault()))
+
+		switch {
+		case tlsFiles.CACert != "":
+			env = append(env, fmt.Sprintf("PGSSLROOTCERT=%s", tlsFiles.CACert))
+		case cfg.ModeOrDefault() == model.TLSVerifyFull:
+			// Verify against the system trust store (libpq 16+, which
+			// only allows it with verify-full)
+			env = append(env, "PGSSLROOTCERT=system")
+		}
+		if tlsFiles.ClientCert != "" {
+			env = append(env, fmt.Sprintf("PGSSLCERT=%s", tlsFiles.ClientCert))
+		}
+		if tlsFiles.ClientKey != "" {
+			env = append(env, fmt.Sprintf("PGSSLKEY=%s", tlsFiles.ClientKey))
+		}
+
+		// libpq checks the certificate against the host name, so connect to
+		// the address (e.g. an SSH tunnel) while verifying the server name
+		if cfg.ServerName != "" && net.ParseIP(req.Host) != nil {
+			env = append(env, fmt.Sprintf("PGHOSTADDR=%s", req.Host))
+			host = cfg.ServerName
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, binPath, append([]string{
+		"-h", host,
+		"-p", req.Port,
+		"-U", req.Username,
+	}, args...)...)
+	cmd.Env = env
+
+	return cmd
+}
@@ -1,45 +1,353 @@
 package backup
 
 import (
+	"archive/tar"
 	"context"
 	"db-backup/internal/model"
 	"fmt"
+	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
 type RedisBackup struct{}
 
 func (b *RedisBackup) Backup(ctx context.Context, req model.BackupRequest) (string, error) {
+	// RDB snapshots always contain the full dataset
+	if req.Mode.OrDefault() != model.ModeFull {
+		return "", unsupportedModeError(req)
+	}
+
 	// Redis backup is tricky remotely without just triggering SAVE and downloading dump.rdb.
 	// However, `redis-cli --rdb filename` is a standard way to do remote backup.
 
+	tlsFiles, err := writeTLSFiles(req.TLS)
+	if err != nil {
+		return "", err
+	}
+	defer tlsFiles.Cleanup()
+
+	host, port, err := resolveRedisTarget(ctx, req, tlsFiles)
+	if err != nil {
+		return "", err
+	}
+
+	if req.Redis != nil && req.Redis.Cluster {
+		return b.backupCluster(ctx, req, host, port, tlsFiles)
+	}
+
 	filename := generateFilename(req, "rdb")
+	if err := dumpRedisNode(ctx, req, host, port, tlsFiles, filename); err != nil {
+		return "", err
+	}
+
+	return filename, nil
+}
+
+// backupCluster takes one RDB per master shard and packs them into a single
+// tar archive, so the whole cluster is tracked as one backup
+func (b *RedisBackup) backupCluster(ctx context.Context, req model.BackupRequest, host, port string, tlsFiles *tlsFiles) (string, error) {
+	masters, err := redisClusterMasters(ctx, req, host, port, tlsFiles)
+	if err != nil {
+		return "", err
+	}
+
+	filename := generateFilename(req, "tar")
+	workDir, err := os.MkdirTemp(filepath.Dir(filename), "cluster-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create cluster work directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	outfile, err := os.Create(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to create backup file: %w", err)
+	}
+	defer outfile.Close()
+
+	tw := tar.NewWriter(outfile)
+	for _, addr := range masters {
+		shardHost, shardPort, err := net.SplitHostPort(addr)
+		if err != nil {
+			os.Remove(filename)
+			return "", fmt.Errorf("invalid cluster node address %s: %w", addr, err)
+		}
+
+		shardName := fmt.Sprintf("%s_%s.rdb", shardHost, shardPort)
+		shardFile := filepath.Join(workDir, shardName)
+		if err := dumpRedisNode(ctx, req, shardHost, shardPort, tlsFiles, shardFile); err != nil {
+			os.Remove(filename)
+			return "", fmt.Errorf("shard %s: %w", addr, err)
+		}
+
+		if err := addFileToTar(tw, shardFile, shardName); err != nil {
+			os.Remove(filename)
+			return "", err
+		}
+		// Keep disk usage to one shard at a time
+		os.Remove(shardFile)
+	}
+
+	if err := tw.Close(); err != nil {
+		os.Remove(filename)
+		return "", fmt.Errorf("failed to finish cluster archive: %w", err)
+	}
+
+	return filename, nil
+}
+
+// Discover returns the logical databases that hold keys. Redis backs up all of
+// them in a single RDB, so sizes are reported as key counts.
+func (b *RedisBackup) Discover(ctx context.Context, req model.BackupRequest) ([]model.DiscoveredDatabase, error) {
+	tlsFiles, err := writeTLSFiles(req.TLS)
+	if err != nil {
+		return nil, err
+	}
+	defer tlsFiles.Cleanup()
+
+	host, port, err := resolveRedisTarget(ctx, req, tlsFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	lines, err := redisLines(ctx, req, host, port, tlsFiles, "INFO", "keyspace")
+	if err != nil {
+		return nil, err
+	}
+
+	// Lines look like: db0:keys=12,expires=0,avg_ttl=0
+	databases := []model.DiscoveredDatabase{}
+	for _, line := range lines {
+		name, fields, ok := strings.Cut(line, ":")
+		if !ok || !strings.HasPrefix(name, "db") {
+			continue
+		}
+		db := model.DiscoveredDatabase{Name: name}
+		for _, field := range strings.Split(fields, ",") {
+			if keys, ok := strings.CutPrefix(field, "keys="); ok {
+				db.Keys, _ = strconv.ParseInt(keys, 10, 64)
+			}
+		}
+		databases = append(databases, db)
+	}
+
+	return databases, nil
+}
+
+// TestConnection sends INFO to the node that would be backed up and, for ACL
+// users on Redis 7+, asks whether the user may run SYNC to stream the RDB
+func (b *RedisBackup) TestConnection(ctx context.Context, req model.BackupRequest) (*model.ConnectionTestResult, error) {
+	tlsFiles, err := writeTLSFiles(req.TLS)
+	if err != nil {
+		return nil, err
+	}
+	defer tlsFiles.Cleanup()
+
+	host, port, err := resolveRedisTarget(ctx, req, tlsFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	lines, err := redisLines(ctx, req, host, port, tlsFiles, "INFO", "server")
+	if err != nil {
+		return nil, err
+	}
+	result := &model.ConnectionTestResult{LatencyMs: time.Since(start).Milliseconds()}
+
+	for _, line := range lines {
+		if version, ok := strings.CutPrefix(line, "redis_version:"); ok {
+			result.ServerVersion = version
+		}
+	}
+	// Errors such as NOAUTH come back as a reply rather than a failure
+	if result.ServerVersion == "" {
+		return nil, fmt.Errorf("unexpected INFO reply: %s", strings.Join(lines, " "))
+	}
+
+	// The default user has every permission unless it was restricted
+	if req.Username == "" || req.Username == "default" {
+		return result, nil
+	}
+	if major, _ := parseVersion(result.ServerVersion); major < 7 {
+		result.Warning = "SYNC permission not checked before Redis 7"
+		return result, nil
+	}
 
-	binPath := resolveExecutable("redis-cli")
-	cmd := exec.CommandContext(ctx, binPath,
-		"-h", req.Host,
-		"-p", req.Port,
-		"-a", req.Password, // Warning: password on CLI
-		"--rdb", filename,
-	)
+	reply, err := redisLines(ctx, req, host, port, tlsFiles, "ACL", "DRYRUN", req.Username, "SYNC")
+	switch {
+	case err == nil && len(reply) == 1 && reply[0] == "OK":
+	case err == nil && len(reply) > 0 && strings.Contains(reply[0], "no permissions"):
+		result.MissingPrivileges = []string{"+sync"}
+	default:
+		result.Warning = fmt.Sprintf("could not check SYNC permission: %v %s", err, strings.Join(reply, " "))
+	}
+
+	return result, nil
+}
 
-	// redis-cli might output warning about password on CLI, but it's the standard CLI flag.
+// redisCommand builds a redis-cli command for a node. The password is passed
+// through REDISCLI_AUTH so it never shows up in the process list.
+func redisCommand(ctx context.Context, req model.BackupRequest, host, port string, tlsFiles *tlsFiles, args ...string) *exec.Cmd {
+	cliArgs := []string{"-h", host, "-p", port}
+	if req.Username != "" {
+		cliArgs = append(cliArgs, "--user", req.Username)
+	}
+	cliArgs = append(cliArgs, redisTLSArgs(req.TLS, tlsFiles)...)
+	cliArgs = append(cliArgs, args...)
 
-	if output, err := cmd.CombinedOutput(); err != nil {
-		// If redis-cli fails, we check output.
-		return "", fmt.Errorf("redis-cli failed: %s, output: %s", err, string(output))
+	cmd := exec.CommandContext(ctx, resolveExecutable("redis-cli"), cliArgs...)
+	cmd.Env = os.Environ()
+	if req.Password != "" {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("REDISCLI_AUTH=%s", req.Password))
+	}
+
+	return cmd
+}
+
+func redisTLSArgs(cfg *model.TLSConfig, tlsFiles *tlsFiles) []string {
+	if cfg == nil || !cfg.Enabled {
+		return nil
+	}
+
+	args := []string{"--tls"}
+	if tlsFiles.CACert != "" {
+		args = append(args, "--cacert", tlsFiles.CACert)
+	}
+	if tlsFiles.ClientCert != "" {
+		args = append(args, "--cert", tlsFiles.ClientCert)
+	}
+	if tlsFiles.ClientKey != "" {
+		args = append(args, "--key", tlsFiles.ClientKey)
+	}
+	if cfg.ServerName != "" {
+		args = append(args, "--sni", cfg.ServerName)
+	}
+	// redis-cli cannot skip only the host name check, so verify-ca verifies
+	// the full certificate
+	if cfg.ModeOrDefault() == model.TLSRequire {
+		args = append(args, "--insecure")
+	}
+
+	return args
+}
+
+// useNativeRedis reports whether the built-in replication client is used
+// instead of redis-cli: when requested, or when redis-cli is not installed
+func useNativeRedis(req model.BackupRequest) bool {
+	return (req.Redis != nil && req.Redis.Native) || !toolAvailable("redis-cli")
+}
+
+// redisLines runs a command against a node and returns its output lines
+func redisLines(ctx context.Context, req model.BackupRequest, host, port string, tlsFiles *tlsFiles, args ...string) ([]string, error) {
+	if useNativeRedis(req) {
+		return redisNativeLines(ctx, req, host, port, args...)
+	}
+
+	cmd := redisCommand(ctx, req, host, port, tlsFiles, args...)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("redis-cli failed: %s, output: %s", err, string(output))
+	}
+
+	return splitLines(output), nil
+}
+
+// dumpRedisNode streams the RDB of a single node into filename and validates
+// it before the backup counts as complete
+func dumpRedisNode(ctx context.Context, req model.BackupRequest, host, port string, tlsFiles *tlsFiles, filename string) error {
+	if useNativeRedis(req) {
+		if err := dumpRedisNodeNative(ctx, req, host, port, filename); err != nil {
+			return err
+		}
+	} else {
+		cmd := redisCommand(ctx, req, host, port, tlsFiles, "--rdb", filename)
+
+		if output, err := cmd.CombinedOutput(); err != nil {
+			// If redis-cli fails, we check output.
+			return fmt.Errorf("redis-cli failed: %s, output: %s", err, string(output))
+		}
 	}
 
 	// Verify file exists and is not empty
 	info, err := os.Stat(filename)
 	if err != nil {
-		return "", fmt.Errorf("failed to verify backup file: %w", err)
+		return fmt.Errorf("failed to verify backup file: %w", err)
 	}
 	if info.Size() == 0 {
 		os.Remove(filename)
-		return "", fmt.Errorf("backup file is empty")
+		return fmt.Errorf("backup file is empty")
 	}
 
-	return filename, nil
+	if err := validateRDB(filename); err != nil {
+		os.Remove(filename)
+		return fmt.Errorf("invalid RDB from %s: %w", net.JoinHostPort(host, port), err)
+	}
+
+	return nil
+}
+
+// resolveRedisTarget returns the node to back up. With Sentinel configured,
+// the host is a Sentinel that is asked for the current master address.
+func resolveRedisTarget(ctx context.Context, req model.BackupRequest, tlsFiles *tlsFiles) (string, string, error) {
+	if req.Redis == nil || req.Redis.SentinelMaster == "" {
+		return req.Host, req.Port, nil
+	}
+
+	// Sentinels have their own (optional) password and no ACL user
+	sentinel := req
+	sentinel.Username = ""
+	sentinel.Password = req.Redis.SentinelPassword
+
+	lines, err := redisLines(ctx, sentinel, req.Host, req.Port, tlsFiles,
+		"SENTINEL", "get-master-addr-by-name", req.Redis.SentinelMaster)
+	if err != nil {
+		return "", "", fmt.Errorf("sentinel lookup failed: %w", err)
+	}
+
+	// The reply is the master host and port on separate lines
+	if len(lines) != 2 {
+		return "", "", fmt.Errorf("sentinel does not know master %q: %s", req.Redis.SentinelMaster, strings.Join(lines, " "))
+	}
+
+	return lines[0], lines[1], nil
+}
+
+// redisClusterMasters returns the addresses of the healthy master nodes of the
+// cluster the given node belongs to
+func redisClusterMasters(ctx context.Context, req model.BackupRequest, host, port string, tlsFiles *tlsFiles) ([]string, error) {
+	lines, err := redisLines(ctx, req, host, port, tlsFiles, "CLUSTER", "NODES")
+	if err != nil {
+		return nil, err
+	}
+
+	// Lines look like: <id> <ip:port@cport[,hostname]> <flags> <master> ...
+	var masters []string
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+
+		flags := "," + fields[2] + ","
+		if !strings.Contains(flags, ",master,") ||
+			strings.Contains(flags, ",fail,") ||
+			strings.Contains(flags, ",noaddr,") ||
+			strings.Contains(flags, ",handshake,") {
+			continue
+		}
+
+		addr, _, _ := strings.Cut(fields[1], "@")
+		masters = append(masters, addr)
+	}
+
+	if len(masters) == 0 {
+		return nil, fmt.Errorf("no healthy cluster masters found")
+	}
+
+	return masters, nil
 }
@@ -0,0 +1,397 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"db-backup/internal/model"
+	"encoding/binary"
+	"fmt"
+	"hash/crc64"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// redisConn is a minimal RESP client, enough to authenticate, run simple
+// commands and pull an RDB snapshot over the replication protocol without
+// redis-cli.
+type redisConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	stop func() bool
+}
+
+// dialRedis connects to a node and authenticates with the request credentials
+func dialRedis(ctx context.Context, req model.BackupRequest, host, port string) (*redisConn, error) {
+	addr := net.JoinHostPort(host, port)
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", addr, err)
+	}
+
+	tlsConfig, err := tlsClientConfig(req.TLS, host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if tlsConfig != nil {
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("TLS handshake with %s failed: %w", addr, err)
+		}
+		conn = tlsConn
+	}
+
+	// Unblock reads and writes when the context is cancelled
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+
+	c := &redisConn{conn: conn, r: bufio.NewReader(conn), stop: stop}
+
+	if req.Password != "" {
+		args := []string{"AUTH", req.Password}
+		if req.Username != "" {
+			args = []string{"AUTH", req.Username, req.Password}
+		}
+		if _, err := c.Do(args...); err != nil {
+			c.Close()
+			return nil, fmt.Errorf("authentication failed: %w", err)
+		}
+	}
+
+	return c, nil
+}
+
+func (c *redisConn) Close() error {
+	c.stop()
+	return c.conn.Close()
+}
+
+// Do sends a command and returns its reply: a string for simple and bulk
+// strings, int64 for integers, []interface{} for arrays and nil for null
+// replies. Error replies are returned as errors.
+func (c *redisConn) Do(args ...string) (interface{}, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&buf, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	if _, err := c.conn.Write(buf.Bytes()); err != nil {
+		return nil, fmt.Errorf("failed to send %s: %w", args[0], err)
+	}
+
+	return c.readReply()
+}
+
+func (c *redisConn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+func (c *redisConn) readReply() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read reply: %w", err)
+	}
+	if line == "" {
+		return nil, fmt.Errorf("empty reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, fmt.Errorf("%s", line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid bulk length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, data); err != nil {
+			return nil, fmt.Errorf("failed to read bulk reply: %w", err)
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("invalid array length %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			item, err := c.readReply()
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	default:
+		return nil, fmt.Errorf("unexpected reply %q", line)
+	}
+}
+
+// syncRDB asks the node for a full resynchronisation and streams the RDB
+// payload to w. The node keeps sending replication traffic afterwards, so the
+// connection must be closed once this returns.
+func (c *redisConn) syncRDB(w io.Writer, progress func(written, total int64)) (int64, error) {
+	// Announce ourselves as a replica that understands EOF-delimited
+	// payloads, which diskless masters send instead of a length
+	if _, err := c.Do("REPLCONF", "capa", "eof", "capa", "psync2"); err != nil {
+		return 0, fmt.Errorf("REPLCONF failed: %w", err)
+	}
+
+	reply, err := c.Do("PSYNC", "?", "-1")
+	if err != nil {
+		return 0, fmt.Errorf("PSYNC failed: %w", err)
+	}
+	if status, _ := reply.(string); !strings.HasPrefix(status, "FULLRESYNC") {
+		return 0, fmt.Errorf("unexpected PSYNC reply %v", reply)
+	}
+
+	// While the master writes the snapshot it sends bare newlines to keep the
+	// connection alive
+	var header string
+	for header == "" {
+		if header, err = c.readLine(); err != nil {
+			return 0, fmt.Errorf("failed to read RDB header: %w", err)
+		}
+	}
+	if header[0] != '$' {
+		return 0, fmt.Errorf("unexpected RDB header %q", header)
+	}
+
+	if mark, ok := strings.CutPrefix(header, "$EOF:"); ok {
+		return copyUntilMark(w, c.r, []byte(mark), progress)
+	}
+
+	total, err := strconv.ParseInt(header[1:], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid RDB length %q", header)
+	}
+
+	written, err := io.Copy(w, io.LimitReader(c.r, total))
+	if progress != nil {
+		progress(written, total)
+	}
+	if err != nil {
+		return written, fmt.Errorf("failed to read RDB payload: %w", err)
+	}
+	if written != total {
+		return written, fmt.Errorf("RDB payload truncated: got %d of %d bytes", written, total)
+	}
+
+	return written, nil
+}
+
+// copyUntilMark copies r to w until the 40-byte EOF mark of a diskless
+// transfer, which is not written
+func copyUntilMark(w io.Writer, r io.Reader, mark []byte, progress func(written, total int64)) (int64, error) {
+	var written int64
+	var pending []byte
+	buf := make([]byte, 64*1024)
+
+	for {
+		n, err := r.Read(buf)
+		pending = append(pending, buf[:n]...)
+
+		// Anything after the mark is replication traffic and is dropped
+		if i := bytes.Index(pending, mark); i >= 0 {
+			n, werr := w.Write(pending[:i])
+			written += int64(n)
+			if progress != nil {
+				progress(written, -1)
+			}
+			return written, werr
+		}
+
+		// Hold back enough bytes to detect a mark split across reads
+		if keep := len(mark); len(pending) > keep {
+			n, werr := w.Write(pending[:len(pending)-keep])
+			written += int64(n)
+			if werr != nil {
+				return written, werr
+			}
+			pending = append(pending[:0], pending[len(pending)-keep:]...)
+			if progress != nil {
+				progress(written, -1)
+			}
+		}
+
+		if err != nil {
+			return written, fmt.Errorf("failed to read RDB payload: %w", err)
+		}
+	}
+}
+
+// redisProgressLogger logs transfer progress at most every few seconds
+func redisProgressLogger(addr string) func(written, total int64) {
+	var last time.Time
+	return func(written, total int64) {
+		if time.Since(last) < 5*time.Second && written != total {
+			return
+		}
+		last = time.Now()
+		if total > 0 {
+			log.Printf("Redis sync from %s: %d/%d bytes (%.1f%%)", addr, written, total, float64(written)*100/float64(total))
+		} else {
+			log.Printf("Redis sync from %s: %d bytes", addr, written)
+		}
+	}
+}
+
+// dumpRedisNodeNative pulls the RDB of a single node over the replication
+// protocol into filename
+func dumpRedisNodeNative(ctx context.Context, req model.BackupRequest, host, port, filename string) error {
+	c, err := dialRedis(ctx, req, host, port)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	outfile, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("failed to create backup file: %w", err)
+	}
+	defer outfile.Close()
+
+	progress := redisProgressLogger(net.JoinHostPort(host, port))
+	if _, err := c.syncRDB(outfile, progress); err != nil {
+		os.Remove(filename)
+		return err
+	}
+
+	return nil
+}
+
+// redisNativeLines runs a command natively and flattens the reply into lines,
+// matching what redis-cli prints for the same command
+func redisNativeLines(ctx context.Context, req model.BackupRequest, host, port string, args ...string) ([]string, error) {
+	c, err := dialRedis(ctx, req, host, port)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Close()
+
+	reply, err := c.Do(args...)
+	if err != nil {
+		return nil, fmt.Errorf("%s failed: %w", args[0], err)
+	}
+
+	var lines []string
+	var flatten func(v interface{})
+	flatten = func(v interface{}) {
+		switch v := v.(type) {
+		case string:
+			lines = append(lines, splitLines([]byte(v))...)
+		case int64:
+			lines = append(lines, strconv.FormatInt(v, 10))
+		case []interface{}:
+			for _, item := range v {
+				flatten(item)
+			}
+		}
+	}
+	flatten(reply)
+
+	return lines, nil
+}
+
+// rdbCRC is the CRC-64/Jones table Redis uses for RDB checksums
+var rdbCRC = crc64.MakeTable(0x95AC9329AC4BC9B5)
+
+// validateRDB checks the RDB magic header, the EOF opcode and, for RDB v5 and
+// later, the CRC-64 trailer. A zero checksum means the server has checksums
+// disabled and is accepted.
+func validateRDB(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("failed to open RDB: %w", err)
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat RDB: %w", err)
+	}
+
+	header := make([]byte, 9)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return fmt.Errorf("RDB too short: %w", err)
+	}
+	if string(header[:5]) != "REDIS" {
+		return fmt.Errorf("not an RDB file: bad magic %q", header[:5])
+	}
+	version, err := strconv.Atoi(string(header[5:]))
+	if err != nil {
+		return fmt.Errorf("invalid RDB version %q", header[5:])
+	}
+
+	if version < 5 {
+		last := make([]byte, 1)
+		if _, err := file.ReadAt(last, info.Size()-1); err != nil || last[0] != 0xFF {
+			return fmt.Errorf("RDB is missing its EOF opcode")
+		}
+		return nil
+	}
+
+	if info.Size() < int64(len(header))+9 {
+		return fmt.Errorf("RDB too short for a checksum trailer")
+	}
+
+	trailer := make([]byte, 9)
+	if _, err := file.ReadAt(trailer, info.Size()-9); err != nil {
+		return fmt.Errorf("failed to read RDB trailer: %w", err)
+	}
+	if trailer[0] != 0xFF {
+		return fmt.Errorf("RDB is missing its EOF opcode")
+	}
+
+	expected := binary.LittleEndian.Uint64(trailer[1:])
+	if expected == 0 {
+		return nil
+	}
+
+	// crc64.Update inverts its input and output; undoing both gives the
+	// plain Jones CRC that Redis writes
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind RDB: %w", err)
+	}
+	var crc uint64
+	buf := make([]byte, 64*1024)
+	remaining := info.Size() - 8
+	for remaining > 0 {
+		n, err := file.Read(buf[:min(int64(len(buf)), remaining)])
+		crc = ^crc64.Update(^crc, rdbCRC, buf[:n])
+		remaining -= int64(n)
+		if err != nil && remaining > 0 {
+			return fmt.Errorf("failed to read RDB: %w", err)
+		}
+	}
+
+	if crc != expected {
+		return fmt.Errorf("RDB checksum mismatch: computed %016x, trailer %016x", crc, expected)
+	}
+
+	return nil
+}
@@ -0,0 +1,294 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"db-backup/internal/model"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// rdbFixture is an RDB v9 file holding aux fields and the key foo = bar. Its
+// trailer was computed with referenceCRC below, which matches the check
+// value in Redis's crc64.c.
+var rdbFixture, _ = hex.DecodeString("524544495330303039" + // REDIS0009
+	"fa0972656469732d76657205372e302e30" + // aux redis-ver 7.0.0
+	"fa0a72656469732d62697473c040" + // aux redis-bits 64
+	"fe00fb0100" + // select db 0, resizedb 1 0
+	"0003666f6f03626172" + // string foo = bar
+	"ff" + // EOF
+	"1a0d424d125c66c5") // CRC-64 little endian
+
+// referenceCRC is the bitwise CRC-64/Jones Redis uses, reflected with no
+// final xor
+func referenceCRC(data []byte) uint64 {
+	var crc uint64
+	for _, b := range data {
+		crc ^= uint64(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ 0x95AC9329AC4BC9B5
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+func TestRDBFixtureChecksum(t *testing.T) {
+	if got := referenceCRC([]byte("123456789")); got != 0xe9c6d914c4b8d9ca {
+		t.Fatalf("reference CRC of 123456789 = %016x, want e9c6d914c4b8d9ca", got)
+	}
+	body := rdbFixture[:len(rdbFixture)-8]
+	if got, want := referenceCRC(body), uint64(0xc5665c124d420d1a); got != want {
+		t.Fatalf("fixture CRC = %016x, want %016x", got, want)
+	}
+}
+
+func writeRDB(t *testing.T, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "dump.rdb")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestValidateRDB(t *testing.T) {
+	modify := func(fn func(b []byte) []byte) []byte {
+		return fn(append([]byte{}, rdbFixture...))
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"valid", rdbFixture, ""},
+		{"corrupted value", modify(func(b []byte) []byte { b[bytes.Index(b, []byte("bar"))] = 'c'; return b }), "checksum mismatch"},
+		{"corrupted trailer", modify(func(b []byte) []byte { b[len(b)-1] ^= 0x01; return b }), "checksum mismatch"},
+		{"checksums disabled", modify(func(b []byte) []byte { copy(b[len(b)-8:], make([]byte, 8)); return b }), ""},
+		{"truncated", rdbFixture[:len(rdbFixture)-20], "EOF opcode"},
+		{"missing trailer", rdbFixture[:len(rdbFixture)-8], "EOF opcode"},
+		{"bad magic", modify(func(b []byte) []byte { copy(b, "RADIS"); return b }), "bad magic"},
+		{"bad version", modify(func(b []byte) []byte { copy(b[5:], "00x9"); return b }), "invalid RDB version"},
+		{"too short", []byte("REDIS"), "too short"},
+		{"old version without checksum", []byte("REDIS0004\xfe\x00\xff"), ""},
+		{"old version truncated", []byte("REDIS0004\xfe\x00"), "EOF opcode"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateRDB(writeRDB(t, tt.data))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("validateRDB: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("validateRDB error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// chunkedReader returns the data in reads of the given sizes
+type chunkedReader struct {
+	data  []byte
+	sizes []int
+}
+
+func (r *chunkedReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	n := len(r.data)
+	if len(r.sizes) > 0 {
+		n = min(n, r.sizes[0])
+		r.sizes = r.sizes[1:]
+	}
+	n = copy(p, r.data[:n])
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestCopyUntilMark(t *testing.T) {
+	mark := []byte(strings.Repeat("0123456789", 4))
+	stream := append(append(append([]byte{}, rdbFixture...), mark...), "*1\r\n$4\r\nPING\r\n"...)
+	markAt := len(rdbFixture)
+
+	tests := []struct {
+		name  string
+		sizes []int
+	}{
+		{"one read", nil},
+		{"mark split across reads", []int{markAt + 13, 5, 1000}},
+		{"byte at a time", repeatSizes(1, len(stream))},
+		{"mark starts a read", []int{markAt, 1000}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			n, err := copyUntilMark(&out, &chunkedReader{data: stream, sizes: tt.sizes}, mark, nil)
+			if err != nil {
+				t.Fatalf("copyUntilMark: %v", err)
+			}
+			if n != int64(len(rdbFixture)) || !bytes.Equal(out.Bytes(), rdbFixture) {
+				t.Fatalf("copied %d bytes %x, want the fixture", n, out.Bytes())
+			}
+		})
+	}
+
+	t.Run("missing mark", func(t *testing.T) {
+		_, err := copyUntilMark(io.Discard, bytes.NewReader(rdbFixture), mark, nil)
+		if err == nil {
+			t.Fatal("copyUntilMark succeeded without the mark")
+		}
+	})
+}
+
+func repeatSizes(size, n int) []int {
+	sizes := make([]int, n)
+	for i := range sizes {
+		sizes[i] = size
+	}
+	return sizes
+}
+
+// fakeMaster answers AUTH, REPLCONF and PSYNC like a Redis master, sends
+// payload, the RDB transfer as written after the FULLRESYNC reply, and closes
+// the connection
+func fakeMaster(t *testing.T, payload []byte) (string, string) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			args, err := readCommand(r)
+			if err != nil {
+				return
+			}
+			switch strings.ToUpper(args[0]) {
+			case "AUTH":
+				if args[len(args)-1] != "secret" {
+					fmt.Fprint(conn, "-WRONGPASS invalid password\r\n")
+					continue
+				}
+				fmt.Fprint(conn, "+OK\r\n")
+			case "REPLCONF":
+				fmt.Fprint(conn, "+OK\r\n")
+			case "PSYNC":
+				fmt.Fprint(conn, "+FULLRESYNC 8de1787ba490483314a4d30f1c628bc5025eb761 0\r\n\n\n")
+				// Write in pieces so the reader sees partial payloads
+				for len(payload) > 0 {
+					n := min(len(payload), 7)
+					if _, err := conn.Write(payload[:n]); err != nil {
+						return
+					}
+					payload = payload[n:]
+				}
+				return
+			}
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	return host, port
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(line[1:]))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+func TestDumpRedisNodeNative(t *testing.T) {
+	mark := strings.Repeat("a1b2c3d4e5", 4)
+	tests := []struct {
+		name    string
+		payload string
+		wantErr string
+	}{
+		{"length prefixed", fmt.Sprintf("$%d\r\n%s*1\r\n$4\r\nPING\r\n", len(rdbFixture), rdbFixture), ""},
+		{"eof mark", "$EOF:" + mark + "\r\n" + string(rdbFixture) + mark + "*1\r\n$4\r\nPING\r\n", ""},
+		{"length truncated", fmt.Sprintf("$%d\r\n%s", len(rdbFixture)+10, rdbFixture), "truncated"},
+		{"eof mark missing", "$EOF:" + mark + "\r\n" + string(rdbFixture), "failed to read RDB payload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			host, port := fakeMaster(t, []byte(tt.payload))
+			filename := filepath.Join(t.TempDir(), "dump.rdb")
+			req := model.BackupRequest{Type: model.Redis, Password: "secret"}
+
+			err := dumpRedisNodeNative(context.Background(), req, host, port, filename)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("dumpRedisNodeNative error = %v, want %q", err, tt.wantErr)
+				}
+				if _, statErr := os.Stat(filename); !os.IsNotExist(statErr) {
+					t.Fatalf("partial dump was left behind")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("dumpRedisNodeNative: %v", err)
+			}
+
+			data, err := os.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(data, rdbFixture) {
+				t.Fatalf("dump = %x, want the fixture", data)
+			}
+			if err := validateRDB(filename); err != nil {
+				t.Fatalf("validateRDB: %v", err)
+			}
+		})
+	}
+
+	t.Run("wrong password", func(t *testing.T) {
+		host, port := fakeMaster(t, nil)
+		req := model.BackupRequest{Type: model.Redis, Password: "wrong"}
+		err := dumpRedisNodeNative(context.Background(), req, host, port, filepath.Join(t.TempDir(), "dump.rdb"))
+		if err == nil || !strings.Contains(err.Error(), "authentication failed") {
+			t.Fatalf("dumpRedisNodeNative error = %v, want authentication failure", err)
+		}
+	})
+}
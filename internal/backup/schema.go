@@ -0,0 +1,493 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"context"
+	"db-backup/internal/model"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ErrNoSchema is returned when the schema cannot be read from a backup
+var ErrNoSchema = errors.New("schema cannot be read from this backup")
+
+// dbSchema holds the tables or collections read from one backup
+type dbSchema struct {
+	tables map[string]*tableSchema
+}
+
+// tableSchema maps the columns, indexes and constraints of a table to their
+// definitions, with whitespace collapsed
+type tableSchema struct {
+	database    string
+	schema      string
+	name        string
+	kind        string
+	columns     map[string]string
+	indexes     map[string]string
+	constraints map[string]string
+}
+
+func (s *dbSchema) table(database, schema, name, kind string) *tableSchema {
+	key := database + "\x00" + schema + "\x00" + name
+	if table, ok := s.tables[key]; ok {
+		return table
+	}
+	table := &tableSchema{
+		database:    database,
+		schema:      schema,
+		name:        name,
+		kind:        kind,
+		columns:     make(map[string]string),
+		indexes:     make(map[string]string),
+		constraints: make(map[string]string),
+	}
+	s.tables[key] = table
+	return table
+}
+
+// DiffSchema compares the tables, columns, indexes and constraints of two
+// Postgres or MySQL backups, or the collections and indexes of two MongoDB
+// backups
+func DiffSchema(ctx context.Context, t model.BackupType, fromPath, toPath string) (*model.SchemaDiff, error) {
+	from, err := readSchema(ctx, t, fromPath)
+	if err != nil {
+		return nil, fmt.Errorf("from backup: %w", err)
+	}
+	to, err := readSchema(ctx, t, toPath)
+	if err != nil {
+		return nil, fmt.Errorf("to backup: %w", err)
+	}
+
+	diff := &model.SchemaDiff{Type: string(t), Tables: []model.TableChange{}}
+	switch {
+	case len(from.tables) == 0:
+		diff.Warning = "the from backup holds no tables or collections"
+	case len(to.tables) == 0:
+		diff.Warning = "the to backup holds no tables or collections"
+	}
+
+	keys := make(map[string]bool)
+	for key := range from.tables {
+		keys[key] = true
+	}
+	for key := range to.tables {
+		keys[key] = true
+	}
+	sorted := make([]string, 0, len(keys))
+	for key := range keys {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+
+	for _, key := range sorted {
+		before, after := from.tables[key], to.tables[key]
+		switch {
+		case before == nil:
+			diff.Added++
+			diff.Tables = append(diff.Tables, tableChange(after, model.ChangeAdded))
+		case after == nil:
+			diff.Removed++
+			diff.Tables = append(diff.Tables, tableChange(before, model.ChangeRemoved))
+		default:
+			change := tableChange(after, model.ChangeChanged)
+			change.Columns = diffDefinitions(before.columns, after.columns)
+			change.Indexes = diffDefinitions(before.indexes, after.indexes)
+			change.Constraints = diffDefinitions(before.constraints, after.constraints)
+			if len(change.Columns)+len(change.Indexes)+len(change.Constraints) > 0 || before.kind != after.kind {
+				diff.Changed++
+				diff.Tables = append(diff.Tables, change)
+			}
+		}
+	}
+
+	return diff, nil
+}
+
+func tableChange(table *tableSchema, change model.ChangeType) model.TableChange {
+	return model.TableChange{
+		Database: table.database,
+		Schema:   table.schema,
+		Name:     table.name,
+		Kind:     table.kind,
+		Change:   change,
+	}
+}
+
+// diffDefinitions lists the names added, removed or defined differently,
+// sorted by name
+func diffDefinitions(before, after map[string]string) []model.SchemaChange {
+	var changes []model.SchemaChange
+	for name, definition := range after {
+		previous, ok := before[name]
+		switch {
+		case !ok:
+			changes = append(changes, model.SchemaChange{Name: name, Change: model.ChangeAdded, To: definition})
+		case previous != definition:
+			changes = append(changes, model.SchemaChange{Name: name, Change: model.ChangeChanged, From: previous, To: definition})
+		}
+	}
+	for name, definition := range before {
+		if _, ok := after[name]; !ok {
+			changes = append(changes, model.SchemaChange{Name: name, Change: model.ChangeRemoved, From: definition})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
+
+// readSchema reads the schema of a backup artifact
+func readSchema(ctx context.Context, t model.BackupType, filePath string) (*dbSchema, error) {
+	artifact, err := openArtifact(filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer artifact.Close()
+
+	schema := &dbSchema{tables: make(map[string]*tableSchema)}
+	switch {
+	case artifact.format == formatSQL && t != model.Mongo:
+		err = sqlSchema(ctx, artifact.r, t == model.MySQL || t == model.MariaDB, schema)
+	case artifact.format == formatPgCustom:
+		err = pgRestoreSchema(ctx, filePath, schema)
+	case artifact.format == formatMongoArchive:
+		err = mongoArchiveSchema(artifact.r, schema)
+	case artifact.format == formatTar && t == model.Mongo:
+		err = mongoTarSchema(ctx, artifact.r, schema)
+	default:
+		return nil, fmt.Errorf("%w: %s artifact", ErrNoSchema, artifact.format)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return schema, nil
+}
+
+var (
+	sqlAlterTable    = regexp.MustCompile(`(?i)^ALTER\s+TABLE\s+(?:IF\s+EXISTS\s+)?(?:ONLY\s+)?` + sqlName + `\s+(.*?);?$`)
+	sqlAddConstraint = regexp.MustCompile(`(?i)^ADD\s+CONSTRAINT\s+(.*)$`)
+	sqlAlterColumn   = regexp.MustCompile(`(?i)^ALTER\s+COLUMN\s+(\S+)\s+((?:SET\s+DEFAULT|ADD\s+GENERATED)\s+.*)$`)
+	sqlCreateIndex   = regexp.MustCompile(`(?i)^CREATE\s+(UNIQUE\s+)?INDEX\s+(?:CONCURRENTLY\s+)?(?:IF\s+NOT\s+EXISTS\s+)?(\S+)\s+ON\s+(?:ONLY\s+)?` + sqlName + `\s*(.*?);?$`)
+)
+
+// sqlSchema reads CREATE TABLE, ALTER TABLE and CREATE INDEX statements from
+// a plain SQL dump. pg_dump adds primary keys, foreign keys and column
+// defaults with ALTER TABLE; mysqldump keeps them in CREATE TABLE.
+func sqlSchema(ctx context.Context, r *bufio.Reader, backslash bool, schema *dbSchema) error {
+	var database string
+	var statement []byte
+	var copying bool
+
+	for lineNo := 0; ; lineNo++ {
+		if lineNo%10000 == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			switch {
+			case copying:
+				copying = !bytes.Equal(bytes.TrimRight(line, "\r\n"), []byte(`\.`))
+			case statement != nil:
+				statement = append(statement, line...)
+				if sqlStatementEnd(statement, backslash) {
+					schema.statement(database, statement, backslash)
+					statement = nil
+				}
+			default:
+				if switched, ok := sqlSwitchDatabase(line); ok {
+					database = switched
+				} else if bytes.HasPrefix(line, []byte("COPY ")) {
+					copying = sqlCopy.Match(line)
+				} else if isSchemaStatement(line) {
+					// Data statements are only followed to their end
+					if sqlStatementEnd(line, backslash) {
+						schema.statement(database, line, backslash)
+					} else {
+						statement = append([]byte{}, line...)
+					}
+				}
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read dump: %w", err)
+		}
+	}
+}
+
+func isSchemaStatement(line []byte) bool {
+	for _, prefix := range []string{"CREATE ", "ALTER ", "INSERT ", "create ", "alter ", "insert "} {
+		if bytes.HasPrefix(line, []byte(prefix)) {
+			return true
+		}
+	}
+	return false
+}
+
+// statement records a complete CREATE TABLE, ALTER TABLE or CREATE INDEX
+// statement; anything else is ignored. backslash marks a MySQL dump.
+func (s *dbSchema) statement(database string, statement []byte, backslash bool) {
+	if len(statement) >= 6 && strings.EqualFold(string(statement[:6]), "INSERT") {
+		return
+	}
+
+	if m := sqlCreateTable.FindSubmatchIndex(statement); m != nil {
+		schemaName, name := splitSQLName(string(statement[m[2]:m[3]]))
+		table := s.table(database, schemaName, name, "table")
+
+		body := statement[m[3]:]
+		start := bytes.IndexByte(body, '(')
+		if start < 0 {
+			return
+		}
+		end := closingParen(body, start, backslash)
+		if end < 0 {
+			return
+		}
+		for _, definition := range splitSQLList(string(body[start+1:end]), backslash) {
+			if definition = collapseSpace(definition); definition != "" {
+				table.definition(definition, backslash)
+			}
+		}
+		return
+	}
+
+	normalized := collapseSpace(string(statement))
+	if m := sqlCreateIndex.FindStringSubmatch(normalized); m != nil {
+		schemaName, name := splitSQLName(m[3])
+		_, index := splitSQLName(m[2])
+		definition := m[4]
+		if m[1] != "" {
+			definition = "UNIQUE " + definition
+		}
+		s.table(database, schemaName, name, "table").indexes[index] = definition
+		return
+	}
+
+	if m := sqlAlterTable.FindStringSubmatch(normalized); m != nil {
+		schemaName, name := splitSQLName(m[1])
+		if c := sqlAddConstraint.FindStringSubmatch(m[2]); c != nil {
+			constraint, definition := cutIdentifier(c[1])
+			s.table(database, schemaName, name, "table").constraints[constraint] = definition
+		} else if c := sqlAlterColumn.FindStringSubmatch(m[2]); c != nil {
+			table := s.table(database, schemaName, name, "table")
+			_, column := splitSQLName(c[1])
+			table.columns[column] = strings.TrimSpace(table.columns[column] + " " + c[2])
+		}
+	}
+}
+
+// sqlIndexKeywords start the index definitions in a MySQL CREATE TABLE
+var sqlIndexKeywords = []string{"UNIQUE KEY ", "UNIQUE INDEX ", "FULLTEXT KEY ", "FULLTEXT INDEX ", "SPATIAL KEY ", "SPATIAL INDEX ", "KEY ", "INDEX "}
+
+// definition records one column, index or constraint of a CREATE TABLE body.
+// Only MySQL declares indexes inside CREATE TABLE.
+func (t *tableSchema) definition(definition string, mysql bool) {
+	upper := strings.ToUpper(definition)
+
+	if mysql {
+		for _, keyword := range sqlIndexKeywords {
+			if strings.HasPrefix(upper, keyword) {
+				name, columns := cutIdentifier(definition[len(keyword):])
+				prefix := strings.TrimSuffix(strings.TrimSuffix(keyword, "KEY "), "INDEX ")
+				t.indexes[name] = prefix + columns
+				return
+			}
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(upper, "CONSTRAINT "):
+		name, rest := cutIdentifier(definition[len("CONSTRAINT "):])
+		t.constraints[name] = rest
+	case strings.HasPrefix(upper, "PRIMARY KEY"):
+		t.constraints["PRIMARY KEY"] = definition
+	case strings.HasPrefix(upper, "UNIQUE"), strings.HasPrefix(upper, "CHECK"),
+		strings.HasPrefix(upper, "FOREIGN KEY"), strings.HasPrefix(upper, "EXCLUDE"):
+		// Unnamed constraints are keyed by their definition
+		t.constraints[definition] = definition
+	case strings.HasPrefix(upper, "LIKE "), strings.HasPrefix(upper, "PERIOD "):
+	default:
+		name, rest := cutIdentifier(definition)
+		t.columns[name] = rest
+	}
+}
+
+// cutIdentifier splits a leading, possibly quoted identifier from the rest
+func cutIdentifier(s string) (string, string) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", ""
+	}
+	if quote := s[0]; quote == '"' || quote == '`' {
+		if end := strings.IndexByte(s[1:], quote); end >= 0 {
+			return s[1 : end+1], strings.TrimSpace(s[end+2:])
+		}
+	}
+	name, rest, _ := strings.Cut(s, " ")
+	return name, strings.TrimSpace(rest)
+}
+
+func collapseSpace(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// pgRestoreSchema converts the schema of a pg_dump custom-format archive to
+// SQL with pg_restore -s and reads it like a plain dump
+func pgRestoreSchema(ctx context.Context, filePath string, schema *dbSchema) error {
+	cmd := exec.CommandContext(ctx, resolveExecutable("pg_restore"), "-s", "-f", "-", filePath)
+	output, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("pg_restore -s failed: %w", err)
+	}
+	return sqlSchema(ctx, bufio.NewReader(bytes.NewReader(output)), false, schema)
+}
+
+// mongoArchiveSchema reads the collections and indexes from the prelude of
+// a mongodump archive, which carries each collection's metadata.json
+func mongoArchiveSchema(r *bufio.Reader, schema *dbSchema) error {
+	if _, err := r.Discard(len(mongoArchiveMagic)); err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+	if _, err := readBSONDocument(r); err != nil {
+		return fmt.Errorf("failed to read archive: %w", err)
+	}
+
+	for {
+		doc, err := readBSONDocument(r)
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+		if doc == nil {
+			return nil
+		}
+
+		var collection struct {
+			Database   string `bson:"db"`
+			Collection string `bson:"collection"`
+			Metadata   string `bson:"metadata"`
+			Type       string `bson:"type"`
+		}
+		if err := bson.Unmarshal(doc, &collection); err != nil {
+			return fmt.Errorf("invalid collection metadata: %w", err)
+		}
+		table := schema.table(collection.Database, "", collection.Collection, mongoKind(collection.Type))
+		if err := table.mongoMetadata([]byte(collection.Metadata)); err != nil {
+			return fmt.Errorf("%s.%s: %w", collection.Database, collection.Collection, err)
+		}
+	}
+}
+
+// mongoTarSchema reads the dump/<db>/<collection>.metadata.json files of a
+// native MongoDB dump
+func mongoTarSchema(ctx context.Context, r io.Reader, schema *dbSchema) error {
+	tr := tar.NewReader(r)
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		header, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %w", err)
+		}
+
+		name, ok := strings.CutPrefix(strings.TrimPrefix(header.Name, "./"), "dump/")
+		if !ok {
+			continue
+		}
+		parts := strings.SplitN(name, "/", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		collection, ok := strings.CutSuffix(parts[1], ".metadata.json")
+		if !ok {
+			continue
+		}
+
+		data, err := io.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("%s: %w", header.Name, err)
+		}
+		var metadata struct {
+			Type string `bson:"type"`
+		}
+		bson.UnmarshalExtJSON(data, false, &metadata)
+		table := schema.table(parts[0], "", collection, mongoKind(metadata.Type))
+		if err := table.mongoMetadata(data); err != nil {
+			return fmt.Errorf("%s: %w", header.Name, err)
+		}
+	}
+}
+
+func mongoKind(collectionType string) string {
+	if collectionType == "view" {
+		return "view"
+	}
+	return "collection"
+}
+
+// mongoMetadata records the indexes and validator of a collection from its
+// metadata.json. Index definitions leave out the name and version.
+func (t *tableSchema) mongoMetadata(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	var metadata struct {
+		Indexes []bson.D `bson:"indexes"`
+		Options bson.D   `bson:"options"`
+	}
+	if err := bson.UnmarshalExtJSON(data, false, &metadata); err != nil {
+		return fmt.Errorf("invalid metadata: %w", err)
+	}
+
+	for _, index := range metadata.Indexes {
+		var name string
+		var spec bson.D
+		for _, element := range index {
+			switch element.Key {
+			case "name":
+				name, _ = element.Value.(string)
+			case "v", "ns":
+			default:
+				spec = append(spec, element)
+			}
+		}
+		definition, err := bson.MarshalExtJSON(spec, false, false)
+		if err != nil {
+			return fmt.Errorf("invalid index %s: %w", name, err)
+		}
+		t.indexes[name] = string(definition)
+	}
+
+	for _, option := range metadata.Options {
+		if option.Key != "validator" {
+			continue
+		}
+		definition, err := bson.MarshalExtJSON(bson.D{option}, false, false)
+		if err != nil {
+			return fmt.Errorf("invalid validator: %w", err)
+		}
+		t.constraints["validator"] = string(definition)
+	}
+
+	return nil
+}
@@ -0,0 +1,118 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"db-backup/internal/model"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type SQLiteBackup struct{}
+
+func (b *SQLiteBackup) Backup(ctx context.Context, req model.BackupRequest) (string, error) {
+	if req.Scope.OrDefault() == model.ScopeServer {
+		return "", fmt.Errorf("server backups are not supported for %s", req.Type)
+	}
+
+	// The database field holds the path of the SQLite file, local or on a
+	// mounted volume
+	source := req.Database
+	if source == "" {
+		return "", fmt.Errorf("sqlite backup requires the database file path")
+	}
+	if _, err := os.Stat(source); err != nil {
+		return "", fmt.Errorf("failed to access database file: %w", err)
+	}
+
+	// Name the backup after the file rather than its full path
+	named := req
+	named.Database = strings.TrimSuffix(filepath.Base(source), filepath.Ext(source))
+	if named.Host == "" {
+		named.Host = "local"
+	}
+
+	binPath := resolveExecutable("sqlite3")
+
+	switch req.Mode.OrDefault() {
+	case model.ModeSchema:
+		filename := generateFilename(named, "sql")
+		outfile, err := os.Create(filename)
+		if err != nil {
+			return "", fmt.Errorf("failed to create backup file: %w", err)
+		}
+		defer outfile.Close()
+
+		cmd := exec.CommandContext(ctx, binPath, source, ".schema")
+		var stderr bytes.Buffer
+		cmd.Stdout = outfile
+		cmd.Stderr = &stderr
+
+		if err := cmd.Run(); err != nil {
+			return "", fmt.Errorf("sqlite3 failed: %s, output: %s", err, stderr.String())
+		}
+
+		return filename, nil
+	case model.ModeData:
+		return "", unsupportedModeError(req)
+	}
+
+	filename := generateFilename(named, "db")
+
+	// .backup uses the SQLite online backup API, which produces a consistent
+	// copy while other connections keep writing. The busy timeout lets it wait
+	// out short write locks instead of failing.
+	cmd := exec.CommandContext(ctx, binPath,
+		"-bail",
+		"-cmd", ".timeout 30000",
+		source,
+		fmt.Sprintf(".backup '%s'", filename),
+	)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(filename)
+		return "", fmt.Errorf("sqlite3 failed: %s, output: %s", err, string(output))
+	}
+
+	// Make sure the copy is a readable database before it is uploaded
+	check := exec.CommandContext(ctx, binPath, filename, "PRAGMA quick_check")
+	output, err := check.CombinedOutput()
+	if err != nil || strings.TrimSpace(string(output)) != "ok" {
+		os.Remove(filename)
+		return "", fmt.Errorf("backup integrity check failed: %v, output: %s", err, string(output))
+	}
+
+	return filename, nil
+}
+
+// TestConnection opens the file read-only and reports the SQLite library
+// version. Reading the file is all a backup needs.
+func (b *SQLiteBackup) TestConnection(ctx context.Context, req model.BackupRequest) (*model.ConnectionTestResult, error) {
+	if req.Database == "" {
+		return nil, fmt.Errorf("sqlite backup requires the database file path")
+	}
+	if _, err := os.Stat(req.Database); err != nil {
+		return nil, fmt.Errorf("failed to access database file: %w", err)
+	}
+
+	start := time.Now()
+	cmd := exec.CommandContext(ctx, resolveExecutable("sqlite3"), "-readonly", req.Database,
+		// Reading the schema fails on files that are not databases
+		"SELECT count(*) FROM sqlite_master",
+		"SELECT sqlite_version()")
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("sqlite3 failed: %s, output: %s", err, string(output))
+	}
+
+	lines := splitLines(output)
+	result := &model.ConnectionTestResult{LatencyMs: time.Since(start).Milliseconds()}
+	if len(lines) > 0 {
+		result.ServerVersion = lines[len(lines)-1]
+	}
+
+	return result, nil
+}
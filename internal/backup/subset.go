@@ -0,0 +1,993 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"db-backup/internal/model"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// subsetBatch is the number of keys matched by one query
+const subsetBatch = 1000
+
+// ValidateSubsetOptions checks the request of a subset dump
+func ValidateSubsetOptions(req model.BackupRequest) error {
+	switch req.Type {
+	case model.Postgres, model.MySQL, model.MariaDB:
+	default:
+		return fmt.Errorf("subset dumps are only supported for %s, %s and %s", model.Postgres, model.MySQL, model.MariaDB)
+	}
+	if req.Scope.OrDefault() != model.ScopeDatabase || req.Physical {
+		return fmt.Errorf("subset dumps cover a single database with a logical dump")
+	}
+
+	cfg := req.Subset
+	if cfg == nil || cfg.Table == "" {
+		return fmt.Errorf("subset dumps require subset.table")
+	}
+	if cfg.Percent < 0 || cfg.Percent > 100 {
+		return fmt.Errorf("subset.percent must be between 0 and 100")
+	}
+	if cfg.Limit < 0 {
+		return fmt.Errorf("subset.limit must not be negative")
+	}
+	if cfg.Percent == 0 && cfg.Where == "" && cfg.Limit == 0 {
+		return fmt.Errorf("subset dumps require subset.percent, subset.where or subset.limit")
+	}
+	if strings.Contains(cfg.Where, ";") {
+		return fmt.Errorf("subset.where must be a single condition")
+	}
+	return nil
+}
+
+// foreignKey references the columns of refTable from the columns of table
+type foreignKey struct {
+	table      string
+	columns    []string
+	refTable   string
+	refColumns []string
+}
+
+// subsetTable is a table with the rows selected for a subset dump, tracked
+// by the SQL literals of their primary key
+type subsetTable struct {
+	name         string
+	ident        string
+	columns      []string
+	types        map[string]string
+	key          []string
+	refs         []foreignKey
+	referencedBy []foreignKey
+
+	rows map[string][]string
+	// down and up hold the keys whose references are still to be followed
+	down [][]string
+	up   [][]string
+}
+
+// add records the selected rows and queues the new ones. Rows reached from
+// the root follow foreign keys in both directions, rows reached as parents
+// only follow them upwards.
+func (t *subsetTable) add(keys [][]string, down bool) {
+	for _, key := range keys {
+		id := strings.Join(key, ",")
+		if _, ok := t.rows[id]; ok {
+			continue
+		}
+		t.rows[id] = key
+		t.up = append(t.up, key)
+		if down {
+			t.down = append(t.down, key)
+		}
+	}
+}
+
+// selectedKeys returns the selected keys in a stable order
+func (t *subsetTable) selectedKeys() [][]string {
+	ids := make([]string, 0, len(t.rows))
+	for id := range t.rows {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	keys := make([][]string, len(ids))
+	for i, id := range ids {
+		keys[i] = t.rows[id]
+	}
+	return keys
+}
+
+// subsetDialect holds what differs between Postgres and MySQL when selecting
+// the rows of a subset
+type subsetDialect interface {
+	quote(ident string) string
+	// literal returns an expression rendering a column as an SQL literal
+	literal(t *subsetTable, column string) string
+	random() string
+	// literals runs a query whose columns are literal expressions
+	literals(ctx context.Context, query string) ([][]string, error)
+}
+
+// subsetSession is one client process that runs every query of a subset
+// dump in a single transaction, so the catalog, the selection and the rows
+// all come from one snapshot. Each statement is followed by one printing a
+// random mark, and its output is read up to that line.
+type subsetSession struct {
+	name   string
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	stderr bytes.Buffer
+	mark   string
+	// markQuery prints the mark when given it
+	markQuery string
+	err       error
+}
+
+// startSubsetSession starts a client reading statements from stdin
+func startSubsetSession(cmd *exec.Cmd, name, markQuery string) (*subsetSession, error) {
+	mark := make([]byte, 16)
+	if _, err := rand.Read(mark); err != nil {
+		return nil, fmt.Errorf("failed to generate session mark: %w", err)
+	}
+	s := &subsetSession{name: name, cmd: cmd, mark: "subset-" + hex.EncodeToString(mark), markQuery: markQuery}
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", name, err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", name, err)
+	}
+	cmd.Stderr = &s.stderr
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", name, err)
+	}
+	s.stdin = stdin
+	s.stdout = bufio.NewReader(stdout)
+	return s, nil
+}
+
+// run executes a statement and copies its output to w. The client stops at
+// the first error, which ends the session.
+func (s *subsetSession) run(statement string, w io.Writer) error {
+	if s.err != nil {
+		return s.err
+	}
+	if _, err := io.WriteString(s.stdin, statement+"\n"+fmt.Sprintf(s.markQuery, s.mark)+"\n"); err != nil {
+		return s.fail(err)
+	}
+	// partial is set while the rest of a line longer than the buffer is read
+	partial := false
+	for {
+		line, err := s.stdout.ReadSlice('\n')
+		if err != nil && err != bufio.ErrBufferFull {
+			return s.fail(err)
+		}
+		if !partial && err == nil && string(line) == s.mark+"\n" {
+			return nil
+		}
+		partial = err == bufio.ErrBufferFull
+		if _, err := w.Write(line); err != nil {
+			return err
+		}
+	}
+}
+
+// fail ends the session after an error and reports the client output
+func (s *subsetSession) fail(err error) error {
+	s.stdin.Close()
+	if waitErr := s.cmd.Wait(); waitErr != nil {
+		err = waitErr
+	}
+	s.err = fmt.Errorf("%s failed: %s, output: %s", s.name, err, s.stderr.String())
+	return s.err
+}
+
+// close ends the read-only transaction and the client
+func (s *subsetSession) close() {
+	if s.err != nil {
+		return
+	}
+	s.stdin.Close()
+	s.cmd.Wait()
+	s.err = fmt.Errorf("%s session closed", s.name)
+}
+
+// subsetSchema is the catalog of a database as seen by a subset dump
+type subsetSchema struct {
+	tables map[string]*subsetTable
+	// skipped lists the tables that rows were not selected from
+	skipped []string
+}
+
+func newSubsetSchema() *subsetSchema {
+	return &subsetSchema{tables: map[string]*subsetTable{}}
+}
+
+// table returns the table with the given name, adding it when missing
+func (s *subsetSchema) table(d subsetDialect, schema, name string) *subsetTable {
+	qualified := name
+	ident := d.quote(name)
+	if schema != "" {
+		qualified = schema + "." + name
+		ident = d.quote(schema) + "." + ident
+	}
+	t, ok := s.tables[qualified]
+	if !ok {
+		t = &subsetTable{name: qualified, ident: ident, types: map[string]string{}, rows: map[string][]string{}}
+		s.tables[qualified] = t
+	}
+	return t
+}
+
+// link adds a foreign key to both of its tables. Keys involving tables
+// outside the catalog, e.g. partitions, are ignored.
+func (s *subsetSchema) link(fk foreignKey) {
+	table, parent := s.tables[fk.table], s.tables[fk.refTable]
+	if table == nil || parent == nil {
+		return
+	}
+	table.refs = append(table.refs, fk)
+	parent.referencedBy = append(parent.referencedBy, fk)
+}
+
+// find returns the root table. Postgres names without a schema prefer the
+// public schema, then any schema with a single table of that name.
+func (s *subsetSchema) find(name string) (*subsetTable, error) {
+	if t, ok := s.tables[name]; ok {
+		return t, nil
+	}
+	if t, ok := s.tables["public."+name]; ok {
+		return t, nil
+	}
+
+	var found *subsetTable
+	for qualified, t := range s.tables {
+		if _, table, ok := strings.Cut(qualified, "."); ok && table == name {
+			if found != nil {
+				return nil, fmt.Errorf("table %s exists in several schemas; qualify it with the schema", name)
+			}
+			found = t
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("table %s not found", name)
+	}
+	return found, nil
+}
+
+// sorted returns the tables ordered by name
+func (s *subsetSchema) sorted() []*subsetTable {
+	tables := make([]*subsetTable, 0, len(s.tables))
+	for _, t := range s.tables {
+		tables = append(tables, t)
+	}
+	sort.Slice(tables, func(i, j int) bool { return tables[i].name < tables[j].name })
+	return tables
+}
+
+// selectRows samples the root rows, adds every row that references them
+// through foreign keys and finally every row the selected rows reference, so
+// the subset restores with its constraints intact
+func (s *subsetSchema) selectRows(ctx context.Context, d subsetDialect, cfg *model.SubsetOptions) error {
+	root, err := s.find(cfg.Table)
+	if err != nil {
+		return err
+	}
+	if len(root.key) == 0 {
+		return fmt.Errorf("table %s has no primary key", root.name)
+	}
+
+	var conditions []string
+	if cfg.Percent > 0 && cfg.Percent < 100 {
+		conditions = append(conditions, fmt.Sprintf("%s < %g", d.random(), cfg.Percent/100))
+	}
+	if cfg.Where != "" {
+		conditions = append(conditions, "("+cfg.Where+")")
+	}
+	query := "SELECT " + literalList(d, root, root.key) + " FROM " + root.ident
+	if len(conditions) > 0 {
+		query += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	if cfg.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", cfg.Limit)
+	}
+	keys, err := d.literals(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to select rows of %s: %w", root.name, err)
+	}
+	root.add(keys, true)
+
+	skipped := map[string]bool{}
+	skip := func(t *subsetTable) {
+		if !skipped[t.name] {
+			skipped[t.name] = true
+			s.skipped = append(s.skipped, t.name)
+			log.Printf("Subset skips table %s, which has no primary key", t.name)
+		}
+	}
+
+	// Follow references down from the root rows, then up from every row
+	for _, down := range []bool{true, false} {
+		for pending := true; pending; {
+			pending = false
+			for _, t := range s.sorted() {
+				queue := &t.up
+				fks := t.refs
+				if down {
+					queue = &t.down
+					fks = t.referencedBy
+				}
+				if len(*queue) == 0 {
+					continue
+				}
+				keys := *queue
+				*queue = nil
+				pending = true
+
+				for _, fk := range fks {
+					next, columns, from := s.tables[fk.table], fk.columns, fk.refColumns
+					if !down {
+						next, columns, from = s.tables[fk.refTable], fk.refColumns, fk.columns
+					}
+					if len(next.key) == 0 {
+						skip(next)
+						continue
+					}
+					if err := s.follow(ctx, d, t, keys, from, next, columns, down); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// follow selects the rows of next whose columns match the from columns of
+// the given rows of t
+func (s *subsetSchema) follow(ctx context.Context, d subsetDialect, t *subsetTable, keys [][]string, from []string, next *subsetTable, columns []string, down bool) error {
+	for start := 0; start < len(keys); start += subsetBatch {
+		batch := keys[start:min(start+subsetBatch, len(keys))]
+
+		values := batch
+		if !sameColumns(from, t.key) {
+			var err error
+			values, err = d.literals(ctx, "SELECT DISTINCT "+literalList(d, t, from)+" FROM "+t.ident+" WHERE "+matchKeys(d, t.key, batch))
+			if err != nil {
+				return fmt.Errorf("failed to read references of %s: %w", t.name, err)
+			}
+			values = withoutNulls(values)
+			if len(values) == 0 {
+				continue
+			}
+		}
+
+		for i := 0; i < len(values); i += subsetBatch {
+			part := values[i:min(i+subsetBatch, len(values))]
+			rows, err := d.literals(ctx, "SELECT "+literalList(d, next, next.key)+" FROM "+next.ident+" WHERE "+matchKeys(d, columns, part))
+			if err != nil {
+				return fmt.Errorf("failed to select rows of %s: %w", next.name, err)
+			}
+			next.add(rows, down)
+		}
+	}
+	return nil
+}
+
+func sameColumns(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// withoutNulls drops the references with a NULL column, which match no row
+func withoutNulls(values [][]string) [][]string {
+	kept := values[:0]
+	for _, value := range values {
+		null := false
+		for _, literal := range value {
+			if literal == "NULL" {
+				null = true
+			}
+		}
+		if !null {
+			kept = append(kept, value)
+		}
+	}
+	return kept
+}
+
+// literalList renders columns as a select list of literal expressions
+func literalList(d subsetDialect, t *subsetTable, columns []string) string {
+	list := make([]string, len(columns))
+	for i, column := range columns {
+		list[i] = d.literal(t, column)
+	}
+	return strings.Join(list, ", ")
+}
+
+// matchKeys returns a condition matching the columns against the literals
+func matchKeys(d subsetDialect, columns []string, keys [][]string) string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = d.quote(column)
+	}
+
+	tuples := make([]string, len(keys))
+	for i, key := range keys {
+		tuples[i] = strings.Join(key, ", ")
+		if len(key) > 1 {
+			tuples[i] = "(" + tuples[i] + ")"
+		}
+	}
+
+	if len(columns) == 1 {
+		return quoted[0] + " IN (" + strings.Join(tuples, ", ") + ")"
+	}
+	return "(" + strings.Join(quoted, ", ") + ") IN (" + strings.Join(tuples, ", ") + ")"
+}
+
+// subsetHeader describes the subset at the top of the dump
+func subsetHeader(w io.Writer, req model.BackupRequest, s *subsetSchema) {
+	cfg := req.Subset
+	fmt.Fprintf(w, "--\n-- Subset of %s starting from %s", req.Database, cfg.Table)
+	if cfg.Percent > 0 {
+		fmt.Fprintf(w, ", %g%% sampled", cfg.Percent)
+	}
+	if cfg.Where != "" {
+		fmt.Fprintf(w, ", where %s", strings.ReplaceAll(cfg.Where, "\n", " "))
+	}
+	if cfg.Limit > 0 {
+		fmt.Fprintf(w, ", at most %d rows", cfg.Limit)
+	}
+	fmt.Fprintf(w, "\n")
+	for _, t := range s.sorted() {
+		if len(t.rows) > 0 {
+			fmt.Fprintf(w, "-- %s: %d rows\n", t.name, len(t.rows))
+		}
+	}
+	if len(s.skipped) > 0 {
+		fmt.Fprintf(w, "-- Left empty, no primary key: %s\n", strings.Join(s.skipped, ", "))
+	}
+	if req.Type == model.Postgres {
+		fmt.Fprintf(w, "-- Schema and rows read from one snapshot\n")
+	} else {
+		fmt.Fprintf(w, "-- Rows read from one snapshot; table definitions and triggers read separately\n")
+	}
+	fmt.Fprintf(w, "--\n\n")
+}
+
+// postgresSubset dumps the schema with pg_dump and the selected rows as COPY
+// blocks in between the table definitions and the constraints, so rows load
+// in any order
+func postgresSubset(ctx context.Context, req model.BackupRequest, pgDump string) (string, error) {
+	tlsFiles, err := writeTLSFiles(req.TLS)
+	if err != nil {
+		return "", err
+	}
+	defer tlsFiles.Cleanup()
+
+	d := &postgresDialect{req: req, tlsFiles: tlsFiles}
+	snapshot, err := d.begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer d.session.close()
+
+	s, err := d.catalog(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err := s.selectRows(ctx, d, req.Subset); err != nil {
+		return "", err
+	}
+
+	filename := generateFilename(req, "sql")
+	file, err := os.Create(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to create backup file: %w", err)
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	subsetHeader(w, req, s)
+
+	options := optionArgs(req.Type, req.Options, func(name string) bool {
+		return name != "exclude-database"
+	})
+	// pg_dump reads the schema from the snapshot the rows are read from
+	dump := func(section string) error {
+		w.Flush()
+		args := append([]string{"--section=" + section, "--snapshot=" + snapshot}, options...)
+		cmd := pgCommand(ctx, req, tlsFiles, pgDump, append(args, req.Database)...)
+		var stderr bytes.Buffer
+		cmd.Stdout = file
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("pg_dump failed: %s, output: %s", err, stderr.String())
+		}
+		return nil
+	}
+
+	if err := dump("pre-data"); err != nil {
+		return "", err
+	}
+
+	for _, t := range s.sorted() {
+		if len(t.rows) == 0 {
+			continue
+		}
+		columns := make([]string, len(t.columns))
+		for i, column := range t.columns {
+			columns[i] = d.quote(column)
+		}
+
+		fmt.Fprintf(w, "\nCOPY %s (%s) FROM stdin;\n", t.ident, strings.Join(columns, ", "))
+		keys := t.selectedKeys()
+		for start := 0; start < len(keys); start += subsetBatch {
+			batch := keys[start:min(start+subsetBatch, len(keys))]
+			query := fmt.Sprintf("COPY (SELECT %s FROM %s WHERE %s) TO STDOUT;",
+				strings.Join(columns, ", "), t.ident, matchKeys(d, t.key, batch))
+			if err := d.script(ctx, query, w); err != nil {
+				return "", fmt.Errorf("failed to copy rows of %s: %w", t.name, err)
+			}
+		}
+		fmt.Fprintf(w, "\\.\n")
+	}
+
+	// Sequences keep their production values so new rows do not collide
+	// with the copied ones
+	sequences, err := psqlQuery(ctx, req, req.Database, `SELECT format('SELECT pg_catalog.setval(%L, %s, true);',
+		quote_ident(schemaname) || '.' || quote_ident(sequencename), last_value)
+		FROM pg_sequences WHERE last_value IS NOT NULL ORDER BY schemaname, sequencename`)
+	if err != nil {
+		log.Printf("Failed to read sequences of %s: %v", req.Database, err)
+	}
+	if len(sequences) > 0 {
+		fmt.Fprintf(w, "\n")
+		for _, sequence := range sequences {
+			fmt.Fprintf(w, "%s\n", sequence)
+		}
+	}
+	fmt.Fprintf(w, "\n")
+
+	if err := dump("post-data"); err != nil {
+		return "", err
+	}
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("failed to write backup file: %w", err)
+	}
+
+	return filename, nil
+}
+
+type postgresDialect struct {
+	req      model.BackupRequest
+	tlsFiles *tlsFiles
+	session  *subsetSession
+}
+
+// begin starts a psql session in a read-only repeatable read transaction
+// and exports its snapshot for pg_dump
+func (d *postgresDialect) begin(ctx context.Context) (string, error) {
+	cmd := pgCommand(ctx, d.req, d.tlsFiles, resolveExecutable("psql"),
+		"-X", "-q", "-v", "ON_ERROR_STOP=1",
+		"-d", d.req.Database,
+		"-f", "-",
+	)
+	session, err := startSubsetSession(cmd, "psql", "COPY (SELECT '%s') TO STDOUT;")
+	if err != nil {
+		return "", err
+	}
+	d.session = session
+
+	if err := session.run("BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY;", io.Discard); err != nil {
+		return "", err
+	}
+	rows, err := d.literals(ctx, "SELECT pg_export_snapshot()")
+	if err != nil {
+		return "", fmt.Errorf("failed to export snapshot: %w", err)
+	}
+	if len(rows) != 1 || len(rows[0]) != 1 {
+		session.close()
+		return "", fmt.Errorf("failed to export snapshot: unexpected psql output %v", rows)
+	}
+	return rows[0][0], nil
+}
+
+func (d *postgresDialect) quote(ident string) string {
+	return `"` + strings.ReplaceAll(ident, `"`, `""`) + `"`
+}
+
+func (d *postgresDialect) literal(t *subsetTable, column string) string {
+	return "quote_nullable(" + d.quote(column) + ")"
+}
+
+func (d *postgresDialect) random() string {
+	return "random()"
+}
+
+func (d *postgresDialect) literals(ctx context.Context, query string) ([][]string, error) {
+	var out bytes.Buffer
+	if err := d.script(ctx, "COPY ("+query+") TO STDOUT;", &out); err != nil {
+		return nil, err
+	}
+
+	var rows [][]string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		for i, field := range fields {
+			fields[i] = decodeCopyField(field).text
+		}
+		rows = append(rows, fields)
+	}
+	return rows, nil
+}
+
+// script runs SQL in the session, whose psql reads it from stdin so long key
+// lists stay out of the argument list
+func (d *postgresDialect) script(ctx context.Context, sql string, w io.Writer) error {
+	return d.session.run(sql, w)
+}
+
+// catalog reads the tables with their columns, primary and foreign keys.
+// Partitions are left out since their rows are read through the partitioned
+// table. attgenerated and relispartition are read through to_jsonb because
+// older servers lack them.
+func (d *postgresDialect) catalog(ctx context.Context) (*subsetSchema, error) {
+	const tables = `FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+		JOIN pg_attribute a ON a.attrelid = c.oid
+		WHERE c.relkind IN ('r', 'p') AND NOT COALESCE((to_jsonb(c) ->> 'relispartition')::boolean, false)
+		  AND n.nspname NOT IN ('pg_catalog', 'information_schema') AND n.nspname NOT LIKE 'pg\_toast%'`
+
+	s := newSubsetSchema()
+
+	rows, err := d.literals(ctx, `SELECT n.nspname, c.relname, a.attname
+		`+tables+`
+		  AND a.attnum > 0 AND NOT a.attisdropped AND COALESCE(to_jsonb(a) ->> 'attgenerated', '') = ''
+		ORDER BY 1, 2, a.attnum`)
+	if err != nil {
+		return nil, err
+	}
+	for _, fields := range rows {
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected psql output: %v", fields)
+		}
+		t := s.table(d, fields[0], fields[1])
+		t.columns = append(t.columns, fields[2])
+	}
+
+	rows, err = d.literals(ctx, `SELECT n.nspname, c.relname, a.attname
+		FROM pg_constraint k
+		JOIN pg_class c ON c.oid = k.conrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+		CROSS JOIN LATERAL unnest(k.conkey) WITH ORDINALITY AS u(attnum, ord)
+		JOIN pg_attribute a ON a.attrelid = k.conrelid AND a.attnum = u.attnum
+		WHERE k.contype = 'p'
+		ORDER BY 1, 2, u.ord`)
+	if err != nil {
+		return nil, err
+	}
+	for _, fields := range rows {
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("unexpected psql output: %v", fields)
+		}
+		if t, ok := s.tables[fields[0]+"."+fields[1]]; ok {
+			t.key = append(t.key, fields[2])
+		}
+	}
+
+	rows, err = d.literals(ctx, `SELECT k.oid, n.nspname, c.relname, a.attname, rn.nspname, rc.relname, ra.attname
+		FROM pg_constraint k
+		JOIN pg_class c ON c.oid = k.conrelid JOIN pg_namespace n ON n.oid = c.relnamespace
+		JOIN pg_class rc ON rc.oid = k.confrelid JOIN pg_namespace rn ON rn.oid = rc.relnamespace
+		CROSS JOIN LATERAL unnest(k.conkey, k.confkey) WITH ORDINALITY AS u(attnum, refnum, ord)
+		JOIN pg_attribute a ON a.attrelid = k.conrelid AND a.attnum = u.attnum
+		JOIN pg_attribute ra ON ra.attrelid = k.confrelid AND ra.attnum = u.refnum
+		WHERE k.contype = 'f'
+		ORDER BY 1, u.ord`)
+	if err != nil {
+		return nil, err
+	}
+	var fk *foreignKey
+	var id string
+	for _, fields := range rows {
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("unexpected psql output: %v", fields)
+		}
+		if fk == nil || fields[0] != id {
+			if fk != nil {
+				s.link(*fk)
+			}
+			id = fields[0]
+			fk = &foreignKey{table: fields[1] + "." + fields[2], refTable: fields[4] + "." + fields[5]}
+		}
+		fk.columns = append(fk.columns, fields[3])
+		fk.refColumns = append(fk.refColumns, fields[6])
+	}
+	if fk != nil {
+		s.link(*fk)
+	}
+
+	return s, nil
+}
+
+// mysqlSubset dumps the tables and stored programs with the dump tool, then
+// the selected rows as INSERT statements and finally the triggers, so they do
+// not fire while the rows load
+func mysqlSubset(ctx context.Context, req model.BackupRequest, client, dumper string) (string, error) {
+	tlsFiles, err := writeTLSFiles(req.TLS)
+	if err != nil {
+		return "", err
+	}
+	defer tlsFiles.Cleanup()
+
+	d := &mysqlDialect{req: req, client: client, tlsFiles: tlsFiles}
+	if err := d.begin(ctx); err != nil {
+		return "", err
+	}
+	defer d.session.close()
+
+	s, err := d.catalog(ctx)
+	if err != nil {
+		return "", err
+	}
+	if err := s.selectRows(ctx, d, req.Subset); err != nil {
+		return "", err
+	}
+
+	filename := generateFilename(req, "sql")
+	file, err := os.Create(filename)
+	if err != nil {
+		return "", fmt.Errorf("failed to create backup file: %w", err)
+	}
+	defer file.Close()
+	w := bufio.NewWriter(file)
+
+	subsetHeader(w, req, s)
+
+	binPath := resolveExecutable(dumper)
+	dump := func(args ...string) error {
+		w.Flush()
+		cmd := d.command(ctx, binPath, append(args, req.Database)...)
+		var stderr bytes.Buffer
+		cmd.Stdout = file
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s failed: %s, output: %s", dumper, err, stderr.String())
+		}
+		return nil
+	}
+
+	schemaArgs := []string{"--single-transaction", "--no-data", "--skip-triggers", "--routines", "--events"}
+	if err := dump(append(schemaArgs, optionArgs(req.Type, req.Options, nil)...)...); err != nil {
+		return "", err
+	}
+
+	fmt.Fprintf(w, "\nSET NAMES utf8mb4;\n")
+	fmt.Fprintf(w, "SET @OLD_FOREIGN_KEY_CHECKS=@@FOREIGN_KEY_CHECKS, FOREIGN_KEY_CHECKS=0;\n")
+	fmt.Fprintf(w, "SET @OLD_SQL_MODE=@@SQL_MODE, SQL_MODE='NO_AUTO_VALUE_ON_ZERO';\n")
+	for _, t := range s.sorted() {
+		if len(t.rows) == 0 {
+			continue
+		}
+		columns := make([]string, len(t.columns))
+		values := make([]string, len(t.columns))
+		for i, column := range t.columns {
+			columns[i] = d.quote(column)
+			values[i] = d.literal(t, column)
+		}
+
+		keys := t.selectedKeys()
+		for start := 0; start < len(keys); start += subsetBatch {
+			batch := keys[start:min(start+subsetBatch, len(keys))]
+			rows, err := d.literals(ctx, fmt.Sprintf("SELECT CONCAT('(', %s, ')') FROM %s WHERE %s",
+				strings.Join(values, ", ',', "), t.ident, matchKeys(d, t.key, batch)))
+			if err != nil {
+				return "", fmt.Errorf("failed to read rows of %s: %w", t.name, err)
+			}
+			if len(rows) == 0 {
+				continue
+			}
+
+			fmt.Fprintf(w, "INSERT INTO %s (%s) VALUES\n", t.ident, strings.Join(columns, ","))
+			for i, row := range rows {
+				separator := ",\n"
+				if i == len(rows)-1 {
+					separator = ";\n"
+				}
+				fmt.Fprintf(w, "%s%s", row[0], separator)
+			}
+		}
+	}
+	fmt.Fprintf(w, "SET SQL_MODE=@OLD_SQL_MODE;\n")
+	fmt.Fprintf(w, "SET FOREIGN_KEY_CHECKS=@OLD_FOREIGN_KEY_CHECKS;\n\n")
+
+	if err := dump("--single-transaction", "--no-create-info", "--no-data", "--no-create-db", "--triggers", "--skip-routines", "--skip-events"); err != nil {
+		return "", err
+	}
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("failed to write backup file: %w", err)
+	}
+
+	return filename, nil
+}
+
+type mysqlDialect struct {
+	req      model.BackupRequest
+	client   string
+	tlsFiles *tlsFiles
+	session  *subsetSession
+}
+
+// begin starts a client session in a read-only transaction with a consistent
+// snapshot. --unbuffered flushes the output of every statement.
+func (d *mysqlDialect) begin(ctx context.Context) error {
+	cmd := d.command(ctx, resolveExecutable(d.client), "-N", "-B", "--unbuffered", "--default-character-set=utf8mb4", d.req.Database)
+	session, err := startSubsetSession(cmd, d.client, "SELECT '%s';")
+	if err != nil {
+		return err
+	}
+	d.session = session
+
+	return session.run("SET SESSION TRANSACTION ISOLATION LEVEL REPEATABLE READ;\n"+
+		"START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY;", io.Discard)
+}
+
+// mysqlNumericTypes are written as bare numbers so keys compare exactly
+var mysqlNumericTypes = map[string]bool{
+	"tinyint": true, "smallint": true, "mediumint": true, "int": true, "integer": true, "bigint": true,
+	"decimal": true, "numeric": true, "float": true, "double": true, "real": true, "year": true,
+}
+
+// mysqlBinaryTypes are written as hex literals, like mysqldump --hex-blob
+var mysqlBinaryTypes = map[string]bool{
+	"bit": true, "binary": true, "varbinary": true,
+	"tinyblob": true, "blob": true, "mediumblob": true, "longblob": true,
+	"geometry": true, "point": true, "linestring": true, "polygon": true, "multipoint": true,
+	"multilinestring": true, "multipolygon": true, "geometrycollection": true, "geomcollection": true,
+}
+
+func (d *mysqlDialect) quote(ident string) string {
+	return "`" + strings.ReplaceAll(ident, "`", "``") + "`"
+}
+
+func (d *mysqlDialect) literal(t *subsetTable, column string) string {
+	quoted := d.quote(column)
+	switch dataType := t.types[column]; {
+	case mysqlNumericTypes[dataType]:
+		return "IFNULL(CAST(" + quoted + " AS CHAR), 'NULL')"
+	case mysqlBinaryTypes[dataType]:
+		return "IF(" + quoted + " IS NULL, 'NULL', IF(LENGTH(" + quoted + ") = 0, '''''', CONCAT('0x', HEX(" + quoted + "))))"
+	default:
+		return "QUOTE(" + quoted + ")"
+	}
+}
+
+func (d *mysqlDialect) random() string {
+	return "RAND()"
+}
+
+func (d *mysqlDialect) literals(ctx context.Context, query string) ([][]string, error) {
+	var out bytes.Buffer
+	if err := d.session.run(query+";", &out); err != nil {
+		return nil, err
+	}
+
+	// Batch mode escapes tabs, newlines and backslashes like COPY does
+	var rows [][]string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		for i, field := range fields {
+			fields[i] = decodeCopyField(field).text
+		}
+		rows = append(rows, fields)
+	}
+	return rows, nil
+}
+
+// command builds a MySQL client command. The password goes through the
+// environment so it stays out of the process list.
+func (d *mysqlDialect) command(ctx context.Context, binPath string, args ...string) *exec.Cmd {
+	base := []string{
+		"-h", d.req.Host,
+		"-P", d.req.Port,
+		"-u", d.req.Username,
+	}
+	base = append(base, mysqlTLSArgs(ctx, binPath, d.req.TLS, d.tlsFiles)...)
+
+	cmd := exec.CommandContext(ctx, binPath, append(base, args...)...)
+	cmd.Env = append(os.Environ(), fmt.Sprintf("MYSQL_PWD=%s", d.req.Password))
+	return cmd
+}
+
+// catalog reads the base tables with their columns, primary and foreign keys
+func (d *mysqlDialect) catalog(ctx context.Context) (*subsetSchema, error) {
+	s := newSubsetSchema()
+
+	rows, err := d.literals(ctx, `SELECT c.table_name, c.column_name, c.data_type
+		FROM information_schema.columns c
+		JOIN information_schema.tables t ON t.table_schema = c.table_schema AND t.table_name = c.table_name
+		WHERE c.table_schema = DATABASE() AND t.table_type = 'BASE TABLE' AND c.extra NOT LIKE '%GENERATED%'
+		ORDER BY c.table_name, c.ordinal_position`)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if len(row) != 3 {
+			return nil, fmt.Errorf("unexpected %s output: %v", d.client, row)
+		}
+		t := s.table(d, "", row[0])
+		t.columns = append(t.columns, row[1])
+		t.types[row[1]] = strings.ToLower(row[2])
+	}
+
+	rows, err = d.literals(ctx, `SELECT table_name, column_name
+		FROM information_schema.key_column_usage
+		WHERE table_schema = DATABASE() AND constraint_name = 'PRIMARY'
+		ORDER BY table_name, ordinal_position`)
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if len(row) != 2 {
+			return nil, fmt.Errorf("unexpected %s output: %v", d.client, row)
+		}
+		if t, ok := s.tables[row[0]]; ok {
+			t.key = append(t.key, row[1])
+		}
+	}
+
+	rows, err = d.literals(ctx, `SELECT table_name, constraint_name, column_name, referenced_table_name, referenced_column_name
+		FROM information_schema.key_column_usage
+		WHERE table_schema = DATABASE() AND referenced_table_schema = DATABASE()
+		ORDER BY table_name, constraint_name, ordinal_position`)
+	if err != nil {
+		return nil, err
+	}
+	var fk *foreignKey
+	var id string
+	for _, row := range rows {
+		if len(row) != 5 {
+			return nil, fmt.Errorf("unexpected %s output: %v", d.client, row)
+		}
+		if fk == nil || row[0]+"."+row[1] != id {
+			if fk != nil {
+				s.link(*fk)
+			}
+			id = row[0] + "." + row[1]
+			fk = &foreignKey{table: row[0], refTable: row[3]}
+		}
+		fk.columns = append(fk.columns, row[2])
+		fk.refColumns = append(fk.refColumns, row[4])
+	}
+	if fk != nil {
+		s.link(*fk)
+	}
+
+	return s, nil
+}
@@ -0,0 +1,68 @@
+package backup
+
+import (
+	"bytes"
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// shellSession runs a subset session against sh, which executes each
+// statement and echoes the mark like a database client would
+func shellSession(t *testing.T) *subsetSession {
+	t.Helper()
+	session, err := startSubsetSession(exec.Command("sh"), "sh", "echo %s")
+	if err != nil {
+		t.Fatalf("startSubsetSession: %v", err)
+	}
+	t.Cleanup(session.close)
+	return session
+}
+
+func TestSubsetSession(t *testing.T) {
+	session := shellSession(t)
+
+	var out bytes.Buffer
+	if err := session.run("printf 'a\\tb\\nc\\n'", &out); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if out.String() != "a\tb\nc\n" {
+		t.Fatalf("output = %q", out.String())
+	}
+
+	// State carries over between statements, as a transaction does
+	if err := session.run("x=kept", io.Discard); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	out.Reset()
+	if err := session.run("echo $x", &out); err != nil || out.String() != "kept\n" {
+		t.Fatalf("output = %q, %v, want kept", out.String(), err)
+	}
+
+	// Lines longer than the read buffer, ending in the mark
+	long := strings.Repeat("x", 10000) + session.mark
+	out.Reset()
+	if err := session.run("echo "+long+"; echo", &out); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	if out.String() != long+"\n\n" {
+		t.Fatalf("long line read as %d bytes, want %d", out.Len(), len(long)+2)
+	}
+
+	if err := session.run("", io.Discard); err != nil {
+		t.Fatalf("run of an empty statement: %v", err)
+	}
+}
+
+func TestSubsetSessionError(t *testing.T) {
+	session := shellSession(t)
+
+	err := session.run("echo 'relation does not exist' >&2; exit 3", io.Discard)
+	if err == nil || !strings.Contains(err.Error(), "relation does not exist") {
+		t.Fatalf("run error = %v, want the client output", err)
+	}
+	if again := session.run("echo ok", io.Discard); again == nil {
+		t.Fatal("run succeeded after the session ended")
+	}
+}
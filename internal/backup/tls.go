@@ -0,0 +1,179 @@
+package backup
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"db-backup/internal/model"
+	"db-backup/internal/secrets"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// tlsFiles holds the PEM material of a TLS config written to private temporary
+// files, because the dump tools only accept certificate paths
+type tlsFiles struct {
+	dir        string
+	CACert     string
+	ClientCert string
+	ClientKey  string
+	// ClientPEM holds the certificate followed by the key, the form
+	// mongodump expects
+	ClientPEM string
+}
+
+// writeTLSFiles writes the certificates and key of cfg to a temporary
+// directory. Call Cleanup once the tool has finished.
+func writeTLSFiles(cfg *model.TLSConfig) (*tlsFiles, error) {
+	files := &tlsFiles{}
+	if cfg == nil || !cfg.Enabled {
+		return files, nil
+	}
+
+	dir, err := os.MkdirTemp("", "db-backup-tls-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create TLS directory: %w", err)
+	}
+	files.dir = dir
+
+	write := func(name, content string) (string, error) {
+		if content == "" {
+			return "", nil
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			return "", fmt.Errorf("failed to write %s: %w", name, err)
+		}
+		return path, nil
+	}
+
+	if files.CACert, err = write("ca.pem", cfg.CACert); err != nil {
+		files.Cleanup()
+		return nil, err
+	}
+	if files.ClientCert, err = write("cert.pem", cfg.ClientCert); err != nil {
+		files.Cleanup()
+		return nil, err
+	}
+	if files.ClientKey, err = write("key.pem", cfg.ClientKey); err != nil {
+		files.Cleanup()
+		return nil, err
+	}
+	if cfg.ClientCert != "" && cfg.ClientKey != "" {
+		combined := strings.TrimRight(cfg.ClientCert, "\n") + "\n" + cfg.ClientKey
+		if files.ClientPEM, err = write("client.pem", combined); err != nil {
+			files.Cleanup()
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// Cleanup removes the temporary files
+func (f *tlsFiles) Cleanup() {
+	if f != nil && f.dir != "" {
+		os.RemoveAll(f.dir)
+	}
+}
+
+// ValidateTLS checks a TLS config is usable before it is saved or run, so bad
+// certificates surface as request errors instead of failed backups
+func ValidateTLS(t model.BackupType, cfg *model.TLSConfig) error {
+	if cfg == nil {
+		return nil
+	}
+	if t == model.SQLite || t == model.Docker {
+		return fmt.Errorf("tls is not supported for %s", t)
+	}
+	if !cfg.Mode.IsValid() {
+		return fmt.Errorf("tls mode must be one of require, verify-ca, verify-full")
+	}
+	if (cfg.ClientCert == "") != (cfg.ClientKey == "") {
+		return fmt.Errorf("tls client certificate and key must be set together")
+	}
+	if !cfg.Enabled {
+		return nil
+	}
+
+	// Building the config parses the CA and the client key pair. A sealed
+	// key is only opened in the worker and was parsed when it was saved.
+	if secrets.IsSealed(cfg.ClientKey) {
+		unsealed := *cfg
+		unsealed.ClientCert, unsealed.ClientKey = "", ""
+		cfg = &unsealed
+	}
+	_, err := tlsClientConfig(cfg, "")
+	return err
+}
+
+// tlsClientConfig builds a crypto/tls config for connections made in-process
+func tlsClientConfig(cfg *model.TLSConfig, host string) (*tls.Config, error) {
+	if cfg == nil || !cfg.Enabled {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		ServerName:         host,
+		InsecureSkipVerify: cfg.ModeOrDefault() != model.TLSVerifyFull,
+	}
+	if cfg.ServerName != "" {
+		config.ServerName = cfg.ServerName
+	}
+
+	if cfg.CACert != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(cfg.CACert)) {
+			return nil, fmt.Errorf("failed to parse CA certificate")
+		}
+		config.RootCAs = pool
+	}
+
+	// crypto/tls has no chain-only verification, so verify-ca skips the
+	// built-in check and verifies the chain without a host name
+	if cfg.ModeOrDefault() == model.TLSVerifyCA {
+		roots := config.RootCAs
+		config.VerifyPeerCertificate = func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyCertificateChain(rawCerts, roots)
+		}
+	}
+
+	if cfg.ClientCert != "" || cfg.ClientKey != "" {
+		cert, err := tls.X509KeyPair([]byte(cfg.ClientCert), []byte(cfg.ClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// verifyCertificateChain checks the server certificate chains up to roots, or
+// the system roots when nil, without checking the host name
+func verifyCertificateChain(rawCerts [][]byte, roots *x509.CertPool) error {
+	if len(rawCerts) == 0 {
+		return fmt.Errorf("server sent no certificate")
+	}
+
+	certs := make([]*x509.Certificate, 0, len(rawCerts))
+	for _, raw := range rawCerts {
+		cert, err := x509.ParseCertificate(raw)
+		if err != nil {
+			return fmt.Errorf("failed to parse server certificate: %w", err)
+		}
+		certs = append(certs, cert)
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return err
+}
@@ -0,0 +1,132 @@
+package backup
+
+import (
+	"context"
+	"db-backup/internal/model"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// knownTools are the client binaries the strategies may run
+var knownTools = []string{
+	"pg_dump",
+	"pg_dumpall",
+	"psql",
+	"mysqldump",
+	"mysql",
+	"mariadb-dump",
+	"mariadb",
+	"mariabackup",
+	"mongodump",
+	"redis-cli",
+	"sqlite3",
+}
+
+// DetectTools reports which client binaries are installed and their versions
+func DetectTools(ctx context.Context) []model.ToolInfo {
+	tools := make([]model.ToolInfo, 0, len(knownTools))
+	for _, name := range knownTools {
+		tools = append(tools, detectTool(ctx, name, resolveExecutable(name)))
+	}
+	return tools
+}
+
+func detectTool(ctx context.Context, name, binPath string) model.ToolInfo {
+	info := model.ToolInfo{Name: name}
+
+	path, err := exec.LookPath(binPath)
+	if err != nil {
+		info.Error = "not found"
+		return info
+	}
+	info.Path = path
+	info.Found = true
+
+	version, err := toolVersion(ctx, path)
+	if err != nil {
+		info.Error = err.Error()
+		return info
+	}
+	info.Version = version
+	info.Major, _ = parseVersion(version)
+
+	return info
+}
+
+// toolVersion returns the first line printed by `<tool> --version`
+func toolVersion(ctx context.Context, path string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	output, err := exec.CommandContext(ctx, path, "--version").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("failed to get version: %s, output: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	lines := splitLines(output)
+	if len(lines) == 0 {
+		return "", fmt.Errorf("empty version output")
+	}
+	return lines[0], nil
+}
+
+var versionPattern = regexp.MustCompile(`(\d+)\.(\d+)`)
+
+// parseVersion extracts the major and minor version from tool or server
+// version strings. MySQL clients report their own protocol version first
+// ("Ver 10.19 Distrib 10.11.6-MariaDB"), so the distribution version wins.
+func parseVersion(version string) (int, int) {
+	if _, distrib, ok := strings.Cut(version, "Distrib "); ok {
+		version = distrib
+	}
+
+	match := versionPattern.FindStringSubmatch(version)
+	if match == nil {
+		// Bare major versions, e.g. server_version_num based "16"
+		major, _ := strconv.Atoi(strings.TrimSpace(version))
+		return major, 0
+	}
+
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+	return major, minor
+}
+
+// toolEnvName returns the environment variable that overrides a tool path,
+// e.g. TOOL_PATH_PG_DUMP, or TOOL_PATH_PG_DUMP_16 for a server major version
+func toolEnvName(binName string, major int) string {
+	name := "TOOL_PATH_" + strings.ToUpper(strings.ReplaceAll(binName, "-", "_"))
+	if major > 0 {
+		name = fmt.Sprintf("%s_%d", name, major)
+	}
+	return name
+}
+
+// resolveToolForServer picks the binary matching the server major version:
+// a TOOL_PATH_<NAME>_<MAJOR> override, then the versioned install locations
+// of the Alpine and Debian PostgreSQL packages, then the default binary.
+func resolveToolForServer(binName string, serverMajor int) string {
+	if serverMajor > 0 {
+		if path := os.Getenv(toolEnvName(binName, serverMajor)); path != "" {
+			return path
+		}
+
+		candidates := []string{
+			filepath.Join(fmt.Sprintf("/usr/libexec/postgresql%d", serverMajor), binName),
+			filepath.Join(fmt.Sprintf("/usr/lib/postgresql/%d/bin", serverMajor), binName),
+		}
+		for _, candidate := range candidates {
+			if _, err := os.Stat(candidate); err == nil {
+				return candidate
+			}
+		}
+	}
+
+	return resolveExecutable(binName)
+}
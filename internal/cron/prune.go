@@ -0,0 +1,42 @@
+package cron
+
+import (
+	"context"
+	"db-backup/internal/database"
+	"db-backup/internal/dedup"
+	"db-backup/internal/storage"
+	"log"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// chunkGracePeriod keeps unreferenced chunks long enough for any backup that
+// was running when they were released to finish
+const chunkGracePeriod = 24 * time.Hour
+
+// StartChunkPruneCron deletes deduplicated chunks that no backup references
+// any more, once a day
+func StartChunkPruneCron(client *storage.Client, repo *database.Repository) {
+	c := cron.New()
+
+	_, err := c.AddFunc("@daily", func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Hour)
+		defer cancel()
+
+		pruned, freed, err := dedup.Prune(ctx, client, repo, chunkGracePeriod)
+		if err != nil {
+			log.Printf("Chunk prune failed: %v", err)
+			return
+		}
+		log.Printf("Pruned %d unreferenced chunks (%d bytes)", pruned, freed)
+	})
+
+	if err != nil {
+		log.Printf("Failed to add chunk prune job: %v", err)
+		return
+	}
+
+	c.Start()
+	log.Println("Chunk prune cron started.")
+}
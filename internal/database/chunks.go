@@ -0,0 +1,272 @@
+package database
+
+import (
+	"context"
+	"db-backup/internal/model"
+	"errors"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const chunksCollection = "chunks"
+
+// GetChunk retrieves a chunk record, or nil when the chunk is unknown
+func (r *Repository) GetChunk(ctx context.Context, hash string) (*model.Chunk, error) {
+	collection := r.db.Collection(chunksCollection)
+
+	var chunk model.Chunk
+	err := collection.FindOne(ctx, bson.M{"_id": hash}).Decode(&chunk)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get chunk: %w", err)
+	}
+
+	return &chunk, nil
+}
+
+// RefChunk adds a reference to a chunk, creating its record when missing. It
+// returns the record as it was before, or nil when the chunk was new. The
+// stored size is recorded with SetChunkStoredSize once the chunk is uploaded.
+func (r *Repository) RefChunk(ctx context.Context, hash string, size int64) (*model.Chunk, error) {
+	collection := r.db.Collection(chunksCollection)
+
+	filter := bson.M{"_id": hash}
+	update := bson.M{
+		"$inc":         bson.M{"refs": 1},
+		"$max":         bson.M{"size": size},
+		"$unset":       bson.M{"releasedAt": ""},
+		"$setOnInsert": bson.M{"createdAt": primitive.NewDateTimeFromTime(time.Now())},
+	}
+	opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+
+	var before model.Chunk
+	err := collection.FindOneAndUpdate(ctx, filter, update, opts).Decode(&before)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to reference chunk: %w", err)
+	}
+
+	return &before, nil
+}
+
+// SetChunkStoredSize records the compressed size of a chunk after upload
+func (r *Repository) SetChunkStoredSize(ctx context.Context, hash string, storedSize int64) error {
+	collection := r.db.Collection(chunksCollection)
+
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": hash}, bson.M{
+		"$max": bson.M{"storedSize": storedSize},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update chunk: %w", err)
+	}
+
+	return nil
+}
+
+// ReleaseChunks drops one reference from each chunk. Chunks left without
+// references are kept until PruneableChunks returns them.
+func (r *Repository) ReleaseChunks(ctx context.Context, hashes []string) error {
+	collection := r.db.Collection(chunksCollection)
+
+	const batchSize = 1000
+	now := primitive.NewDateTimeFromTime(time.Now())
+	for start := 0; start < len(hashes); start += batchSize {
+		end := min(start+batchSize, len(hashes))
+
+		_, err := collection.UpdateMany(ctx,
+			bson.M{"_id": bson.M{"$in": hashes[start:end]}},
+			bson.M{
+				"$inc": bson.M{"refs": -1},
+				"$set": bson.M{"releasedAt": now},
+			},
+		)
+		if err != nil {
+			return fmt.Errorf("failed to release chunks: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// PruneableChunks lists chunks that have had no references since before
+func (r *Repository) PruneableChunks(ctx context.Context, before time.Time) ([]model.Chunk, error) {
+	collection := r.db.Collection(chunksCollection)
+
+	filter := bson.M{
+		"refs":       bson.M{"$lte": 0},
+		"releasedAt": bson.M{"$lt": primitive.NewDateTimeFromTime(before)},
+	}
+
+	cursor, err := collection.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list unreferenced chunks: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var chunks []model.Chunk
+	if err := cursor.All(ctx, &chunks); err != nil {
+		return nil, fmt.Errorf("failed to decode chunks: %w", err)
+	}
+
+	return chunks, nil
+}
+
+// MarkChunkPruning flags a chunk that is still unreferenced and was released
+// before the given time as being pruned at pruningAt, and reports whether it
+// was flagged. Uploads that reference a flagged chunk wait for the prune to
+// finish before they upload it again.
+func (r *Repository) MarkChunkPruning(ctx context.Context, hash string, before time.Time, pruningAt primitive.DateTime) (bool, error) {
+	collection := r.db.Collection(chunksCollection)
+
+	filter := bson.M{
+		"_id":        hash,
+		"refs":       bson.M{"$lte": 0},
+		"releasedAt": bson.M{"$lt": primitive.NewDateTimeFromTime(before)},
+	}
+	result, err := collection.UpdateOne(ctx, filter, bson.M{
+		"$set": bson.M{"pruningAt": pruningAt},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to mark chunk: %w", err)
+	}
+
+	return result.MatchedCount > 0, nil
+}
+
+// UnmarkChunkPruning clears the prune flag of a chunk if it is still the one
+// set at pruningAt. The object may already be gone, so its stored size is
+// cleared too and the next upload stores it again.
+func (r *Repository) UnmarkChunkPruning(ctx context.Context, hash string, pruningAt primitive.DateTime) error {
+	collection := r.db.Collection(chunksCollection)
+
+	_, err := collection.UpdateOne(ctx, bson.M{"_id": hash, "pruningAt": pruningAt}, bson.M{
+		"$unset": bson.M{"pruningAt": ""},
+		"$set":   bson.M{"storedSize": 0},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to unmark chunk: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteChunk removes a chunk record if it is still unreferenced and reports
+// whether it was removed. A chunk referenced again keeps its record but loses
+// its prune flag and stored size, as its object has been deleted.
+func (r *Repository) DeleteChunk(ctx context.Context, hash string) (bool, error) {
+	collection := r.db.Collection(chunksCollection)
+
+	result, err := collection.DeleteOne(ctx, bson.M{"_id": hash, "refs": bson.M{"$lte": 0}})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete chunk: %w", err)
+	}
+	if result.DeletedCount > 0 {
+		return true, nil
+	}
+
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": hash}, bson.M{
+		"$unset": bson.M{"pruningAt": ""},
+		"$set":   bson.M{"storedSize": 0},
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to unmark chunk: %w", err)
+	}
+
+	return false, nil
+}
+
+// UpdateBackupDedupByID records how a deduplicated backup was stored
+func (r *Repository) UpdateBackupDedupByID(ctx context.Context, id string, info model.DedupInfo) error {
+	collection := r.db.Collection(backupsCollection)
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("invalid backup ID: %w", err)
+	}
+
+	_, err = collection.UpdateOne(ctx, bson.M{"_id": objectID}, bson.M{
+		"$set": bson.M{"dedup": info},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update backup dedup by ID: %w", err)
+	}
+
+	return nil
+}
+
+// GetStorageStats sums the logical size of all completed backups and the
+// bytes they occupy in storage. Deduplicated backups count their index and
+// every stored chunk once. Split server backups are counted through their
+// children, since the parent record only totals them.
+func (r *Repository) GetStorageStats(ctx context.Context) (*model.StorageStats, error) {
+	backups := r.db.Collection(backupsCollection)
+
+	cursor, err := backups.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: bson.M{
+			"status":   model.StatusCompleted,
+			"children": bson.M{"$not": bson.M{"$gt": 0}},
+		}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "logical", Value: bson.M{"$sum": "$fileSize"}},
+			{Key: "dedupLogical", Value: bson.M{"$sum": bson.M{
+				"$cond": bson.A{bson.M{"$ifNull": bson.A{"$dedup", false}}, "$fileSize", 0},
+			}}},
+			{Key: "indexes", Value: bson.M{"$sum": "$dedup.indexSize"}},
+		}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate backup sizes: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var sizes struct {
+		Logical      int64 `bson:"logical"`
+		DedupLogical int64 `bson:"dedupLogical"`
+		Indexes      int64 `bson:"indexes"`
+	}
+	if cursor.Next(ctx) {
+		if err := cursor.Decode(&sizes); err != nil {
+			return nil, fmt.Errorf("failed to decode backup sizes: %w", err)
+		}
+	}
+
+	chunks, err := r.db.Collection(chunksCollection).Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "count", Value: bson.M{"$sum": 1}},
+			{Key: "stored", Value: bson.M{"$sum": "$storedSize"}},
+		}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to aggregate chunk sizes: %w", err)
+	}
+	defer chunks.Close(ctx)
+
+	var chunkSizes struct {
+		Count  int64 `bson:"count"`
+		Stored int64 `bson:"stored"`
+	}
+	if chunks.Next(ctx) {
+		if err := chunks.Decode(&chunkSizes); err != nil {
+			return nil, fmt.Errorf("failed to decode chunk sizes: %w", err)
+		}
+	}
+
+	return &model.StorageStats{
+		LogicalBytes:      sizes.Logical,
+		PhysicalBytes:     sizes.Logical - sizes.DedupLogical + sizes.Indexes + chunkSizes.Stored,
+		DedupLogicalBytes: sizes.DedupLogical,
+		ChunkBytes:        chunkSizes.Stored,
+		Chunks:            chunkSizes.Count,
+	}, nil
+}
@@ -3,6 +3,7 @@ package database
 import (
 	"context"
 	"db-backup/internal/model"
+	"db-backup/internal/secrets"
 	"fmt"
 	"time"
 
@@ -39,14 +40,56 @@ func (r *Repository) SaveDatabase(ctx context.Context, db *model.Database) error
 	}
 	db.UpdatedAt = now
 
-	result, err := collection.InsertOne(ctx, db)
+	// Credentials are stored sealed; the caller gets the stored values back
+	sealed, err := secrets.SealDatabase(db)
+	if err != nil {
+		return fmt.Errorf("failed to seal database credentials: %w", err)
+	}
+
+	result, err := collection.InsertOne(ctx, sealed)
 	if err != nil {
 		return fmt.Errorf("failed to save database: %w", err)
 	}
 
 	// Set the ID from the insert result
 	if oid, ok := result.InsertedID.(primitive.ObjectID); ok {
-		db.ID = oid
+		sealed.ID = oid
+	}
+	*db = *sealed
+
+	return nil
+}
+
+// SaveDatabases inserts several database records at once
+func (r *Repository) SaveDatabases(ctx context.Context, dbs []*model.Database) error {
+	collection := r.db.Collection(databasesCollection)
+
+	now := primitive.NewDateTimeFromTime(time.Now())
+	docs := make([]interface{}, 0, len(dbs))
+	for i, db := range dbs {
+		if db.CreatedAt == 0 {
+			db.CreatedAt = now
+		}
+		db.UpdatedAt = now
+
+		sealed, err := secrets.SealDatabase(db)
+		if err != nil {
+			return fmt.Errorf("failed to seal database credentials: %w", err)
+		}
+		dbs[i] = sealed
+		docs = append(docs, sealed)
+	}
+
+	result, err := collection.InsertMany(ctx, docs)
+	if err != nil {
+		return fmt.Errorf("failed to save databases: %w", err)
+	}
+
+	// Set the IDs from the insert result
+	for i, id := range result.InsertedIDs {
+		if oid, ok := id.(primitive.ObjectID); ok {
+			dbs[i].ID = oid
+		}
 	}
 
 	return nil
@@ -109,14 +152,66 @@ func (r *Repository) UpdateDatabase(ctx context.Context, db *model.Database) err
 
 	db.UpdatedAt = primitive.NewDateTimeFromTime(time.Now())
 
-	_, err := collection.ReplaceOne(ctx, bson.M{"_id": db.ID}, db)
+	sealed, err := secrets.SealDatabase(db)
+	if err != nil {
+		return fmt.Errorf("failed to seal database credentials: %w", err)
+	}
+
+	_, err = collection.ReplaceOne(ctx, bson.M{"_id": db.ID}, sealed)
 	if err != nil {
 		return fmt.Errorf("failed to update database: %w", err)
 	}
+	*db = *sealed
 
 	return nil
 }
 
+// ResealDatabaseSecrets seals the plaintext credentials of every database
+// and rewraps those sealed with a previous master key. Only the secret fields
+// are written, so it is safe to run while the server is up. It returns the
+// number of databases that were, or with dryRun would be, updated.
+func (r *Repository) ResealDatabaseSecrets(ctx context.Context, dryRun bool) (int, error) {
+	collection := r.db.Collection(databasesCollection)
+
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return 0, fmt.Errorf("failed to list databases: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	updated := 0
+	for cursor.Next(ctx) {
+		var db model.Database
+		if err := cursor.Decode(&db); err != nil {
+			return updated, fmt.Errorf("failed to decode database: %w", err)
+		}
+
+		changed, err := secrets.ResealDatabase(&db)
+		if err != nil {
+			return updated, fmt.Errorf("database %s: %w", db.ID.Hex(), err)
+		}
+		if len(changed) == 0 {
+			continue
+		}
+
+		if !dryRun {
+			set := bson.M{}
+			for path, value := range changed {
+				set[path] = value
+			}
+			if _, err := collection.UpdateOne(ctx, bson.M{"_id": db.ID}, bson.M{"$set": set}); err != nil {
+				return updated, fmt.Errorf("failed to update database %s: %w", db.ID.Hex(), err)
+			}
+		}
+		updated++
+	}
+	if err := cursor.Err(); err != nil {
+		return updated, fmt.Errorf("failed to list databases: %w", err)
+	}
+
+	return updated, nil
+}
+
 // DeleteDatabase removes a database record
 func (r *Repository) DeleteDatabase(ctx context.Context, id string) error {
 	collection := r.db.Collection(databasesCollection)
@@ -161,7 +256,7 @@ func (r *Repository) SaveBackup(ctx context.Context, backup *model.BackupMetadat
 }
 
 // ListBackups retrieves backups with pagination and optional filtering
-func (r *Repository) ListBackups(ctx context.Context, page, limit int, statuses []model.BackupStatus, types []string, search, orderBy, orderDir string, startDate, endDate *primitive.DateTime) ([]model.BackupMetadata, int64, error) {
+func (r *Repository) ListBackups(ctx context.Context, page, limit int, statuses []model.BackupStatus, types []string, modes []model.BackupMode, parentID, search, orderBy, orderDir string, startDate, endDate *primitive.DateTime) ([]model.BackupMetadata, int64, error) {
 	collection := r.db.Collection(backupsCollection)
 
 	// Calculate skip
@@ -180,6 +275,27 @@ func (r *Repository) ListBackups(ctx context.Context, page, limit int, statuses
 		filter["type"] = bson.M{"$in": types}
 	}
 
+	// Mode filtering. Backups created before modes existed have no mode and
+	// are full backups.
+	if len(modes) > 0 {
+		modeValues := bson.A{}
+		for _, m := range modes {
+			modeValues = append(modeValues, m)
+			if m == model.ModeFull {
+				modeValues = append(modeValues, nil)
+			}
+		}
+		filter["mode"] = bson.M{"$in": modeValues}
+	}
+
+	// Child backups of a server backup are only listed when asking for a
+	// specific parent
+	if parentID != "" {
+		filter["parentId"] = parentID
+	} else {
+		filter["parentId"] = bson.M{"$exists": false}
+	}
+
 	// Search filtering (search in database, host, and type fields)
 	if search != "" {
 		filter["$or"] = []bson.M{
@@ -302,6 +418,96 @@ func (r *Repository) UpdateBackupStatusByID(ctx context.Context, id string, stat
 	return nil
 }
 
+// UpdateBackupChildrenByID records how many child backups a server backup has
+func (r *Repository) UpdateBackupChildrenByID(ctx context.Context, id string, children int) error {
+	collection := r.db.Collection(backupsCollection)
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("invalid backup ID: %w", err)
+	}
+
+	filter := bson.M{"_id": objectID}
+	update := bson.M{
+		"$set": bson.M{
+			"children": children,
+		},
+	}
+
+	_, err = collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to update backup children by ID: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateBackupWarningByID records a non-fatal problem found before or during a backup
+func (r *Repository) UpdateBackupWarningByID(ctx context.Context, id, warning string) error {
+	collection := r.db.Collection(backupsCollection)
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("invalid backup ID: %w", err)
+	}
+
+	filter := bson.M{"_id": objectID}
+	update := bson.M{
+		"$set": bson.M{
+			"warning": warning,
+		},
+	}
+
+	_, err = collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to update backup warning by ID: %w", err)
+	}
+
+	return nil
+}
+
+// AddBackupHookResultByID appends the outcome of a hook run to a backup
+func (r *Repository) AddBackupHookResultByID(ctx context.Context, id string, result model.HookResult) error {
+	collection := r.db.Collection(backupsCollection)
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return fmt.Errorf("invalid backup ID: %w", err)
+	}
+
+	filter := bson.M{"_id": objectID}
+	update := bson.M{
+		"$push": bson.M{
+			"hooks": result,
+		},
+	}
+
+	_, err = collection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		return fmt.Errorf("failed to add backup hook result by ID: %w", err)
+	}
+
+	return nil
+}
+
+// ListChildBackups retrieves all child backups of a server backup
+func (r *Repository) ListChildBackups(ctx context.Context, parentID string) ([]model.BackupMetadata, error) {
+	collection := r.db.Collection(backupsCollection)
+
+	cursor, err := collection.Find(ctx, bson.M{"parentId": parentID}, options.Find().SetSort(bson.D{{Key: "createdAt", Value: 1}}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list child backups: %w", err)
+	}
+	defer cursor.Close(ctx)
+
+	var backups []model.BackupMetadata
+	if err := cursor.All(ctx, &backups); err != nil {
+		return nil, fmt.Errorf("failed to decode child backups: %w", err)
+	}
+
+	return backups, nil
+}
+
 // UpdateStatus updates the status and error message of the most recent backup for a given host/database/type
 func (r *Repository) UpdateStatus(ctx context.Context, host, database, dbType string, status model.BackupStatus, errorMsg string) error {
 	collection := r.db.Collection(backupsCollection)
@@ -398,6 +604,8 @@ type BackupStats struct {
 	Total    int64            `json:"total"`
 	ByType   map[string]int64 `json:"byType"`
 	ByStatus map[string]int64 `json:"byStatus"`
+	// Storage compares logical and physical bytes across all backups
+	Storage *model.StorageStats `json:"storage,omitempty"`
 }
 
 // GetBackupStats retrieves aggregated backup statistics
@@ -0,0 +1,72 @@
+package dedup
+
+import (
+	"bufio"
+	"io"
+)
+
+// Chunk boundaries are content-defined: a rolling gear hash over the last 64
+// bytes cuts a chunk wherever its top bits are zero, so an insert early in a
+// dump only changes the chunks around it instead of shifting every later one.
+const (
+	minChunkSize = 512 << 10
+	maxChunkSize = 8 << 20
+	// avgChunkBits gives chunks of about 1 MiB on top of the minimum
+	avgChunkBits = 20
+	boundaryMask = uint64(1<<avgChunkBits-1) << (64 - avgChunkBits)
+)
+
+// gear maps each byte to a random value. It is generated from a fixed seed and
+// must never change, or existing chunks would no longer be found.
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	state := uint64(0x6a09e667f3bcc908)
+	for i := range table {
+		// splitmix64
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+type chunker struct {
+	r   *bufio.Reader
+	buf []byte
+}
+
+func newChunker(r io.Reader) *chunker {
+	return &chunker{
+		r:   bufio.NewReaderSize(r, 1<<20),
+		buf: make([]byte, 0, maxChunkSize),
+	}
+}
+
+// next returns the next chunk, or io.EOF after the last one. The slice is
+// reused by the following call.
+func (c *chunker) next() ([]byte, error) {
+	c.buf = c.buf[:0]
+	var hash uint64
+
+	for {
+		b, err := c.r.ReadByte()
+		if err == io.EOF {
+			if len(c.buf) == 0 {
+				return nil, io.EOF
+			}
+			return c.buf, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		c.buf = append(c.buf, b)
+		hash = (hash << 1) + gear[b]
+
+		if len(c.buf) >= maxChunkSize || (len(c.buf) >= minChunkSize && hash&boundaryMask == 0) {
+			return c.buf, nil
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package dedup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func randomData(size int, seed int64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+// chunkAll splits data and returns the chunks, checking they add up to the
+// input
+func chunkAll(t *testing.T, data []byte) [][]byte {
+	t.Helper()
+	var chunks [][]byte
+	c := newChunker(bytes.NewReader(data))
+	for {
+		chunk, err := c.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("next: %v", err)
+		}
+		chunks = append(chunks, append([]byte(nil), chunk...))
+	}
+	if joined := bytes.Join(chunks, nil); !bytes.Equal(joined, data) {
+		t.Fatalf("chunks do not reassemble the input")
+	}
+	return chunks
+}
+
+func chunkHashes(chunks [][]byte) map[[32]byte]bool {
+	hashes := make(map[[32]byte]bool, len(chunks))
+	for _, chunk := range chunks {
+		hashes[sha256.Sum256(chunk)] = true
+	}
+	return hashes
+}
+
+func TestChunkerStableAfterEdits(t *testing.T) {
+	original := randomData(48<<20, 1)
+	base := chunkAll(t, original)
+	if len(base) < 10 {
+		t.Fatalf("got %d chunks, want enough to compare", len(base))
+	}
+	baseHashes := chunkHashes(base)
+
+	insert := append(append(append([]byte{}, original[:1000]...), randomData(100, 2)...), original[1000:]...)
+	remove := append(append([]byte{}, original[:5000]...), original[9000:]...)
+	overwrite := append([]byte{}, original...)
+	copy(overwrite[2<<20:], randomData(64, 3))
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"insert near the start", insert},
+		{"delete near the start", remove},
+		{"overwrite in the middle", overwrite},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chunks := chunkAll(t, tt.data)
+			reused := 0
+			for hash := range chunkHashes(chunks) {
+				if baseHashes[hash] {
+					reused++
+				}
+			}
+			// Only the chunks around the edit may change
+			if changed := len(chunks) - reused; changed > 2 {
+				t.Fatalf("%d of %d chunks changed after a local edit, want at most 2", changed, len(chunks))
+			}
+		})
+	}
+}
+
+func TestChunkerSizes(t *testing.T) {
+	t.Run("random data", func(t *testing.T) {
+		chunks := chunkAll(t, randomData(40<<20, 4))
+		total := 0
+		for i, chunk := range chunks {
+			total += len(chunk)
+			if i == len(chunks)-1 {
+				break
+			}
+			if len(chunk) < minChunkSize || len(chunk) > maxChunkSize {
+				t.Fatalf("chunk %d is %d bytes, want %d to %d", i, len(chunk), minChunkSize, maxChunkSize)
+			}
+		}
+		if avg := total / len(chunks); avg < minChunkSize || avg > 3<<20 {
+			t.Fatalf("average chunk is %d bytes", avg)
+		}
+	})
+
+	t.Run("no boundaries", func(t *testing.T) {
+		// A run of one byte value keeps the hash constant, so only the
+		// maximum size cuts it
+		data := make([]byte, 2*maxChunkSize+123)
+		chunks := chunkAll(t, data)
+		if len(chunks) != 3 || len(chunks[0]) != maxChunkSize || len(chunks[1]) != maxChunkSize || len(chunks[2]) != 123 {
+			sizes := make([]int, len(chunks))
+			for i, chunk := range chunks {
+				sizes[i] = len(chunk)
+			}
+			t.Fatalf("chunk sizes = %v, want two of %d and one of 123", sizes, maxChunkSize)
+		}
+	})
+
+	t.Run("smaller than the minimum", func(t *testing.T) {
+		data := randomData(minChunkSize-1, 5)
+		if chunks := chunkAll(t, data); len(chunks) != 1 {
+			t.Fatalf("got %d chunks, want 1", len(chunks))
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		if chunks := chunkAll(t, nil); len(chunks) != 0 {
+			t.Fatalf("got %d chunks, want none", len(chunks))
+		}
+	})
+}
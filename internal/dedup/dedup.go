@@ -0,0 +1,385 @@
+// Package dedup stores backups as content-defined chunks addressed by their
+// SHA-256 hash. Each chunk is uploaded once, gzip compressed, under
+// chunks/<2 hex>/<hash>; a backup is reduced to a gzipped JSON index listing
+// its chunks in order. Chunk records in MongoDB count the backups that
+// reference them so unreferenced chunks can be pruned.
+package dedup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"db-backup/internal/database"
+	"db-backup/internal/model"
+	"db-backup/internal/storage"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const indexVersion = 1
+
+// Index lists the chunks of one backup in order. A chunk appears once per
+// occurrence in the dump.
+type Index struct {
+	Version int          `json:"version"`
+	Size    int64        `json:"size"`
+	Chunks  []IndexChunk `json:"chunks"`
+}
+
+type IndexChunk struct {
+	Hash string `json:"h"`
+	Size int64  `json:"s"`
+}
+
+// IndexKey returns the object key of the index for a backup file
+func IndexKey(dbType, filePath string) string {
+	return fmt.Sprintf("backups/%s/%s.index", dbType, filepath.Base(filePath))
+}
+
+// FileName returns the name of the backup file an index describes
+func FileName(indexKey string) string {
+	return strings.TrimSuffix(path.Base(indexKey), ".index")
+}
+
+func chunkKey(hash string) string {
+	return fmt.Sprintf("chunks/%s/%s", hash[:2], hash)
+}
+
+// Upload splits a backup file into chunks, uploads the chunks the bucket does
+// not hold yet and writes the index under indexKey. Every distinct chunk gets
+// one reference from this backup; on failure the references are dropped again.
+func Upload(ctx context.Context, client *storage.Client, repo *database.Repository, filePath, indexKey string) (*model.DedupInfo, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open backup file: %w", err)
+	}
+	defer file.Close()
+
+	info := &model.DedupInfo{}
+	index := Index{Version: indexVersion}
+	seen := make(map[string]bool)
+	var refs []string
+
+	fail := func(err error) (*model.DedupInfo, error) {
+		if len(refs) > 0 {
+			releaseCtx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+			defer cancel()
+			if releaseErr := repo.ReleaseChunks(releaseCtx, refs); releaseErr != nil {
+				log.Printf("Failed to release chunks of failed upload: %v", releaseErr)
+			}
+		}
+		return nil, err
+	}
+
+	c := newChunker(file)
+	for {
+		data, err := c.next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fail(fmt.Errorf("failed to read backup file: %w", err))
+		}
+
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		size := int64(len(data))
+		index.Chunks = append(index.Chunks, IndexChunk{Hash: hash, Size: size})
+		index.Size += size
+
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+
+		// Reference before uploading so a prune cannot delete the chunk.
+		// Only a recorded stored size proves the object is in the bucket; a
+		// reference alone may belong to an upload that failed or stopped, so
+		// anything else is uploaded again under the same key.
+		before, err := repo.RefChunk(ctx, hash, size)
+		if err != nil {
+			return fail(err)
+		}
+		refs = append(refs, hash)
+
+		if before != nil && before.StoredSize > 0 && before.PruningAt == 0 {
+			continue
+		}
+		if before != nil && before.PruningAt != 0 {
+			if err := waitForPrune(ctx, repo, hash); err != nil {
+				return fail(err)
+			}
+		}
+		stored, err := putChunk(ctx, client, hash, data)
+		if err != nil {
+			return fail(err)
+		}
+		if err := repo.SetChunkStoredSize(ctx, hash, stored); err != nil {
+			return fail(err)
+		}
+		info.NewChunks++
+		info.StoredSize += stored
+	}
+
+	encoded, err := encodeIndex(index)
+	if err != nil {
+		return fail(err)
+	}
+	if err := client.PutObject(ctx, indexKey, encoded); err != nil {
+		return fail(err)
+	}
+
+	info.Chunks = len(index.Chunks)
+	info.IndexSize = int64(len(encoded))
+	info.StoredSize += info.IndexSize
+
+	return info, nil
+}
+
+// Restore reassembles the backup file of an index, checking every chunk
+// against its hash
+func Restore(ctx context.Context, client *storage.Client, indexKey, filePath string) error {
+	index, err := loadIndex(ctx, client, indexKey)
+	if err != nil {
+		return err
+	}
+
+	out, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer out.Close()
+
+	for _, chunk := range index.Chunks {
+		if err := readChunk(ctx, client, chunk, out); err != nil {
+			os.Remove(filePath)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ErrNoBackupFile is returned by Fetch when a backup has neither a local file
+// nor an object in storage
+var ErrNoBackupFile = errors.New("backup file not found in storage or locally")
+
+// Fetch returns a local path to the artifact of a backup. The local copy is
+// preferred; otherwise the file is downloaded, or reassembled from its chunks
+// for a deduplicated backup. cleanup removes any temporary copy.
+func Fetch(ctx context.Context, client *storage.Client, record *model.BackupMetadata) (string, func(), error) {
+	if record.FilePath != "" {
+		if _, err := os.Stat(record.FilePath); err == nil {
+			return record.FilePath, func() {}, nil
+		}
+	}
+	if record.ObjectKey == "" || client == nil {
+		return "", nil, ErrNoBackupFile
+	}
+
+	tmp, err := os.CreateTemp("", "backup-*")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmp.Close()
+	cleanup := func() { os.Remove(tmp.Name()) }
+
+	if record.Dedup != nil {
+		err = Restore(ctx, client, record.ObjectKey, tmp.Name())
+	} else {
+		err = client.Download(ctx, record.ObjectKey, tmp.Name())
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+
+	return tmp.Name(), cleanup, nil
+}
+
+// Release drops the references of a backup to its chunks and deletes its
+// index. The chunks themselves are removed later by Prune.
+func Release(ctx context.Context, client *storage.Client, repo *database.Repository, indexKey string) error {
+	index, err := loadIndex(ctx, client, indexKey)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	var hashes []string
+	for _, chunk := range index.Chunks {
+		if !seen[chunk.Hash] {
+			seen[chunk.Hash] = true
+			hashes = append(hashes, chunk.Hash)
+		}
+	}
+
+	if err := repo.ReleaseChunks(ctx, hashes); err != nil {
+		return err
+	}
+
+	return client.Delete(ctx, indexKey)
+}
+
+// Prune deletes chunks that no backup has referenced for at least grace. The
+// grace period keeps chunks a running backup may still reference. It returns
+// the number of chunks and stored bytes removed.
+func Prune(ctx context.Context, client *storage.Client, repo *database.Repository, grace time.Duration) (int, int64, error) {
+	chunks, err := repo.PruneableChunks(ctx, time.Now().Add(-grace))
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var pruned int
+	var freed int64
+	for _, chunk := range chunks {
+		// Flag the chunk so an upload that references it meanwhile waits
+		// until the object is deleted before uploading it again
+		pruningAt := primitive.NewDateTimeFromTime(time.Now())
+		marked, err := repo.MarkChunkPruning(ctx, chunk.Hash, time.Now().Add(-grace), pruningAt)
+		if err != nil {
+			return pruned, freed, err
+		}
+		// Referenced again since it was listed
+		if !marked {
+			continue
+		}
+
+		if err := client.Delete(ctx, chunkKey(chunk.Hash)); err != nil {
+			log.Printf("Failed to delete chunk %s: %v", chunk.Hash, err)
+			if err := repo.UnmarkChunkPruning(ctx, chunk.Hash, pruningAt); err != nil {
+				return pruned, freed, err
+			}
+			continue
+		}
+
+		deleted, err := repo.DeleteChunk(ctx, chunk.Hash)
+		if err != nil {
+			return pruned, freed, err
+		}
+		if deleted {
+			pruned++
+			freed += chunk.StoredSize
+		}
+	}
+
+	return pruned, freed, nil
+}
+
+// pruneLease is how long an upload waits for a prune flag to clear before it
+// treats the flag as left behind by a prune that stopped
+const pruneLease = 5 * time.Minute
+
+// waitForPrune waits until a running prune of a chunk has deleted its object
+func waitForPrune(ctx context.Context, repo *database.Repository, hash string) error {
+	for {
+		chunk, err := repo.GetChunk(ctx, hash)
+		if err != nil {
+			return err
+		}
+		if chunk == nil || chunk.PruningAt == 0 {
+			return nil
+		}
+		if time.Since(chunk.PruningAt.Time()) > pruneLease {
+			return repo.UnmarkChunkPruning(ctx, hash, chunk.PruningAt)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+// putChunk compresses and uploads a chunk and returns its stored size
+func putChunk(ctx context.Context, client *storage.Client, hash string, data []byte) (int64, error) {
+	var buf bytes.Buffer
+	gz, _ := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	if _, err := gz.Write(data); err != nil {
+		return 0, fmt.Errorf("failed to compress chunk: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return 0, fmt.Errorf("failed to compress chunk: %w", err)
+	}
+
+	if err := client.PutObject(ctx, chunkKey(hash), buf.Bytes()); err != nil {
+		return 0, fmt.Errorf("failed to upload chunk %s: %w", hash, err)
+	}
+
+	return int64(buf.Len()), nil
+}
+
+// readChunk downloads a chunk, verifies it and appends it to w
+func readChunk(ctx context.Context, client *storage.Client, chunk IndexChunk, w io.Writer) error {
+	body, err := client.GetObject(ctx, chunkKey(chunk.Hash))
+	if err != nil {
+		return fmt.Errorf("failed to download chunk %s: %w", chunk.Hash, err)
+	}
+	defer body.Close()
+
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return fmt.Errorf("failed to read chunk %s: %w", chunk.Hash, err)
+	}
+	defer gz.Close()
+
+	hasher := sha256.New()
+	n, err := io.Copy(io.MultiWriter(w, hasher), gz)
+	if err != nil {
+		return fmt.Errorf("failed to read chunk %s: %w", chunk.Hash, err)
+	}
+	if n != chunk.Size || hex.EncodeToString(hasher.Sum(nil)) != chunk.Hash {
+		return fmt.Errorf("chunk %s is corrupt", chunk.Hash)
+	}
+
+	return nil
+}
+
+func encodeIndex(index Index) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(gz).Encode(index); err != nil {
+		return nil, fmt.Errorf("failed to encode index: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode index: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func loadIndex(ctx context.Context, client *storage.Client, indexKey string) (*Index, error) {
+	body, err := client.GetObject(ctx, indexKey)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	defer gz.Close()
+
+	var index Index
+	if err := json.NewDecoder(gz).Decode(&index); err != nil {
+		return nil, fmt.Errorf("failed to decode index: %w", err)
+	}
+	if index.Version != indexVersion {
+		return nil, fmt.Errorf("unsupported index version %d", index.Version)
+	}
+
+	return &index, nil
+}
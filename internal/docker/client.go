@@ -0,0 +1,365 @@
+// Package docker is a small client for the Docker Engine API, covering what
+// backups of containers need: finding a container, running a command in it,
+// pausing it and copying files out of it.
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultHost is the Docker socket used when DOCKER_HOST is not set
+const DefaultHost = "unix:///var/run/docker.sock"
+
+// Allowed reports whether Docker targets may be used. Access to the Docker
+// socket amounts to root on the host and anyone who can call the API could
+// use it, so they are opt-in with ALLOW_DOCKER_BACKUPS=true.
+func Allowed() bool {
+	return os.Getenv("ALLOW_DOCKER_BACKUPS") == "true"
+}
+
+// Client talks to the Docker daemon over its unix socket, or over plain TCP
+type Client struct {
+	http *http.Client
+	base string
+}
+
+// NewClient connects to the daemon named by DOCKER_HOST, by default the
+// local unix socket. Nothing is sent until the first request.
+func NewClient() (*Client, error) {
+	host := os.Getenv("DOCKER_HOST")
+	if host == "" {
+		host = DefaultHost
+	}
+
+	u, err := url.Parse(host)
+	if err != nil {
+		return nil, fmt.Errorf("invalid DOCKER_HOST %q: %w", host, err)
+	}
+
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+		transport := &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+		return &Client{http: &http.Client{Transport: transport}, base: "http://docker"}, nil
+	case "tcp":
+		return &Client{http: &http.Client{}, base: "http://" + u.Host}, nil
+	default:
+		return nil, fmt.Errorf("unsupported DOCKER_HOST %q: use unix:// or tcp://", host)
+	}
+}
+
+// Container is a container as listed by the daemon
+type Container struct {
+	ID     string            `json:"Id"`
+	Names  []string          `json:"Names"`
+	Image  string            `json:"Image"`
+	State  string            `json:"State"`
+	Labels map[string]string `json:"Labels"`
+}
+
+// ContainerInfo is the detail of one container
+type ContainerInfo struct {
+	ID    string `json:"Id"`
+	Name  string `json:"Name"`
+	State struct {
+		Running bool `json:"Running"`
+		Paused  bool `json:"Paused"`
+	} `json:"State"`
+	Config struct {
+		Image string `json:"Image"`
+	} `json:"Config"`
+	Mounts []Mount `json:"Mounts"`
+}
+
+// Mount is a volume or bind mount of a container
+type Mount struct {
+	Type        string `json:"Type"`
+	Name        string `json:"Name"`
+	Source      string `json:"Source"`
+	Destination string `json:"Destination"`
+}
+
+// do sends a request and returns the response, or the daemon's error message
+// for non-2xx responses
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	target := c.base + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, reader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach docker daemon: %w", err)
+	}
+	if resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		var apiErr struct {
+			Message string `json:"message"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		if json.Unmarshal(data, &apiErr) != nil || apiErr.Message == "" {
+			apiErr.Message = strings.TrimSpace(string(data))
+		}
+		return nil, fmt.Errorf("docker %s %s: %s (status %d)", method, path, apiErr.Message, resp.StatusCode)
+	}
+	return resp, nil
+}
+
+// getJSON decodes the response of a GET request into v
+func (c *Client) getJSON(ctx context.Context, path string, query url.Values, v interface{}) error {
+	resp, err := c.do(ctx, http.MethodGet, path, query, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Version returns the version of the daemon
+func (c *Client) Version(ctx context.Context) (string, error) {
+	var version struct {
+		Version string `json:"Version"`
+	}
+	if err := c.getJSON(ctx, "/version", nil, &version); err != nil {
+		return "", err
+	}
+	return version.Version, nil
+}
+
+// FindByLabel returns the one running container with a label, given as key
+// or key=value
+func (c *Client) FindByLabel(ctx context.Context, label string) (*Container, error) {
+	filters, _ := json.Marshal(map[string][]string{
+		"label":  {label},
+		"status": {"running"},
+	})
+
+	var containers []Container
+	if err := c.getJSON(ctx, "/containers/json", url.Values{"filters": {string(filters)}}, &containers); err != nil {
+		return nil, err
+	}
+
+	switch len(containers) {
+	case 0:
+		return nil, fmt.Errorf("no running container has label %s", label)
+	case 1:
+		return &containers[0], nil
+	}
+	names := make([]string, 0, len(containers))
+	for _, container := range containers {
+		names = append(names, containerName(container.Names))
+	}
+	return nil, fmt.Errorf("label %s matches %d containers: %s", label, len(containers), strings.Join(names, ", "))
+}
+
+// Inspect returns a container by name or ID
+func (c *Client) Inspect(ctx context.Context, id string) (*ContainerInfo, error) {
+	var info ContainerInfo
+	if err := c.getJSON(ctx, "/containers/"+url.PathEscape(id)+"/json", nil, &info); err != nil {
+		return nil, err
+	}
+	info.Name = strings.TrimPrefix(info.Name, "/")
+	return &info, nil
+}
+
+// Exec runs a command in a running container and streams its standard
+// output to stdout. It fails when the command exits with a non-zero code,
+// reporting the end of its standard error.
+func (c *Client) Exec(ctx context.Context, id string, cmd, env []string, stdout io.Writer) error {
+	return c.ExecWithInput(ctx, id, cmd, env, nil, stdout)
+}
+
+// ExecWithInput runs a command like Exec and writes stdin to its standard
+// input, so secrets can be passed without showing up in its arguments or
+// environment
+func (c *Client) ExecWithInput(ctx context.Context, id string, cmd, env []string, stdin io.Reader, stdout io.Writer) error {
+	resp, err := c.do(ctx, http.MethodPost, "/containers/"+url.PathEscape(id)+"/exec", nil, map[string]interface{}{
+		"AttachStdin":  stdin != nil,
+		"AttachStdout": true,
+		"AttachStderr": true,
+		"Cmd":          cmd,
+		"Env":          env,
+	})
+	if err != nil {
+		return err
+	}
+	var created struct {
+		ID string `json:"Id"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&created)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("failed to create exec: %w", err)
+	}
+
+	output, err := c.startExec(ctx, created.ID, stdin)
+	if err != nil {
+		return err
+	}
+	var stderr bytes.Buffer
+	err = demux(output, stdout, &stderr)
+	output.Close()
+	if err != nil {
+		return fmt.Errorf("%s failed: %w", cmd[0], err)
+	}
+
+	// The stream can end before the daemon records the exit code, so the
+	// code only counts once the exec is no longer running
+	var inspect struct {
+		ExitCode int  `json:"ExitCode"`
+		Running  bool `json:"Running"`
+	}
+	for {
+		if err := c.getJSON(ctx, "/exec/"+created.ID+"/json", nil, &inspect); err != nil {
+			return err
+		}
+		if !inspect.Running {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s did not finish: %w", cmd[0], ctx.Err())
+		case <-time.After(200 * time.Millisecond):
+		}
+	}
+	if inspect.ExitCode != 0 {
+		output := strings.TrimSpace(stderr.String())
+		if len(output) > 2048 {
+			output = output[len(output)-2048:]
+		}
+		return fmt.Errorf("%s exited with code %d, output: %s", cmd[0], inspect.ExitCode, output)
+	}
+	return nil
+}
+
+// startExec starts an exec and returns its multiplexed output. Without an
+// upgrade header the daemon answers with the output in the body and closes
+// the connection when the command ends. Standard input needs the connection
+// upgraded to a raw stream, which the body then writes to.
+func (c *Client) startExec(ctx context.Context, execID string, stdin io.Reader) (io.ReadCloser, error) {
+	if stdin == nil {
+		resp, err := c.do(ctx, http.MethodPost, "/exec/"+execID+"/start", nil, map[string]interface{}{
+			"Detach": false,
+			"Tty":    false,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return resp.Body, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base+"/exec/"+execID+"/start", strings.NewReader(`{"Detach":false,"Tty":false}`))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach docker daemon: %w", err)
+	}
+	conn, ok := resp.Body.(io.ReadWriteCloser)
+	if resp.StatusCode != http.StatusSwitchingProtocols || !ok {
+		resp.Body.Close()
+		return nil, fmt.Errorf("docker POST /exec/%s/start: connection was not upgraded (status %d)", execID, resp.StatusCode)
+	}
+	if _, err := io.Copy(conn, stdin); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to write to exec: %w", err)
+	}
+	return conn, nil
+}
+
+// demux splits the multiplexed stream of an exec into standard output and
+// standard error. Each frame has an 8 byte header: the stream, three zero
+// bytes and the big endian payload size.
+func demux(r io.Reader, stdout, stderr io.Writer) error {
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(r, header); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		w := stdout
+		if header[0] == 2 {
+			w = stderr
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		if _, err := io.CopyN(w, r, size); err != nil {
+			return err
+		}
+	}
+}
+
+// Pause freezes the processes of a container
+func (c *Client) Pause(ctx context.Context, id string) error {
+	return c.post(ctx, "/containers/"+url.PathEscape(id)+"/pause")
+}
+
+// Unpause resumes a paused container
+func (c *Client) Unpause(ctx context.Context, id string) error {
+	return c.post(ctx, "/containers/"+url.PathEscape(id)+"/unpause")
+}
+
+func (c *Client) post(ctx context.Context, path string) error {
+	resp, err := c.do(ctx, http.MethodPost, path, nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Archive returns a tar stream of a path inside a container. The entries
+// are named below the base name of the path.
+func (c *Client) Archive(ctx context.Context, id, path string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/containers/"+url.PathEscape(id)+"/archive", url.Values{"path": {path}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func containerName(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(names[0], "/")
+}
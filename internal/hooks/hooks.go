@@ -0,0 +1,235 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"db-backup/internal/model"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	defaultTimeout = 60 * time.Second
+	// maxOutput caps the command output or response body kept per hook
+	maxOutput = 4096
+)
+
+// Vars are the backup details available to hooks, as BACKUP_* environment
+// variables for commands and as template fields for HTTP hooks
+type Vars struct {
+	ID        string
+	Stage     model.HookStage
+	Type      model.BackupType
+	Host      string
+	Port      string
+	Database  string
+	Mode      model.BackupMode
+	Scope     model.BackupScope
+	Status    model.BackupStatus
+	File      string
+	FileSize  int64
+	ObjectKey string
+	Error     string
+}
+
+func (v Vars) env() []string {
+	return []string{
+		"BACKUP_ID=" + v.ID,
+		"BACKUP_STAGE=" + string(v.Stage),
+		"BACKUP_TYPE=" + string(v.Type),
+		"BACKUP_HOST=" + v.Host,
+		"BACKUP_PORT=" + v.Port,
+		"BACKUP_DATABASE=" + v.Database,
+		"BACKUP_MODE=" + string(v.Mode),
+		"BACKUP_SCOPE=" + string(v.Scope),
+		"BACKUP_STATUS=" + string(v.Status),
+		"BACKUP_FILE=" + v.File,
+		"BACKUP_FILE_SIZE=" + strconv.FormatInt(v.FileSize, 10),
+		"BACKUP_OBJECT_KEY=" + v.ObjectKey,
+		"BACKUP_ERROR=" + v.Error,
+	}
+}
+
+// CommandsAllowed reports whether shell commands may run. Anyone who can call
+// the API could otherwise run commands on the server, so they are opt-in with
+// ALLOW_SHELL_COMMANDS=true.
+func CommandsAllowed() bool {
+	return os.Getenv("ALLOW_SHELL_COMMANDS") == "true"
+}
+
+// Validate checks hooks before they are saved or run
+func Validate(hooks []model.Hook) error {
+	for i, hook := range hooks {
+		name := hook.Name
+		if name == "" {
+			name = fmt.Sprintf("#%d", i+1)
+		}
+
+		if !hook.Stage.IsValid() {
+			return fmt.Errorf("hook %s: stage must be one of pre-backup, post-backup, post-upload", name)
+		}
+		if hook.TimeoutSeconds < 0 {
+			return fmt.Errorf("hook %s: timeoutSeconds cannot be negative", name)
+		}
+
+		switch hook.Type {
+		case model.HookHTTP:
+			if hook.URL == "" {
+				return fmt.Errorf("hook %s: http hooks require a url", name)
+			}
+			if _, err := template.New("url").Parse(hook.URL); err != nil {
+				return fmt.Errorf("hook %s: invalid url template: %w", name, err)
+			}
+			if _, err := template.New("body").Parse(hook.Body); err != nil {
+				return fmt.Errorf("hook %s: invalid body template: %w", name, err)
+			}
+			for header, value := range hook.Headers {
+				if _, err := template.New(header).Parse(value); err != nil {
+					return fmt.Errorf("hook %s: invalid %s header template: %w", name, header, err)
+				}
+			}
+		case model.HookCommand:
+			if hook.Command == "" {
+				return fmt.Errorf("hook %s: command hooks require a command", name)
+			}
+			if !CommandsAllowed() {
+				return fmt.Errorf("hook %s: command hooks are disabled; set ALLOW_SHELL_COMMANDS=true to enable them", name)
+			}
+		default:
+			return fmt.Errorf("hook %s: type must be one of http, command", name)
+		}
+	}
+
+	return nil
+}
+
+// Run runs a hook and reports its outcome
+func Run(ctx context.Context, hook model.Hook, vars Vars) model.HookResult {
+	vars.Stage = hook.Stage
+
+	timeout := defaultTimeout
+	if hook.TimeoutSeconds > 0 {
+		timeout = time.Duration(hook.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	var output string
+	var err error
+	switch hook.Type {
+	case model.HookHTTP:
+		output, err = runHTTP(ctx, hook, vars)
+	case model.HookCommand:
+		output, err = runCommand(ctx, hook, vars)
+	default:
+		err = fmt.Errorf("unsupported hook type: %s", hook.Type)
+	}
+
+	result := model.HookResult{
+		Name:       hook.Name,
+		Stage:      hook.Stage,
+		Success:    err == nil,
+		Output:     truncate(output),
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	return result
+}
+
+func runCommand(ctx context.Context, hook model.Hook, vars Vars) (string, error) {
+	if !CommandsAllowed() {
+		return "", fmt.Errorf("command hooks are disabled; set ALLOW_SHELL_COMMANDS=true to enable them")
+	}
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", hook.Command)
+	cmd.Env = append(os.Environ(), vars.env()...)
+	// Children of the shell may keep the output open after a timeout kills
+	// it; stop waiting for them shortly after
+	cmd.WaitDelay = 5 * time.Second
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return string(output), fmt.Errorf("command failed: %w", err)
+	}
+
+	return string(output), nil
+}
+
+func runHTTP(ctx context.Context, hook model.Hook, vars Vars) (string, error) {
+	url, err := render(hook.URL, vars)
+	if err != nil {
+		return "", err
+	}
+	body, err := render(hook.Body, vars)
+	if err != nil {
+		return "", err
+	}
+
+	method := hook.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, url, strings.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("invalid request: %w", err)
+	}
+	for name, value := range hook.Headers {
+		rendered, err := render(value, vars)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set(name, rendered)
+	}
+	if body != "" && req.Header.Get("Content-Type") == "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, maxOutput))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return string(respBody), fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+
+	return string(respBody), nil
+}
+
+// render executes a hook template over the backup details
+func render(text string, vars Vars) (string, error) {
+	tmpl, err := template.New("hook").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("invalid template: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("failed to render template: %w", err)
+	}
+
+	return buf.String(), nil
+}
+
+// truncate keeps the end of long output, where errors usually are
+func truncate(output string) string {
+	output = strings.TrimSpace(output)
+	if len(output) > maxOutput {
+		return "..." + output[len(output)-maxOutput:]
+	}
+	return output
+}
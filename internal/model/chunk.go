@@ -0,0 +1,38 @@
+package model
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+// Chunk is a deduplicated piece of backup data stored once in the bucket.
+// Refs counts the backups whose index lists the chunk; chunks without
+// references are pruned once ReleasedAt is old enough.
+type Chunk struct {
+	Hash       string             `bson:"_id" json:"hash"`
+	Size       int64              `bson:"size" json:"size"`
+	StoredSize int64              `bson:"storedSize" json:"storedSize"`
+	Refs       int                `bson:"refs" json:"refs"`
+	CreatedAt  primitive.DateTime `bson:"createdAt" json:"createdAt" swaggertype:"string"`
+	ReleasedAt primitive.DateTime `bson:"releasedAt,omitempty" json:"releasedAt,omitempty" swaggertype:"string"`
+	PruningAt  primitive.DateTime `bson:"pruningAt,omitempty" json:"pruningAt,omitempty" swaggertype:"string"`
+}
+
+// DedupInfo describes how a deduplicated backup was stored. FileSize of the
+// backup stays the logical size of the dump.
+type DedupInfo struct {
+	Chunks    int `bson:"chunks" json:"chunks"`
+	NewChunks int `bson:"newChunks" json:"newChunks"`
+	// StoredSize is what this backup added to the bucket: its new chunks,
+	// compressed, plus the index
+	StoredSize int64 `bson:"storedSize" json:"storedSize"`
+	IndexSize  int64 `bson:"indexSize" json:"indexSize"`
+}
+
+// StorageStats compares the size of the backups with the bytes they occupy
+// in storage
+type StorageStats struct {
+	LogicalBytes  int64 `json:"logicalBytes"`
+	PhysicalBytes int64 `json:"physicalBytes"`
+	// DedupLogicalBytes is the part of LogicalBytes stored as chunks
+	DedupLogicalBytes int64 `json:"dedupLogicalBytes"`
+	ChunkBytes        int64 `json:"chunkBytes"`
+	Chunks            int64 `json:"chunks"`
+}
@@ -0,0 +1,37 @@
+package model
+
+// ExportFormat is the flat file format of an export
+type ExportFormat string
+
+const (
+	ExportCSV     ExportFormat = "csv"
+	ExportJSONL   ExportFormat = "jsonl"
+	ExportParquet ExportFormat = "parquet"
+)
+
+// IsValid reports whether the format is a known export format
+func (f ExportFormat) IsValid() bool {
+	switch f {
+	case ExportCSV, ExportJSONL, ExportParquet:
+		return true
+	default:
+		return false
+	}
+}
+
+// ExportOptions configure the flat exports of a saved database. A cron
+// expression schedules exports independently of the database's backups.
+type ExportOptions struct {
+	Format ExportFormat `bson:"format" json:"format" example:"parquet"`
+	// Tables limits the export to these tables or collections, optionally
+	// qualified by schema or database. Empty exports every table.
+	Tables         []string `bson:"tables,omitempty" json:"tables,omitempty" example:"public.orders"`
+	CronExpression string   `bson:"cronExpression,omitempty" json:"cronExpression,omitempty" example:"0 3 * * *"`
+}
+
+// ExportRequest represents the request body for an export of a backup or a
+// saved database
+type ExportRequest struct {
+	Format ExportFormat `json:"format" example:"csv"`
+	Tables []string     `json:"tables,omitempty" example:"public.orders"`
+}
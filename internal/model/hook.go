@@ -0,0 +1,62 @@
+package model
+
+// HookStage says when a hook runs during a backup
+type HookStage string
+
+const (
+	// HookPreBackup runs before the dump; a failure aborts the backup
+	HookPreBackup HookStage = "pre-backup"
+	// HookPostBackup runs after the dump, whether it succeeded or not, so it
+	// can undo what a pre-backup hook did
+	HookPostBackup HookStage = "post-backup"
+	// HookPostUpload runs once the backup is stored
+	HookPostUpload HookStage = "post-upload"
+)
+
+// IsValid reports whether the stage is a known stage
+func (s HookStage) IsValid() bool {
+	switch s {
+	case HookPreBackup, HookPostBackup, HookPostUpload:
+		return true
+	default:
+		return false
+	}
+}
+
+// HookType selects what a hook does
+type HookType string
+
+const (
+	// HookHTTP calls a URL
+	HookHTTP HookType = "http"
+	// HookCommand runs a shell command
+	HookCommand HookType = "command"
+)
+
+// Hook is an HTTP call or shell command run around a backup. Commands get
+// the backup details as BACKUP_* environment variables; the URL, headers and
+// body of HTTP hooks are Go templates over the same fields, e.g. {{.Database}}.
+type Hook struct {
+	Name  string    `bson:"name" json:"name" example:"maintenance on"`
+	Stage HookStage `bson:"stage" json:"stage" example:"pre-backup"`
+	Type  HookType  `bson:"type" json:"type" example:"http"`
+	// HTTP hooks
+	URL     string            `bson:"url,omitempty" json:"url,omitempty" example:"https://app.example.com/maintenance"`
+	Method  string            `bson:"method,omitempty" json:"method,omitempty" example:"POST"`
+	Headers map[string]string `bson:"headers,omitempty" json:"headers,omitempty"`
+	Body    string            `bson:"body,omitempty" json:"body,omitempty"`
+	// Command hooks, run with sh -c
+	Command string `bson:"command,omitempty" json:"command,omitempty" example:"mysql -e 'FLUSH TABLES'"`
+	// TimeoutSeconds defaults to 60
+	TimeoutSeconds int `bson:"timeoutSeconds,omitempty" json:"timeoutSeconds,omitempty" example:"60"`
+}
+
+// HookResult records the outcome of one hook run
+type HookResult struct {
+	Name       string    `bson:"name" json:"name"`
+	Stage      HookStage `bson:"stage" json:"stage"`
+	Success    bool      `bson:"success" json:"success"`
+	Error      string    `bson:"error,omitempty" json:"error,omitempty"`
+	Output     string    `bson:"output,omitempty" json:"output,omitempty"`
+	DurationMs int64     `bson:"durationMs" json:"durationMs"`
+}
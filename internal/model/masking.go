@@ -0,0 +1,59 @@
+package model
+
+// MaskingStrategy selects how a masked value is replaced. Every strategy
+// except null and fixed is deterministic for a given salt, so the same input
+// masks to the same output across tables and keeps joins intact.
+type MaskingStrategy string
+
+const (
+	// MaskHash replaces the value with the hex HMAC-SHA256 of it
+	MaskHash MaskingStrategy = "hash"
+	// MaskEmail replaces the value with an address at example.com
+	MaskEmail MaskingStrategy = "email"
+	// MaskNull replaces the value with NULL
+	MaskNull MaskingStrategy = "null"
+	// MaskFixed replaces the value with the rule's Value
+	MaskFixed MaskingStrategy = "fixed"
+	// MaskKeepFormat replaces digits with digits and letters with letters of
+	// the same case, keeping length and punctuation
+	MaskKeepFormat MaskingStrategy = "keep_format"
+)
+
+// IsValid reports whether the strategy is a known strategy
+func (s MaskingStrategy) IsValid() bool {
+	switch s {
+	case MaskHash, MaskEmail, MaskNull, MaskFixed, MaskKeepFormat:
+		return true
+	default:
+		return false
+	}
+}
+
+// MaskingRule masks one column, or one field of a MongoDB collection
+type MaskingRule struct {
+	// Table is a table or collection name, optionally qualified by schema or
+	// database, e.g. public.users. An empty table matches every table.
+	Table string `bson:"table,omitempty" json:"table,omitempty" example:"users"`
+	// Column is a column name or a dotted MongoDB field path
+	Column   string          `bson:"column" json:"column" example:"email"`
+	Strategy MaskingStrategy `bson:"strategy" json:"strategy" example:"email"`
+	// Value replaces the column for the fixed strategy
+	Value string `bson:"value,omitempty" json:"value,omitempty"`
+}
+
+// MaskingProfile is a named set of rules applied to produce a sanitized copy
+// of a backup
+type MaskingProfile struct {
+	Name string `bson:"name" json:"name" example:"staging"`
+	// Salt keys the hashes so masked values cannot be matched against hashes
+	// of guessed inputs. It is generated when empty.
+	Salt  string        `bson:"salt" json:"salt,omitempty"`
+	Rules []MaskingRule `bson:"rules" json:"rules"`
+}
+
+// SanitizeRequest selects the masking profile of a saved database that is
+// applied to a backup
+type SanitizeRequest struct {
+	DatabaseID string `json:"databaseId" example:"60d5ec49f1a4c2b8e8f0e4a1"`
+	Profile    string `json:"profile" example:"staging"`
+}
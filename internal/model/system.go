@@ -0,0 +1,16 @@
+package model
+
+// ToolInfo describes a database client binary used for backups
+type ToolInfo struct {
+	Name    string `json:"name" example:"pg_dump"`
+	Path    string `json:"path,omitempty" example:"/usr/bin/pg_dump"`
+	Found   bool   `json:"found" example:"true"`
+	Version string `json:"version,omitempty" example:"pg_dump (PostgreSQL) 16.2"`
+	Major   int    `json:"major,omitempty" example:"16"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ToolsResponse lists the client binaries and the versions found
+type ToolsResponse struct {
+	Tools []ToolInfo `json:"tools"`
+}
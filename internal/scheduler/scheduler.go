@@ -17,6 +17,12 @@ type Scheduler struct {
 	jobs map[string]uuid.UUID // Map database ID to job ID
 }
 
+// exportJobKey keys the export job of a database, which runs on its own
+// schedule next to the backup job
+func exportJobKey(dbID string) string {
+	return "export:" + dbID
+}
+
 func NewScheduler(repo *database.Repository) (*Scheduler, error) {
 	s, err := gocron.NewScheduler()
 	if err != nil {
@@ -52,7 +58,7 @@ func (s *Scheduler) loadJobs() {
 	}
 
 	for _, db := range dbs {
-		if db.IsActive && db.CronExpression != "" {
+		if db.IsActive && (db.CronExpression != "" || db.Export != nil) {
 			if err := s.AddJob(&db); err != nil {
 				log.Printf("Failed to schedule job for database %s: %v", db.Name, err)
 			}
@@ -61,40 +67,52 @@ func (s *Scheduler) loadJobs() {
 }
 
 func (s *Scheduler) AddJob(db *model.Database) error {
-	// Remove existing job if any
+	// Remove existing jobs if any
 	s.RemoveJob(db.ID.Hex())
 
-	if !db.IsActive || db.CronExpression == "" {
+	if !db.IsActive {
 		return nil
 	}
 
-	job, err := s.cron.NewJob(
-		gocron.CronJob(db.CronExpression, false),
-		gocron.NewTask(
-			func(db model.Database) {
-				log.Printf("Running scheduled backup for %s", db.Name)
-				req := model.BackupRequest{
-					Type:          db.Type,
-					Host:          db.Host,
-					Port:          db.Port,
-					Username:      db.Username,
-					Password:      db.Password,
-					Database:      db.Database,
-					ConnectionURI: db.ConnectionURI,
-					WebhookURL:    db.WebhookURL,
-				}
-				worker.ProcessBackup(req)
-			},
-			*db,
-		),
-	)
+	if db.CronExpression != "" {
+		job, err := s.cron.NewJob(
+			gocron.CronJob(db.CronExpression, false),
+			gocron.NewTask(
+				func(db model.Database) {
+					log.Printf("Running scheduled backup for %s", db.Name)
+					worker.ProcessBackup(db.BackupRequest())
+				},
+				*db,
+			),
+		)
 
-	if err != nil {
-		return err
+		if err != nil {
+			return err
+		}
+
+		s.jobs[db.ID.Hex()] = job.ID()
+		log.Printf("Scheduled backup for %s with schedule %s", db.Name, db.CronExpression)
 	}
 
-	s.jobs[db.ID.Hex()] = job.ID()
-	log.Printf("Scheduled backup for %s with schedule %s", db.Name, db.CronExpression)
+	if db.Export != nil && db.Export.CronExpression != "" {
+		job, err := s.cron.NewJob(
+			gocron.CronJob(db.Export.CronExpression, false),
+			gocron.NewTask(
+				func(db model.Database) {
+					log.Printf("Running scheduled %s export for %s", db.Export.Format, db.Name)
+					worker.ProcessDatabaseExport(db, db.Export.Format, db.Export.Tables)
+				},
+				*db,
+			),
+		)
+
+		if err != nil {
+			return err
+		}
+
+		s.jobs[exportJobKey(db.ID.Hex())] = job.ID()
+		log.Printf("Scheduled %s export for %s with schedule %s", db.Export.Format, db.Name, db.Export.CronExpression)
+	}
 	return nil
 }
 
@@ -106,4 +124,11 @@ func (s *Scheduler) RemoveJob(dbID string) {
 		delete(s.jobs, dbID)
 		log.Printf("Removed scheduled backup for database %s", dbID)
 	}
+	if jobID, exists := s.jobs[exportJobKey(dbID)]; exists {
+		if err := s.cron.RemoveJob(jobID); err != nil {
+			log.Printf("Failed to remove export job for database %s: %v", dbID, err)
+		}
+		delete(s.jobs, exportJobKey(dbID))
+		log.Printf("Removed scheduled export for database %s", dbID)
+	}
 }
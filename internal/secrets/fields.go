@@ -0,0 +1,113 @@
+package secrets
+
+import (
+	"db-backup/internal/model"
+	"fmt"
+)
+
+// databaseFields returns the secret fields of a database keyed by their
+// document path. The nested structs must not be shared with other values.
+func databaseFields(db *model.Database) map[string]*string {
+	fields := map[string]*string{
+		"password":      &db.Password,
+		"connectionUri": &db.ConnectionURI,
+	}
+	nestedFields(fields, db.SSH, db.TLS, db.Redis)
+	return fields
+}
+
+// requestFields returns the secret fields of a backup request
+func requestFields(req *model.BackupRequest) map[string]*string {
+	fields := map[string]*string{
+		"password":      &req.Password,
+		"connectionUri": &req.ConnectionURI,
+	}
+	nestedFields(fields, req.SSH, req.TLS, req.Redis)
+	return fields
+}
+
+func nestedFields(fields map[string]*string, ssh *model.SSHTunnel, tls *model.TLSConfig, redis *model.RedisOptions) {
+	if ssh != nil {
+		fields["ssh.privateKey"] = &ssh.PrivateKey
+		fields["ssh.passphrase"] = &ssh.Passphrase
+	}
+	if tls != nil {
+		fields["tls.clientKey"] = &tls.ClientKey
+	}
+	if redis != nil {
+		fields["redis.sentinelPassword"] = &redis.SentinelPassword
+	}
+}
+
+// cloneNested copies the structs holding secrets so sealing or opening them
+// leaves the original untouched
+func cloneNested(ssh **model.SSHTunnel, tls **model.TLSConfig, redis **model.RedisOptions) {
+	if *ssh != nil {
+		c := **ssh
+		*ssh = &c
+	}
+	if *tls != nil {
+		c := **tls
+		*tls = &c
+	}
+	if *redis != nil {
+		c := **redis
+		*redis = &c
+	}
+}
+
+// SealDatabase returns a copy of a database with its credentials sealed
+func SealDatabase(db *model.Database) (*model.Database, error) {
+	sealed := *db
+	cloneNested(&sealed.SSH, &sealed.TLS, &sealed.Redis)
+	for _, field := range databaseFields(&sealed) {
+		value, err := Seal(*field)
+		if err != nil {
+			return nil, err
+		}
+		*field = value
+	}
+	return &sealed, nil
+}
+
+// OpenRequest returns a copy of a backup request with its credentials
+// opened. Requests that were never stored pass through unchanged.
+func OpenRequest(req model.BackupRequest) (model.BackupRequest, error) {
+	cloneNested(&req.SSH, &req.TLS, &req.Redis)
+	for path, field := range requestFields(&req) {
+		value, err := Open(*field)
+		if err != nil {
+			return req, fmt.Errorf("failed to open %s: %w", path, err)
+		}
+		*field = value
+	}
+	return req, nil
+}
+
+// VerifyRequest checks that the sealed credentials of a backup request open
+// with a configured key. The request is left sealed, so credentials are only
+// opened where they are used.
+func VerifyRequest(req model.BackupRequest) error {
+	for path, field := range requestFields(&req) {
+		if err := Verify(*field); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// ResealDatabase brings the credentials of a stored database to the current
+// master key and returns the changed fields by document path
+func ResealDatabase(db *model.Database) (map[string]string, error) {
+	changed := make(map[string]string)
+	for path, field := range databaseFields(db) {
+		value, ok, err := Reseal(*field)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if ok {
+			changed[path] = value
+		}
+	}
+	return changed, nil
+}
@@ -0,0 +1,303 @@
+// Package secrets seals the credentials of saved databases at rest with
+// envelope encryption. Every value gets its own random data key, which
+// encrypts the value with AES-256-GCM and is itself wrapped with the master
+// key. A sealed value is a string of the form
+//
+//	enc:v1:<key id>:<wrapped data key>:<ciphertext>
+//
+// so it is stored in the same field as the plaintext it replaces. Rotating the
+// master key only rewraps the data keys.
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+const prefix = "enc:v1:"
+
+// KeySize is the size of master and data keys in bytes
+const KeySize = 32
+
+var (
+	// ErrNoKey is returned when a sealed value is opened without the master
+	// key it was sealed with
+	ErrNoKey = errors.New("master key not configured")
+
+	keys *Keyring
+)
+
+// Keyring holds the current master key, which seals new values, and the
+// previous keys, which still open values sealed before a rotation
+type Keyring struct {
+	current string
+	keys    map[string][]byte
+}
+
+// Init loads the master keys from the environment. Without a master key
+// values are stored as plaintext.
+func Init() error {
+	k, err := LoadKeyring()
+	if err != nil {
+		return err
+	}
+	keys = k
+	if k == nil {
+		log.Println("Warning: SECRETS_MASTER_KEY is not set, database credentials are stored as plaintext")
+	}
+	return nil
+}
+
+// Enabled reports whether a master key is configured
+func Enabled() bool {
+	return keys != nil
+}
+
+// LoadKeyring reads SECRETS_MASTER_KEY and SECRETS_PREVIOUS_KEYS, or the
+// files named by SECRETS_MASTER_KEY_FILE and SECRETS_PREVIOUS_KEYS_FILE.
+// Keys are base64 encoded; previous keys are separated by commas or newlines.
+// It returns nil when no master key is set.
+func LoadKeyring() (*Keyring, error) {
+	current, err := envOrFile("SECRETS_MASTER_KEY")
+	if err != nil {
+		return nil, err
+	}
+	previous, err := envOrFile("SECRETS_PREVIOUS_KEYS")
+	if err != nil {
+		return nil, err
+	}
+	if current == "" {
+		if previous != "" {
+			return nil, fmt.Errorf("SECRETS_PREVIOUS_KEYS requires SECRETS_MASTER_KEY")
+		}
+		return nil, nil
+	}
+
+	k := &Keyring{keys: make(map[string][]byte)}
+	if k.current, err = k.add(current); err != nil {
+		return nil, fmt.Errorf("invalid SECRETS_MASTER_KEY: %w", err)
+	}
+	for _, encoded := range strings.FieldsFunc(previous, func(r rune) bool { return r == ',' || r == '\n' }) {
+		if encoded = strings.TrimSpace(encoded); encoded == "" {
+			continue
+		}
+		if _, err := k.add(encoded); err != nil {
+			return nil, fmt.Errorf("invalid key in SECRETS_PREVIOUS_KEYS: %w", err)
+		}
+	}
+	return k, nil
+}
+
+func envOrFile(name string) (string, error) {
+	if value := os.Getenv(name); value != "" {
+		return strings.TrimSpace(value), nil
+	}
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return "", nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s_FILE: %w", name, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// add decodes a key and returns its ID, the start of its SHA-256 hash
+func (k *Keyring) add(encoded string) (string, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", err
+	}
+	if len(key) != KeySize {
+		return "", fmt.Errorf("key must be %d bytes, got %d", KeySize, len(key))
+	}
+	sum := sha256.Sum256(key)
+	id := hex.EncodeToString(sum[:4])
+	k.keys[id] = key
+	return id, nil
+}
+
+// GenerateKey returns a new random master key, base64 encoded
+func GenerateKey() (string, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// IsSealed reports whether a value is sealed
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, prefix)
+}
+
+// Seal encrypts a value with a new data key. Empty values are returned as is,
+// as is everything when no master key is configured. Sealed values are
+// returned as is once they are known to open, so a client cannot store a
+// value that looks sealed but fails every backup.
+func Seal(value string) (string, error) {
+	if IsSealed(value) {
+		if err := Verify(value); err != nil {
+			return "", err
+		}
+		return value, nil
+	}
+	if keys == nil || value == "" {
+		return value, nil
+	}
+
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	ciphertext, err := encrypt(dataKey, []byte(value), nil)
+	if err != nil {
+		return "", err
+	}
+	return keys.wrap(dataKey, ciphertext)
+}
+
+// Verify checks that a sealed value opens with a configured key without
+// keeping its plaintext. Plaintext values always pass.
+func Verify(value string) error {
+	if _, err := Open(value); err != nil {
+		return fmt.Errorf("invalid sealed secret: %w", err)
+	}
+	return nil
+}
+
+// Open decrypts a sealed value. Plaintext values are returned as is.
+func Open(value string) (string, error) {
+	if !IsSealed(value) {
+		return value, nil
+	}
+
+	id, wrapped, ciphertext, err := parse(value)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := keys.unwrap(id, wrapped)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := decrypt(dataKey, ciphertext, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt secret: %w", err)
+	}
+	return string(plaintext), nil
+}
+
+// Reseal brings a value to the current master key: plaintext is sealed and
+// values sealed with a previous key get their data key rewrapped. It reports
+// whether the value changed.
+func Reseal(value string) (string, bool, error) {
+	if keys == nil {
+		return value, false, ErrNoKey
+	}
+	if value == "" {
+		return value, false, nil
+	}
+	if !IsSealed(value) {
+		sealed, err := Seal(value)
+		return sealed, err == nil, err
+	}
+
+	id, wrapped, ciphertext, err := parse(value)
+	if err != nil {
+		return "", false, err
+	}
+	if id == keys.current {
+		return value, false, nil
+	}
+	dataKey, err := keys.unwrap(id, wrapped)
+	if err != nil {
+		return "", false, err
+	}
+	resealed, err := keys.wrap(dataKey, ciphertext)
+	return resealed, err == nil, err
+}
+
+// wrap encrypts a data key with the current master key and formats the
+// sealed value. The key ID is authenticated with the wrapped key.
+func (k *Keyring) wrap(dataKey, ciphertext []byte) (string, error) {
+	wrapped, err := encrypt(k.keys[k.current], dataKey, []byte(k.current))
+	if err != nil {
+		return "", err
+	}
+	return prefix + k.current + ":" +
+		base64.RawURLEncoding.EncodeToString(wrapped) + ":" +
+		base64.RawURLEncoding.EncodeToString(ciphertext), nil
+}
+
+func (k *Keyring) unwrap(id string, wrapped []byte) ([]byte, error) {
+	if k == nil {
+		return nil, ErrNoKey
+	}
+	master, ok := k.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: secret is sealed with key %s, add it to SECRETS_PREVIOUS_KEYS", ErrNoKey, id)
+	}
+	dataKey, err := decrypt(master, wrapped, []byte(id))
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return dataKey, nil
+}
+
+func parse(value string) (id string, wrapped, ciphertext []byte, err error) {
+	parts := strings.Split(strings.TrimPrefix(value, prefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, fmt.Errorf("malformed sealed secret")
+	}
+	if wrapped, err = base64.RawURLEncoding.DecodeString(parts[1]); err != nil {
+		return "", nil, nil, fmt.Errorf("malformed sealed secret: %w", err)
+	}
+	if ciphertext, err = base64.RawURLEncoding.DecodeString(parts[2]); err != nil {
+		return "", nil, nil, fmt.Errorf("malformed sealed secret: %w", err)
+	}
+	return parts[0], wrapped, ciphertext, nil
+}
+
+// encrypt seals plaintext with AES-256-GCM, prefixing the random nonce
+func encrypt(key, plaintext, additional []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plaintext)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additional), nil
+}
+
+func decrypt(key, sealed, additional []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, additional)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -1,8 +1,10 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"time"
@@ -101,6 +103,57 @@ func (c *Client) Upload(ctx context.Context, filePath string, metadata UploadMet
 	return objectKey, nil
 }
 
+// Download writes an object from R2 to a local file
+func (c *Client) Download(ctx context.Context, objectKey, filePath string) error {
+	output, err := c.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(objectKey),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to download from R2: %w", err)
+	}
+	defer output.Body.Close()
+
+	file, err := os.Create(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, output.Body); err != nil {
+		return fmt.Errorf("failed to download from R2: %w", err)
+	}
+
+	return nil
+}
+
+// PutObject uploads in-memory content to R2 under key
+func (c *Client) PutObject(ctx context.Context, key string, data []byte) error {
+	_, err := c.s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(data),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload to R2: %w", err)
+	}
+
+	return nil
+}
+
+// GetObject opens an object in R2 for reading; the caller closes it
+func (c *Client) GetObject(ctx context.Context, key string) (io.ReadCloser, error) {
+	output, err := c.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download from R2: %w", err)
+	}
+
+	return output.Body, nil
+}
+
 // Delete deletes an object from R2
 func (c *Client) Delete(ctx context.Context, objectKey string) error {
 	_, err := c.s3Client.DeleteObject(ctx, &s3.DeleteObjectInput{
@@ -0,0 +1,300 @@
+package tunnel
+
+import (
+	"context"
+	"db-backup/internal/model"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// keepaliveInterval keeps idle bastion connections open during long dumps
+const keepaliveInterval = 30 * time.Second
+
+// Tunnel forwards a local port to a database through an SSH bastion host
+type Tunnel struct {
+	client    *ssh.Client
+	listener  net.Listener
+	agentConn net.Conn
+	target    string
+	done      chan struct{}
+	wg        sync.WaitGroup
+}
+
+// Open connects to the bastion of req.SSH and forwards a local port to the
+// database host of req. Requests without SSH settings get a nil tunnel, which
+// Rewrite and Close accept. Close the tunnel once the backup has finished.
+func Open(ctx context.Context, req model.BackupRequest) (*Tunnel, error) {
+	if req.SSH == nil {
+		return nil, nil
+	}
+	cfg := req.SSH
+
+	t := &Tunnel{
+		target: net.JoinHostPort(req.Host, req.Port),
+		done:   make(chan struct{}),
+	}
+
+	auth, err := t.authMethods(cfg)
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+
+	hostKeyCallback, err := hostKeyCallback(cfg)
+	if err != nil {
+		t.Close()
+		return nil, err
+	}
+
+	port := cfg.Port
+	if port == "" {
+		port = "22"
+	}
+	bastion := net.JoinHostPort(cfg.Host, port)
+
+	dialer := net.Dialer{Timeout: 30 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", bastion)
+	if err != nil {
+		t.Close()
+		return nil, fmt.Errorf("failed to reach ssh bastion %s: %w", bastion, err)
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, bastion, &ssh.ClientConfig{
+		User:            cfg.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         30 * time.Second,
+	})
+	if err != nil {
+		conn.Close()
+		t.Close()
+		return nil, fmt.Errorf("ssh handshake with %s failed: %w", bastion, err)
+	}
+	t.client = ssh.NewClient(sshConn, chans, reqs)
+
+	// Fail early when the bastion cannot reach the database
+	probe, err := t.client.Dial("tcp", t.target)
+	if err != nil {
+		t.Close()
+		return nil, fmt.Errorf("ssh bastion %s cannot reach %s: %w", bastion, t.target, err)
+	}
+	probe.Close()
+
+	t.listener, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Close()
+		return nil, fmt.Errorf("failed to open local forward: %w", err)
+	}
+
+	t.wg.Add(2)
+	go t.accept()
+	go t.keepalive()
+
+	log.Printf("Opened ssh tunnel to %s via %s on %s", t.target, bastion, t.listener.Addr())
+
+	return t, nil
+}
+
+// Rewrite returns a copy of req whose Host and Port point at the local end of
+// the tunnel. A nil tunnel returns req unchanged.
+func (t *Tunnel) Rewrite(req model.BackupRequest) model.BackupRequest {
+	if t == nil {
+		return req
+	}
+
+	// Certificates are still issued for the real host name
+	if req.TLS != nil && req.TLS.ServerName == "" {
+		tlsCfg := *req.TLS
+		tlsCfg.ServerName = req.Host
+		req.TLS = &tlsCfg
+	}
+
+	addr := t.listener.Addr().(*net.TCPAddr)
+	req.OriginalHost = req.Host
+	req.Host = "127.0.0.1"
+	req.Port = strconv.Itoa(addr.Port)
+
+	return req
+}
+
+// Close stops forwarding and disconnects from the bastion
+func (t *Tunnel) Close() error {
+	if t == nil {
+		return nil
+	}
+
+	close(t.done)
+	if t.listener != nil {
+		t.listener.Close()
+	}
+	var err error
+	if t.client != nil {
+		err = t.client.Close()
+	}
+	if t.agentConn != nil {
+		t.agentConn.Close()
+	}
+	t.wg.Wait()
+
+	return err
+}
+
+// accept forwards every local connection to the database until closed
+func (t *Tunnel) accept() {
+	defer t.wg.Done()
+
+	for {
+		local, err := t.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		t.wg.Add(1)
+		go func() {
+			defer t.wg.Done()
+			t.forward(local)
+		}()
+	}
+}
+
+func (t *Tunnel) forward(local net.Conn) {
+	defer local.Close()
+
+	remote, err := t.client.Dial("tcp", t.target)
+	if err != nil {
+		log.Printf("ssh tunnel failed to reach %s: %v", t.target, err)
+		return
+	}
+	defer remote.Close()
+
+	// Closing both ends once either side finishes unblocks the other copy
+	var once sync.Once
+	closeBoth := func() {
+		local.Close()
+		remote.Close()
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(remote, local)
+		once.Do(closeBoth)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(local, remote)
+		once.Do(closeBoth)
+	}()
+	wg.Wait()
+}
+
+func (t *Tunnel) keepalive() {
+	defer t.wg.Done()
+
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+			if _, _, err := t.client.SendRequest("keepalive@openssh.com", true, nil); err != nil {
+				log.Printf("ssh tunnel keepalive to %s failed: %v", t.target, err)
+				return
+			}
+		}
+	}
+}
+
+// authMethods returns the private key and agent authentication of cfg
+func (t *Tunnel) authMethods(cfg *model.SSHTunnel) ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+
+	if cfg.PrivateKey != "" {
+		var signer ssh.Signer
+		var err error
+		if cfg.Passphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase([]byte(cfg.PrivateKey), []byte(cfg.Passphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey([]byte(cfg.PrivateKey))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid ssh private key: %w", err)
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+
+	if cfg.UseAgent {
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, fmt.Errorf("ssh agent requested but SSH_AUTH_SOCK is not set")
+		}
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, fmt.Errorf("failed to connect to ssh agent: %w", err)
+		}
+		t.agentConn = conn
+		methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+
+	if len(methods) == 0 {
+		return nil, fmt.Errorf("ssh tunnel requires a private key or the ssh agent")
+	}
+
+	return methods, nil
+}
+
+// hostKeyCallback verifies the bastion against the configured known_hosts
+// lines, falling back to ~/.ssh/known_hosts of the service user
+func hostKeyCallback(cfg *model.SSHTunnel) (ssh.HostKeyCallback, error) {
+	if cfg.KnownHosts != "" {
+		// knownhosts only reads files; it parses them up front
+		file, err := os.CreateTemp("", "db-backup-known-hosts-")
+		if err != nil {
+			return nil, fmt.Errorf("failed to create known_hosts file: %w", err)
+		}
+		defer os.Remove(file.Name())
+
+		_, err = file.WriteString(cfg.KnownHosts)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to write known_hosts file: %w", err)
+		}
+
+		callback, err := knownhosts.New(file.Name())
+		if err != nil {
+			return nil, fmt.Errorf("invalid known_hosts: %w", err)
+		}
+		return callback, nil
+	}
+
+	if cfg.InsecureIgnoreHostKey {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+
+	if home, err := os.UserHomeDir(); err == nil {
+		path := filepath.Join(home, ".ssh", "known_hosts")
+		if _, err := os.Stat(path); err == nil {
+			callback, err := knownhosts.New(path)
+			if err != nil {
+				return nil, fmt.Errorf("invalid known_hosts: %w", err)
+			}
+			return callback, nil
+		}
+	}
+
+	return nil, fmt.Errorf("ssh tunnel requires knownHosts or insecureIgnoreHostKey")
+}
@@ -0,0 +1,231 @@
+package worker
+
+import (
+	"context"
+	"db-backup/internal/backup"
+	"db-backup/internal/dedup"
+	"db-backup/internal/model"
+	"db-backup/internal/secrets"
+	"db-backup/internal/storage"
+	"db-backup/internal/tunnel"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProcessDatabaseExport saves a pending record for a flat export of a saved
+// database, then dumps the database and exports the dump in the background.
+// The dump itself is not kept. It returns the ID of the new record.
+func ProcessDatabaseExport(db model.Database, format model.ExportFormat, tables []string) string {
+	req := db.BackupRequest()
+	req.Mode = model.ModeFull
+	req.Scope = req.Scope.OrDefault()
+	req.Split = false
+	req.Physical = false
+	req.Dedup = false
+	req.Subset = nil
+
+	timestamp := time.Now()
+	backupID := saveExportMetadata(&model.BackupMetadata{
+		Type:      string(req.Type),
+		Host:      req.Host,
+		Database:  req.Database,
+		Mode:      req.Mode,
+		Scope:     req.Scope,
+		Export:    format,
+		Timestamp: timestamp,
+	})
+	if backupID == "" {
+		return ""
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Hour)
+		defer cancel()
+
+		result := model.BackupResult{Timestamp: timestamp.Format(time.RFC3339)}
+		dumpPath, err := dumpForExport(ctx, backupID, req)
+		if err == nil {
+			result, err = runExport(ctx, backupID, req.Type, dumpPath, filepath.Base(dumpPath), format, tables, req.Host, req.Database, timestamp)
+			if removeErr := os.Remove(dumpPath); removeErr != nil {
+				log.Printf("Failed to delete local dump file: %v", removeErr)
+			}
+		}
+		if err != nil {
+			log.Printf("Export failed for %s (%s): %v", req.Type, req.Host, err)
+			updateBackupStatus(ctx, backupID, model.StatusFailed, err.Error())
+			result.Success = false
+			result.Error = err.Error()
+		}
+
+		notifyWebhook(db.WebhookURL, result)
+	}()
+
+	return backupID
+}
+
+// ProcessBackupExport saves a pending record for a flat export of a
+// completed backup and exports it in the background. It returns the ID of
+// the new record.
+func ProcessBackupExport(source *model.BackupMetadata, format model.ExportFormat, tables []string) string {
+	timestamp := time.Now()
+	backupID := saveExportMetadata(&model.BackupMetadata{
+		Type:      source.Type,
+		Host:      source.Host,
+		Database:  source.Database,
+		Mode:      source.Mode,
+		Scope:     source.Scope,
+		SourceID:  source.ID.Hex(),
+		Export:    format,
+		Timestamp: timestamp,
+	})
+	if backupID == "" {
+		return ""
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Hour)
+		defer cancel()
+
+		err := func() error {
+			srcPath, cleanup, err := dedup.Fetch(ctx, storageClient, source)
+			if err != nil {
+				return err
+			}
+			defer cleanup()
+
+			_, err = runExport(ctx, backupID, model.BackupType(source.Type), srcPath, sourceName(source), format, tables, source.Host, source.Database, timestamp)
+			return err
+		}()
+		if err != nil {
+			log.Printf("Export failed for backup %s: %v", source.ID.Hex(), err)
+			updateBackupStatus(ctx, backupID, model.StatusFailed, err.Error())
+		}
+	}()
+
+	return backupID
+}
+
+func saveExportMetadata(metadata *model.BackupMetadata) string {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	metadata.Status = model.StatusPending
+	metadata.CreatedAt = primitive.NewDateTimeFromTime(metadata.Timestamp)
+	if err := backupRepo.SaveBackup(ctx, metadata); err != nil {
+		log.Printf("Failed to save backup metadata: %v", err)
+		return ""
+	}
+	return metadata.ID.Hex()
+}
+
+// dumpForExport takes a full logical dump of a database to export
+func dumpForExport(ctx context.Context, backupID string, req model.BackupRequest) (string, error) {
+	log.Printf("Dumping %s (%s) for export", req.Type, req.Host)
+	updateBackupStatus(ctx, backupID, model.StatusGenerating, "")
+
+	strategy, err := backup.NewStrategy(req.Type)
+	if err != nil {
+		return "", err
+	}
+
+	req, err = secrets.OpenRequest(req)
+	if err != nil {
+		return "", err
+	}
+
+	tunnelCtx, cancel := context.WithTimeout(ctx, 1*time.Minute)
+	tun, err := tunnel.Open(tunnelCtx, req)
+	cancel()
+	if err != nil {
+		return "", fmt.Errorf("failed to open ssh tunnel: %w", err)
+	}
+	defer tun.Close()
+	req = tun.Rewrite(req)
+
+	// Compare the client tool with the server like a backup does, which also
+	// picks the client matching the server version
+	if checker, ok := strategy.(backup.VersionChecker); ok {
+		warning, err := checker.CheckVersion(ctx, req)
+		if err != nil {
+			return "", err
+		}
+		if warning != "" {
+			log.Printf("Version warning for %s (%s): %s", req.Type, req.Host, warning)
+			if err := backupRepo.UpdateBackupWarningByID(ctx, backupID, warning); err != nil {
+				log.Printf("Failed to update backup warning: %v", err)
+			}
+		}
+	}
+
+	return strategy.Backup(ctx, req)
+}
+
+// runExport exports an artifact, uploads the archive and records it. It
+// returns the webhook result.
+func runExport(ctx context.Context, backupID string, t model.BackupType, srcPath, name string, format model.ExportFormat, tables []string, host, database string, timestamp time.Time) (model.BackupResult, error) {
+	log.Printf("Exporting %s as %s", name, format)
+	updateBackupStatus(ctx, backupID, model.StatusGenerating, "")
+
+	result := model.BackupResult{
+		Timestamp: timestamp.Format(time.RFC3339),
+		Metadata:  make(map[string]string),
+	}
+
+	export, err := backup.Export(ctx, t, srcPath, name, format, tables)
+	if err != nil {
+		return result, err
+	}
+	log.Printf("Exported %s: %s, %d objects, %d rows", name, export.FilePath, export.Objects, export.Rows)
+
+	var fileSize int64
+	if fileInfo, err := os.Stat(export.FilePath); err == nil {
+		fileSize = fileInfo.Size()
+	}
+
+	var objectKey string
+	if storageClient != nil {
+		objectKey, err = storageClient.Upload(ctx, export.FilePath, storage.UploadMetadata{
+			DatabaseType: string(t),
+			Host:         host,
+			Database:     database,
+			Timestamp:    timestamp,
+			FileSize:     fileSize,
+		})
+		if err != nil {
+			log.Printf("Failed to upload to R2: %v", err)
+			result.Metadata["upload_error"] = err.Error()
+			objectKey = ""
+		} else {
+			result.Metadata["storage"] = "r2"
+			log.Printf("Uploaded to R2: %s", objectKey)
+		}
+	}
+
+	filePath := export.FilePath
+	if objectKey != "" {
+		if err := os.Remove(filePath); err != nil {
+			log.Printf("Failed to delete local export file: %v", err)
+		} else {
+			filePath = ""
+		}
+	}
+	updateBackupMetadata(ctx, backupID, filePath, objectKey, fileSize, model.StatusCompleted, "")
+
+	result.Success = true
+	result.FilePath = export.FilePath
+	result.ObjectKey = objectKey
+	result.Metadata["database_type"] = string(t)
+	result.Metadata["host"] = host
+	result.Metadata["database"] = database
+	result.Metadata["export"] = string(format)
+	result.Metadata["objects"] = strconv.Itoa(export.Objects)
+	result.Metadata["rows"] = strconv.FormatInt(export.Rows, 10)
+	result.Metadata["file_size"] = strconv.FormatInt(fileSize, 10)
+	return result, nil
+}
@@ -0,0 +1,126 @@
+package worker
+
+import (
+	"context"
+	"db-backup/internal/backup"
+	"db-backup/internal/dedup"
+	"db-backup/internal/model"
+	"db-backup/internal/storage"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// ProcessSanitize saves a pending record for a sanitized copy of source and
+// produces the copy in the background. It returns the ID of the new record.
+func ProcessSanitize(source *model.BackupMetadata, profile model.MaskingProfile) string {
+	timestamp := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	metadata := &model.BackupMetadata{
+		Type:           source.Type,
+		Timestamp:      timestamp,
+		Status:         model.StatusPending,
+		Host:           source.Host,
+		Database:       source.Database,
+		Mode:           source.Mode,
+		Scope:          source.Scope,
+		SourceID:       source.ID.Hex(),
+		MaskingProfile: profile.Name,
+		CreatedAt:      primitive.NewDateTimeFromTime(timestamp),
+	}
+	if err := backupRepo.SaveBackup(ctx, metadata); err != nil {
+		cancel()
+		log.Printf("Failed to save backup metadata: %v", err)
+		return ""
+	}
+	cancel()
+	backupID := metadata.ID.Hex()
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Hour)
+		defer cancel()
+
+		if err := runSanitize(ctx, backupID, source, profile, timestamp); err != nil {
+			log.Printf("Sanitize failed for backup %s: %v", source.ID.Hex(), err)
+			updateBackupStatus(ctx, backupID, model.StatusFailed, err.Error())
+		}
+	}()
+
+	return backupID
+}
+
+// runSanitize masks the source artifact, uploads the copy and records it
+func runSanitize(ctx context.Context, backupID string, source *model.BackupMetadata, profile model.MaskingProfile, timestamp time.Time) error {
+	log.Printf("Sanitizing backup %s with profile %s", source.ID.Hex(), profile.Name)
+	updateBackupStatus(ctx, backupID, model.StatusGenerating, "")
+
+	srcPath, cleanup, err := dedup.Fetch(ctx, storageClient, source)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	result, err := backup.Sanitize(ctx, model.BackupType(source.Type), srcPath, sourceName(source), profile)
+	if err != nil {
+		return err
+	}
+	log.Printf("Sanitized backup %s: %s, %d values masked", source.ID.Hex(), result.FilePath, result.Values)
+
+	if len(result.Unmatched) > 0 {
+		warning := "rules matched no column or field: " + strings.Join(result.Unmatched, ", ")
+		if err := backupRepo.UpdateBackupWarningByID(ctx, backupID, warning); err != nil {
+			log.Printf("Failed to update backup warning: %v", err)
+		}
+	}
+
+	var fileSize int64
+	if fileInfo, err := os.Stat(result.FilePath); err == nil {
+		fileSize = fileInfo.Size()
+	}
+
+	var objectKey string
+	if storageClient != nil {
+		objectKey, err = storageClient.Upload(ctx, result.FilePath, storage.UploadMetadata{
+			DatabaseType: source.Type,
+			Host:         source.Host,
+			Database:     source.Database,
+			Timestamp:    timestamp,
+			FileSize:     fileSize,
+		})
+		if err != nil {
+			log.Printf("Failed to upload to R2: %v", err)
+			objectKey = ""
+		} else {
+			log.Printf("Uploaded to R2: %s", objectKey)
+		}
+	}
+
+	filePath := result.FilePath
+	if objectKey != "" {
+		if err := os.Remove(filePath); err != nil {
+			log.Printf("Failed to delete local backup file: %v", err)
+		} else {
+			filePath = ""
+		}
+	}
+	updateBackupMetadata(ctx, backupID, filePath, objectKey, fileSize, model.StatusCompleted, "")
+	return nil
+}
+
+// sourceName returns the file name of a backup artifact, which copies
+// derived from the backup are named after
+func sourceName(source *model.BackupMetadata) string {
+	if source.FilePath != "" {
+		return filepath.Base(source.FilePath)
+	}
+	if source.Dedup != nil {
+		return dedup.FileName(source.ObjectKey)
+	}
+	return path.Base(source.ObjectKey)
+}
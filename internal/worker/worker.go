@@ -5,12 +5,19 @@ import (
 	"context"
 	"db-backup/internal/backup"
 	"db-backup/internal/database"
+	"db-backup/internal/dedup"
+	"db-backup/internal/hooks"
 	"db-backup/internal/model"
+	"db-backup/internal/secrets"
 	"db-backup/internal/storage"
+	"db-backup/internal/tunnel"
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -40,6 +47,8 @@ func InitializeWorker() error {
 
 func ProcessBackup(req model.BackupRequest) string {
 	timestamp := time.Now()
+	req.Mode = req.Mode.OrDefault()
+	req.Scope = req.Scope.OrDefault()
 
 	// Create a context for initial save
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
@@ -47,114 +56,445 @@ func ProcessBackup(req model.BackupRequest) string {
 	// Save initial pending status and get the ID
 	var backupID string
 	if backupRepo != nil {
-		backupID = saveBackupMetadata(ctx, req, "", "", 0, model.StatusPending, "", timestamp)
+		backupID = saveBackupMetadata(ctx, req, "", "", 0, model.StatusPending, "", timestamp, "")
 	}
 	cancel()
 
 	// Run the actual backup in a goroutine
 	go func() {
-		// Create a context with timeout, e.g. 1 hour max for backup
-		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Hour)
-		defer cancel()
+		// Stored credentials are sealed and only opened for the run
+		req, err := secrets.OpenRequest(req)
+		if err != nil {
+			log.Printf("Failed to open credentials for %s (%s): %v", req.Type, req.Host, err)
+			failBackup(backupID, req.WebhookURL, timestamp, err)
+			return
+		}
+
+		// One tunnel serves the whole run, including every database of a
+		// split server backup
+		tunnelCtx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+		tun, err := tunnel.Open(tunnelCtx, req)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to open ssh tunnel for %s (%s): %v", req.Type, req.Host, err)
+			failBackup(backupID, req.WebhookURL, timestamp, err)
+			return
+		}
+		defer tun.Close()
+
+		var result model.BackupResult
+		if req.Scope == model.ScopeServer && req.Split {
+			result = runServerBackup(backupID, req, timestamp, tun)
+		} else {
+			// Create a context with timeout, e.g. 1 hour max for backup
+			ctx, cancel := context.WithTimeout(context.Background(), 1*time.Hour)
+			defer cancel()
+
+			result, _ = runBackup(ctx, backupID, req, timestamp, tun)
+		}
+
+		notifyWebhook(req.WebhookURL, result)
+	}()
+
+	return backupID
+}
 
-		log.Printf("Starting backup for %s (%s)", req.Type, req.Host)
+// failBackup records a backup that could not start and notifies the webhook
+func failBackup(backupID, webhookURL string, timestamp time.Time, err error) {
+	if backupRepo != nil && backupID != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		updateBackupStatus(ctx, backupID, model.StatusFailed, err.Error())
+		cancel()
+	}
+	notifyWebhook(webhookURL, model.BackupResult{
+		Success:   false,
+		Error:     err.Error(),
+		Timestamp: timestamp.Format(time.RFC3339),
+	})
+}
+
+// TestConnection opens the credentials of a request and tests the
+// connection through its ssh tunnel, if any
+func TestConnection(ctx context.Context, req model.BackupRequest) model.ConnectionTestResult {
+	req, err := secrets.OpenRequest(req)
+	if err != nil {
+		return model.ConnectionTestResult{Error: err.Error()}
+	}
+
+	tun, err := tunnel.Open(ctx, req)
+	if err != nil {
+		return model.ConnectionTestResult{Error: err.Error()}
+	}
+	defer tun.Close()
+
+	return backup.TestConnection(ctx, tun.Rewrite(req))
+}
+
+// runBackup runs a single backup, uploads it and records the outcome. It
+// returns the webhook result and the size of the backup file. The strategy
+// connects through tun when the database sits behind an SSH bastion.
+func runBackup(ctx context.Context, backupID string, req model.BackupRequest, timestamp time.Time, tun *tunnel.Tunnel) (model.BackupResult, int64) {
+	log.Printf("Starting backup for %s (%s)", req.Type, req.Host)
+
+	strategy, err := backup.NewStrategy(req.Type)
+	if err != nil {
+		log.Printf("Failed to create strategy: %v", err)
+
+		// Update to failed status
+		if backupRepo != nil && backupID != "" {
+			updateBackupStatus(ctx, backupID, model.StatusFailed, err.Error())
+		}
+		return model.BackupResult{
+			Success: false,
+			Error:   err.Error(),
+		}, 0
+	}
 
-		strategy, err := backup.NewStrategy(req.Type)
+	// Update to generating status
+	if backupRepo != nil && backupID != "" {
+		updateBackupStatus(ctx, backupID, model.StatusGenerating, "")
+	}
+
+	// Compare the client tool with the server before dumping
+	var warning string
+	if checker, ok := strategy.(backup.VersionChecker); ok {
+		warning, err = checker.CheckVersion(ctx, tun.Rewrite(req))
 		if err != nil {
-			notifyWebhook(req.WebhookURL, model.BackupResult{
-				Success: false,
-				Error:   err.Error(),
-			})
-			log.Printf("Failed to create strategy: %v", err)
+			log.Printf("Version check failed for %s: %v", req.Type, err)
 
-			// Update to failed status
 			if backupRepo != nil && backupID != "" {
 				updateBackupStatus(ctx, backupID, model.StatusFailed, err.Error())
 			}
-			return
+			return model.BackupResult{
+				Success:   false,
+				Error:     err.Error(),
+				Timestamp: timestamp.Format(time.RFC3339),
+			}, 0
 		}
 
-		// Update to generating status
-		if backupRepo != nil && backupID != "" {
-			updateBackupStatus(ctx, backupID, model.StatusGenerating, "")
+		if warning != "" {
+			log.Printf("Version warning for %s (%s): %s", req.Type, req.Host, warning)
+
+			if backupRepo != nil && backupID != "" {
+				if err := backupRepo.UpdateBackupWarningByID(ctx, backupID, warning); err != nil {
+					log.Printf("Failed to update backup warning: %v", err)
+				}
+			}
 		}
+	}
 
-		filePath, err := strategy.Backup(ctx, req)
-		result := model.BackupResult{
-			Success:   err == nil,
-			FilePath:  filePath,
+	vars := hookVars(backupID, req)
+	if err := runHooks(ctx, backupID, req.Hooks, model.HookPreBackup, vars); err != nil {
+		err = fmt.Errorf("pre-backup hook failed: %w", err)
+		log.Printf("Backup aborted for %s: %v", req.Type, err)
+
+		// Post-backup hooks still run to undo what earlier hooks did
+		vars.Status = model.StatusFailed
+		vars.Error = err.Error()
+		runHooks(ctx, backupID, req.Hooks, model.HookPostBackup, vars)
+
+		if backupRepo != nil && backupID != "" {
+			updateBackupStatus(ctx, backupID, model.StatusFailed, err.Error())
+		}
+		return model.BackupResult{
+			Success:   false,
+			Error:     err.Error(),
 			Timestamp: timestamp.Format(time.RFC3339),
-			Metadata:  make(map[string]string),
+		}, 0
+	}
+
+	filePath, err := strategy.Backup(ctx, tun.Rewrite(req))
+
+	vars.File = filePath
+	vars.Status = model.StatusCompleted
+	if err != nil {
+		vars.Status = model.StatusFailed
+		vars.Error = err.Error()
+	} else if fileInfo, statErr := os.Stat(filePath); statErr == nil {
+		vars.FileSize = fileInfo.Size()
+	}
+	runHooks(ctx, backupID, req.Hooks, model.HookPostBackup, vars)
+
+	result := model.BackupResult{
+		Success:   err == nil,
+		FilePath:  filePath,
+		Timestamp: timestamp.Format(time.RFC3339),
+		Metadata:  make(map[string]string),
+	}
+	if warning != "" {
+		result.Metadata["warning"] = warning
+	}
+
+	if err != nil {
+		result.Error = err.Error()
+		log.Printf("Backup failed for %s: %v", req.Type, err)
+
+		// Update to failed status
+		if backupRepo != nil && backupID != "" {
+			updateBackupStatus(ctx, backupID, model.StatusFailed, err.Error())
 		}
+		return result, 0
+	}
+
+	log.Printf("Backup completed for %s: %s", req.Type, filePath)
+
+	// Get file size
+	fileInfo, _ := os.Stat(filePath)
+	var fileSize int64
+	if fileInfo != nil {
+		fileSize = fileInfo.Size()
+	}
 
+	// Upload to R2 if configured. Deduplicated backups need the chunk
+	// records, so they fall back to a plain upload without a backup record.
+	var objectKey string
+	if storageClient != nil && req.Dedup && backupID != "" {
+		indexKey := dedup.IndexKey(string(req.Type), filePath)
+		info, err := dedup.Upload(ctx, storageClient, backupRepo, filePath, indexKey)
 		if err != nil {
-			result.Error = err.Error()
-			log.Printf("Backup failed for %s: %v", req.Type, err)
+			log.Printf("Failed to upload chunks to R2: %v", err)
+			result.Metadata["upload_error"] = err.Error()
+		} else {
+			objectKey = indexKey
+			result.ObjectKey = objectKey
+			result.Metadata["storage"] = "r2"
+			result.Metadata["dedup_chunks"] = strconv.Itoa(info.Chunks)
+			result.Metadata["dedup_new_chunks"] = strconv.Itoa(info.NewChunks)
+			result.Metadata["stored_size"] = strconv.FormatInt(info.StoredSize, 10)
+			log.Printf("Uploaded %s to R2: %d chunks, %d new, %d bytes stored", indexKey, info.Chunks, info.NewChunks, info.StoredSize)
 
-			// Update to failed status
-			if backupRepo != nil && backupID != "" {
-				updateBackupStatus(ctx, backupID, model.StatusFailed, err.Error())
+			if err := backupRepo.UpdateBackupDedupByID(ctx, backupID, *info); err != nil {
+				log.Printf("Failed to update backup dedup: %v", err)
 			}
+		}
+	} else if storageClient != nil {
+		objectKey, err = storageClient.Upload(ctx, filePath, storage.UploadMetadata{
+			DatabaseType: string(req.Type),
+			Host:         req.Host,
+			Database:     req.Database,
+			Timestamp:    timestamp,
+			FileSize:     fileSize,
+		})
+
+		if err != nil {
+			log.Printf("Failed to upload to R2: %v", err)
+			result.Metadata["upload_error"] = err.Error()
 		} else {
-			log.Printf("Backup completed for %s: %s", req.Type, filePath)
+			result.ObjectKey = objectKey
+			result.Metadata["storage"] = "r2"
+			log.Printf("Uploaded to R2: %s", objectKey)
+		}
+	}
 
-			// Get file size
-			fileInfo, _ := os.Stat(filePath)
-			var fileSize int64
-			if fileInfo != nil {
-				fileSize = fileInfo.Size()
+	// Update backup metadata to completed
+	if backupRepo != nil && backupID != "" {
+		dbFilePath := filePath
+		if objectKey != "" {
+			// If uploaded successfully, delete local file and clear filePath in DB
+			if err := os.Remove(filePath); err != nil {
+				log.Printf("Failed to delete local backup file: %v", err)
+			} else {
+				log.Printf("Deleted local backup file: %s", filePath)
+				dbFilePath = "" // Clear in DB
 			}
+		}
+		updateBackupMetadata(ctx, backupID, dbFilePath, objectKey, fileSize, model.StatusCompleted, "")
+	}
 
-			// Upload to R2 if configured
-			var objectKey string
-			if storageClient != nil {
-				objectKey, err = storageClient.Upload(ctx, filePath, storage.UploadMetadata{
-					DatabaseType: string(req.Type),
-					Host:         req.Host,
-					Database:     req.Database,
-					Timestamp:    timestamp,
-					FileSize:     fileSize,
-				})
-
-				if err != nil {
-					log.Printf("Failed to upload to R2: %v", err)
-					result.Metadata["upload_error"] = err.Error()
-				} else {
-					result.ObjectKey = objectKey
-					result.Metadata["storage"] = "r2"
-					log.Printf("Uploaded to R2: %s", objectKey)
-				}
+	vars.ObjectKey = objectKey
+	runHooks(ctx, backupID, req.Hooks, model.HookPostUpload, vars)
+
+	// Add metadata
+	result.Metadata["database_type"] = string(req.Type)
+	result.Metadata["host"] = req.Host
+	result.Metadata["database"] = req.Database
+	result.Metadata["mode"] = string(req.Mode)
+	result.Metadata["scope"] = string(req.Scope)
+	result.Metadata["file_size"] = strconv.FormatInt(fileSize, 10)
+
+	return result, fileSize
+}
+
+// hookVars returns the details of a backup that hooks can use
+func hookVars(backupID string, req model.BackupRequest) hooks.Vars {
+	return hooks.Vars{
+		ID:       backupID,
+		Type:     req.Type,
+		Host:     req.Host,
+		Port:     req.Port,
+		Database: req.Database,
+		Mode:     req.Mode,
+		Scope:    req.Scope,
+	}
+}
+
+// runHooks runs the hooks of a stage in order and records each outcome on the
+// backup. Pre-backup hooks stop at the first failure, which is returned; later
+// stages run every hook since the backup itself is already decided.
+func runHooks(ctx context.Context, backupID string, list []model.Hook, stage model.HookStage, vars hooks.Vars) error {
+	var firstErr error
+	for _, hook := range list {
+		if hook.Stage != stage {
+			continue
+		}
+
+		result := hooks.Run(ctx, hook, vars)
+		if result.Success {
+			log.Printf("Hook %q (%s) succeeded in %dms", hook.Name, stage, result.DurationMs)
+		} else {
+			log.Printf("Hook %q (%s) failed: %s", hook.Name, stage, result.Error)
+		}
+
+		if backupRepo != nil && backupID != "" {
+			if err := backupRepo.AddBackupHookResultByID(ctx, backupID, result); err != nil {
+				log.Printf("Failed to record hook result: %v", err)
 			}
+		}
 
-			// Update backup metadata to completed
-			if backupRepo != nil && backupID != "" {
-				dbFilePath := filePath
-				if objectKey != "" {
-					// If uploaded successfully, delete local file and clear filePath in DB
-					if err := os.Remove(filePath); err != nil {
-						log.Printf("Failed to delete local backup file: %v", err)
-					} else {
-						log.Printf("Deleted local backup file: %s", filePath)
-						dbFilePath = "" // Clear in DB
-					}
-				}
-				updateBackupMetadata(ctx, backupID, dbFilePath, objectKey, fileSize, model.StatusCompleted, "")
+		if !result.Success && firstErr == nil {
+			firstErr = fmt.Errorf("%s: %s", hook.Name, result.Error)
+			if stage == model.HookPreBackup {
+				return firstErr
 			}
+		}
+	}
+
+	return firstErr
+}
 
-			// Add metadata
-			result.Metadata["database_type"] = string(req.Type)
-			result.Metadata["host"] = req.Host
-			result.Metadata["database"] = req.Database
-			result.Metadata["file_size"] = string(rune(fileSize))
+// runServerBackup discovers every database on the server and backs each one
+// up as a child of the parent backup record. The parent ends up completed only
+// when every child completed.
+func runServerBackup(parentID string, req model.BackupRequest, timestamp time.Time, tun *tunnel.Tunnel) model.BackupResult {
+	result := model.BackupResult{
+		Timestamp: timestamp.Format(time.RFC3339),
+		Metadata:  make(map[string]string),
+	}
+
+	fail := func(err error) model.BackupResult {
+		log.Printf("Server backup failed for %s (%s): %v", req.Type, req.Host, err)
+		if backupRepo != nil && parentID != "" {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			updateBackupStatus(ctx, parentID, model.StatusFailed, err.Error())
 		}
+		result.Success = false
+		result.Error = err.Error()
+		return result
+	}
 
-		notifyWebhook(req.WebhookURL, result)
-	}()
+	strategy, err := backup.NewStrategy(req.Type)
+	if err != nil {
+		return fail(err)
+	}
 
-	return backupID
+	lister, ok := strategy.(backup.DatabaseLister)
+	if !ok {
+		return fail(fmt.Errorf("per-database server backups are not supported for %s", req.Type))
+	}
+
+	if backupRepo != nil && parentID != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		updateBackupStatus(ctx, parentID, model.StatusGenerating, "")
+		cancel()
+	}
+
+	listCtx, cancel := context.WithTimeout(context.Background(), 1*time.Minute)
+	names, err := lister.ListDatabases(listCtx, tun.Rewrite(req))
+	cancel()
+	if err != nil {
+		return fail(err)
+	}
+	if len(names) == 0 {
+		return fail(fmt.Errorf("no databases found on %s", req.Host))
+	}
+
+	log.Printf("Starting server backup for %s (%s): %d databases", req.Type, req.Host, len(names))
+
+	if backupRepo != nil && parentID != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		if err := backupRepo.UpdateBackupChildrenByID(ctx, parentID, len(names)); err != nil {
+			log.Printf("Failed to update backup children: %v", err)
+		}
+		cancel()
+	}
+
+	// Hooks run once around the whole server backup, not per database
+	vars := hookVars(parentID, req)
+	if err := runHooks(context.Background(), parentID, req.Hooks, model.HookPreBackup, vars); err != nil {
+		err = fmt.Errorf("pre-backup hook failed: %w", err)
+		vars.Status = model.StatusFailed
+		vars.Error = err.Error()
+		runHooks(context.Background(), parentID, req.Hooks, model.HookPostBackup, vars)
+		return fail(err)
+	}
+
+	var failed []string
+	var totalSize int64
+	for _, name := range names {
+		child := req
+		child.Scope = model.ScopeDatabase
+		child.Split = false
+		child.Database = name
+		child.Hooks = nil
+
+		childTimestamp := time.Now()
+
+		// Each database gets the same time budget as a standalone backup
+		ctx, cancel := context.WithTimeout(context.Background(), 1*time.Hour)
+
+		var childID string
+		if backupRepo != nil {
+			childID = saveBackupMetadata(ctx, child, "", "", 0, model.StatusPending, "", childTimestamp, parentID)
+		}
+
+		childResult, size := runBackup(ctx, childID, child, childTimestamp, tun)
+		cancel()
+
+		if !childResult.Success {
+			failed = append(failed, name)
+		}
+		totalSize += size
+	}
+
+	status := model.StatusCompleted
+	var errorMsg string
+	if len(failed) > 0 {
+		status = model.StatusFailed
+		errorMsg = fmt.Sprintf("%d of %d database backups failed: %s", len(failed), len(names), strings.Join(failed, ", "))
+	}
+
+	vars.Status = status
+	vars.Error = errorMsg
+	vars.FileSize = totalSize
+	runHooks(context.Background(), parentID, req.Hooks, model.HookPostBackup, vars)
+
+	if backupRepo != nil && parentID != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		updateBackupMetadata(ctx, parentID, "", "", totalSize, status, errorMsg)
+		cancel()
+	}
+
+	if status == model.StatusCompleted {
+		runHooks(context.Background(), parentID, req.Hooks, model.HookPostUpload, vars)
+	}
+
+	log.Printf("Server backup finished for %s (%s): %d/%d databases succeeded", req.Type, req.Host, len(names)-len(failed), len(names))
+
+	result.Success = len(failed) == 0
+	result.Error = errorMsg
+	result.Metadata["database_type"] = string(req.Type)
+	result.Metadata["host"] = req.Host
+	result.Metadata["mode"] = string(req.Mode)
+	result.Metadata["scope"] = string(req.Scope)
+	result.Metadata["children"] = strconv.Itoa(len(names))
+	result.Metadata["failed_children"] = strconv.Itoa(len(failed))
+	result.Metadata["file_size"] = strconv.FormatInt(totalSize, 10)
+
+	return result
 }
 
-func saveBackupMetadata(ctx context.Context, req model.BackupRequest, filePath, objectKey string, fileSize int64, status model.BackupStatus, errorMsg string, timestamp time.Time) string {
+func saveBackupMetadata(ctx context.Context, req model.BackupRequest, filePath, objectKey string, fileSize int64, status model.BackupStatus, errorMsg string, timestamp time.Time, parentID string) string {
 	metadata := &model.BackupMetadata{
 		Type:      string(req.Type),
 		ObjectKey: objectKey,
@@ -165,6 +505,9 @@ func saveBackupMetadata(ctx context.Context, req model.BackupRequest, filePath,
 		Error:     errorMsg,
 		Host:      req.Host,
 		Database:  req.Database,
+		Mode:      req.Mode,
+		Scope:     req.Scope,
+		ParentID:  parentID,
 		CreatedAt: primitive.NewDateTimeFromTime(timestamp),
 	}
 